@@ -37,14 +37,13 @@ func main() {
 		os.Exit(1)
 	}
 
-	for _, key := range []string{"DB_USER", "DB_PASSWORD", "DB_NAME"} {
-		if _, ok := env[key]; !ok {
-			fmt.Fprintf(os.Stderr, "error: %s not set in %s\n", key, *envFile)
-			os.Exit(1)
-		}
+	dbConfig, err := sptt.DBConfigFromEnv(env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v in %s\n", err, *envFile)
+		os.Exit(1)
 	}
 
-	db, err := sptt.NewDB(env["DB_USER"], env["DB_PASSWORD"], env["DB_NAME"])
+	db, err := sptt.NewStore(dbConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error connecting to database: %v\n", err)
 		os.Exit(1)
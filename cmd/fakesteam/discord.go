@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+)
+
+// Messages kept for GET /_fake/discord
+const discordKeep = 100
+
+// discordStandIn accepts Discord webhook messages, point the notifier at it
+// with DISCORD_WEBHOOK_URL=http://localhost:8090/_fake/discord.
+type discordStandIn struct {
+	mu       sync.Mutex
+	messages []json.RawMessage
+}
+
+func (d *discordStandIn) handlePost(rw http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var msg struct {
+		Content string `json:"content"`
+		Embeds  []struct {
+			Description string `json:"description"`
+		} `json:"embeds"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		http.Error(rw, `{"message": "Cannot send an empty message", "code": 50006}`, http.StatusBadRequest)
+		return
+	}
+
+	if msg.Content != "" {
+		log.Infof("Discord: %s", msg.Content)
+	}
+	for _, e := range msg.Embeds {
+		log.Infof("Discord: %s", e.Description)
+	}
+
+	d.mu.Lock()
+	d.messages = append(d.messages, body)
+	if len(d.messages) > discordKeep {
+		d.messages = d.messages[len(d.messages)-discordKeep:]
+	}
+	d.mu.Unlock()
+
+	// Like Discord without ?wait=true
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (d *discordStandIn) handleGet(rw http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	writeJSON(rw, map[string]interface{}{"messages": append([]json.RawMessage{}, d.messages...)})
+}
@@ -0,0 +1,355 @@
+// fakesteam serves a scriptable stand-in for the parts of the Steam Web API
+// and store API the tracker uses, so the whole tracker can be run end to end
+// without a Steam API key.
+//
+// The state of the fake users is read from a JSON or YAML scenario file and
+// changes over time as the scenario's events become due. Point the tracker
+// at it by setting STEAM_API_BASE_URL and STEAM_STORE_BASE_URL in .env.
+//
+// Usage:
+//
+//	go run ./cmd/fakesteam --scenario=cmd/fakesteam/scenarios/example.yaml --addr=:8090
+//
+// Besides the Steam endpoints, GET /_fake/state dumps the current state and
+// POST /_fake/reset restarts the scenario. POST /_fake/discord stands in
+// for a Discord webhook, messages are logged and listed by GET /_fake/discord.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+func main() {
+	scenarioFile := flag.String("scenario", "", "path to the scenario file, .json or .yaml (required)")
+	addr := flag.String("addr", ":8090", "address to listen on")
+	apiKey := flag.String("key", "", "API key to require, any key is accepted if empty")
+	speed := flag.Float64("speed", 0, "override the scenario speed")
+	logLevel := flag.String("log", "info", "log level")
+	flag.Parse()
+
+	log.SetLevelFromString(*logLevel)
+
+	if *scenarioFile == "" {
+		fmt.Fprintln(os.Stderr, "error: --scenario is required")
+		os.Exit(1)
+	}
+
+	sc, err := LoadScenario(*scenarioFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading scenario: %v\n", err)
+		os.Exit(1)
+	}
+	if *speed > 0 {
+		sc.Speed = *speed
+	}
+
+	w := NewWorld(sc, time.Now)
+
+	log.Infof("Fake Steam API listening on %s with %d users and %d events (speed %vx)", *addr, len(sc.Users), len(sc.Events), sc.Speed)
+	if err := http.ListenAndServe(*addr, NewHandler(w, *apiKey)); err != nil {
+		log.Fatal(err)
+		os.Exit(1)
+	}
+}
+
+// NewHandler returns the http handler serving the fake endpoints for w.
+func NewHandler(w *World, apiKey string) http.Handler {
+	mux := http.NewServeMux()
+
+	requireKey := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			if apiKey != "" && r.URL.Query().Get("key") != apiKey {
+				http.Error(rw, "<html><body>Forbidden</body></html>", http.StatusForbidden)
+				return
+			}
+			h(rw, r)
+		}
+	}
+
+	mux.HandleFunc("GET /ISteamUser/GetPlayerSummaries/v2/", requireKey(w.handlePlayerSummaries))
+	mux.HandleFunc("GET /ISteamUser/ResolveVanityURL/v1/", requireKey(w.handleResolveVanityURL))
+	mux.HandleFunc("GET /IPlayerService/GetOwnedGames/v1/", requireKey(w.handleOwnedGames))
+	mux.HandleFunc("GET /IPlayerService/GetRecentlyPlayedGames/v1/", requireKey(w.handleRecentlyPlayedGames))
+	mux.HandleFunc("GET /api/appdetails", w.handleAppDetails)
+	mux.HandleFunc("GET /_fake/state", w.handleState)
+
+	discord := &discordStandIn{}
+	mux.HandleFunc("POST /_fake/discord", discord.handlePost)
+	mux.HandleFunc("GET /_fake/discord", discord.handleGet)
+	mux.HandleFunc("POST /_fake/reset", func(rw http.ResponseWriter, r *http.Request) {
+		w.Reset()
+		log.Info("Scenario reset")
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Error("Error writing response: ", err)
+	}
+}
+
+func (w *World) handlePlayerSummaries(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance()
+
+	players := []sptt.PlayerSummary{}
+	for _, raw := range strings.Split(r.URL.Query().Get("steamids"), ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			continue
+		}
+		u, ok := w.users[sptt.SteamID(id)]
+		if !ok {
+			// Steam silently drops unknown steamids
+			continue
+		}
+
+		summary := sptt.PlayerSummary{
+			SteamID:      u.SteamID,
+			Visibility:   3,
+			Profilestate: 1,
+			Personaname:  u.Name,
+			Profileurl:   fmt.Sprintf("https://steamcommunity.com/profiles/%d/", id),
+		}
+		if u.Private {
+			summary.Visibility = 1
+		} else if u.InGame != nil {
+			appid := *u.InGame
+			name := w.appName(appid)
+			summary.GameID = &appid
+			summary.Gameextrainfo = &name
+			summary.PersonaState = 1
+		}
+		players = append(players, summary)
+	}
+
+	writeJSON(rw, map[string]interface{}{"response": map[string]interface{}{"players": players}})
+}
+
+func (w *World) handleResolveVanityURL(rw http.ResponseWriter, r *http.Request) {
+	vanity := r.URL.Query().Get("vanityurl")
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, u := range w.users {
+		if u.Vanity != "" && strings.EqualFold(u.Vanity, vanity) {
+			writeJSON(rw, map[string]interface{}{"response": map[string]interface{}{
+				"steamid": strconv.FormatUint(uint64(u.SteamID), 10),
+				"success": 1,
+			}})
+			return
+		}
+	}
+	writeJSON(rw, map[string]interface{}{"response": map[string]interface{}{"success": 42, "message": "No match"}})
+}
+
+func (w *World) handleOwnedGames(rw http.ResponseWriter, r *http.Request) {
+	var input struct {
+		SteamID        sptt.SteamID `json:"steamid"`
+		IncludeAppInfo bool         `json:"include_appinfo"`
+		Appids         []sptt.AppID `json:"appids_filter"`
+	}
+	if raw := r.URL.Query().Get("input_json"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &input); err != nil {
+			http.Error(rw, "bad input_json", http.StatusBadRequest)
+			return
+		}
+	} else if id, err := strconv.ParseUint(r.URL.Query().Get("steamid"), 10, 64); err == nil {
+		input.SteamID = sptt.SteamID(id)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	elapsed := w.advance()
+
+	u, ok := w.users[input.SteamID]
+	if !ok || u.Private || u.OwnedGames == OwnedGamesPrivate {
+		writeJSON(rw, map[string]interface{}{"response": map[string]interface{}{}})
+		return
+	}
+	switch u.OwnedGames {
+	case OwnedGamesEmptyResponse:
+		writeJSON(rw, map[string]interface{}{})
+		return
+	case OwnedGamesError:
+		http.Error(rw, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	filter := make(map[sptt.AppID]bool, len(input.Appids))
+	for _, appid := range input.Appids {
+		filter[appid] = true
+	}
+
+	games := []sptt.GameInfo{}
+	for _, g := range w.sortedGames(u) {
+		if len(filter) > 0 && !filter[g.AppID] {
+			continue
+		}
+		view := w.gameView(u, g, elapsed)
+		info := sptt.GameInfo{
+			AppID:    view.AppID,
+			Playtime: view.PlaytimeForever,
+		}
+		if input.IncludeAppInfo {
+			info.Name = w.appName(view.AppID)
+		}
+		if view.Playtime2Weeks > 0 {
+			info.Playtime2Weeks = &view.Playtime2Weeks
+		}
+		games = append(games, info)
+	}
+
+	resp := map[string]interface{}{"game_count": len(games)}
+	if len(games) > 0 {
+		resp["games"] = games
+	}
+	writeJSON(rw, map[string]interface{}{"response": resp})
+}
+
+func (w *World) handleRecentlyPlayedGames(rw http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseUint(r.URL.Query().Get("steamid"), 10, 64)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	elapsed := w.advance()
+
+	u, ok := w.users[sptt.SteamID(id)]
+	if !ok || u.Private {
+		writeJSON(rw, map[string]interface{}{"response": map[string]interface{}{}})
+		return
+	}
+	if u.OwnedGames == OwnedGamesError {
+		http.Error(rw, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	games := []sptt.RecentGame{}
+	for _, g := range w.sortedGames(u) {
+		view := w.gameView(u, g, elapsed)
+		if view.Playtime2Weeks <= 0 {
+			continue
+		}
+		games = append(games, sptt.RecentGame{
+			AppID:          view.AppID,
+			Name:           w.appName(view.AppID),
+			Playtime:       view.PlaytimeForever,
+			Playtime2Weeks: &view.Playtime2Weeks,
+		})
+	}
+
+	resp := map[string]interface{}{"total_count": len(games)}
+	if len(games) > 0 {
+		resp["games"] = games
+	}
+	writeJSON(rw, map[string]interface{}{"response": resp})
+}
+
+func (w *World) handleAppDetails(rw http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("appids")
+	id, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		writeJSON(rw, nil)
+		return
+	}
+
+	w.mu.Lock()
+	app, ok := w.apps[sptt.AppID(id)]
+	w.mu.Unlock()
+
+	if !ok {
+		writeJSON(rw, map[string]interface{}{raw: map[string]bool{"success": false}})
+		return
+	}
+
+	data := sptt.GameData{
+		Name:        app.Name,
+		AppID:       app.AppID,
+		HeaderImage: app.HeaderImage,
+		Developers:  app.Developers,
+		Publishers:  app.Publishers,
+	}
+	data.Platforms.Windows = true
+	writeJSON(rw, map[string]interface{}{raw: map[string]interface{}{"success": true, "data": data}})
+}
+
+func (w *World) handleState(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	elapsed := w.advance()
+
+	type gameState struct {
+		AppID           sptt.AppID `json:"appid"`
+		PlaytimeForever int32      `json:"playtime_forever"`
+		Playtime2Weeks  int32      `json:"playtime_2weeks"`
+	}
+	type userOut struct {
+		SteamID    string      `json:"steamid"`
+		Name       string      `json:"name"`
+		Private    bool        `json:"private"`
+		OwnedGames string      `json:"owned_games"`
+		InGame     *sptt.AppID `json:"in_game"`
+		Games      []gameState `json:"games"`
+	}
+
+	users := make([]userOut, 0, len(w.users))
+	for _, u := range w.users {
+		out := userOut{
+			SteamID:    strconv.FormatUint(uint64(u.SteamID), 10),
+			Name:       u.Name,
+			Private:    u.Private,
+			OwnedGames: u.OwnedGames,
+			InGame:     u.InGame,
+		}
+		for _, g := range w.sortedGames(u) {
+			view := w.gameView(u, g, elapsed)
+			out.Games = append(out.Games, gameState{view.AppID, view.PlaytimeForever, view.Playtime2Weeks})
+		}
+		users = append(users, out)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].SteamID < users[j].SteamID })
+
+	writeJSON(rw, map[string]interface{}{
+		"elapsed":        elapsed.Truncate(time.Second).String(),
+		"events_applied": w.next,
+		"events_total":   len(w.scenario.Events),
+		"users":          users,
+	})
+}
+
+func (w *World) sortedGames(u *userState) []*ScenarioGame {
+	games := make([]*ScenarioGame, 0, len(u.Games))
+	for _, g := range u.Games {
+		games = append(games, g)
+	}
+	sort.Slice(games, func(i, j int) bool { return games[i].AppID < games[j].AppID })
+	return games
+}
+
+func logEvent(ev ScenarioEvent) {
+	switch ev.Action {
+	case ActionStartGame, ActionAddPlaytime:
+		log.Infof("[%v] %d %s %d %d", time.Duration(ev.At), uint64(ev.SteamID), ev.Action, uint32(ev.AppID), ev.Minutes)
+	case ActionSetOwnedGames:
+		log.Infof("[%v] %d %s %s", time.Duration(ev.At), uint64(ev.SteamID), ev.Action, ev.Mode)
+	default:
+		log.Infof("[%v] %d %s", time.Duration(ev.At), uint64(ev.SteamID), ev.Action)
+	}
+}
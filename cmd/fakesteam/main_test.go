@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+const testScenario = `
+apps:
+  - { appid: 493520, name: GTFO }
+users:
+  - { steamid: "76561198000000001", name: Alice, vanity: alice, games: [{ appid: 493520, playtime_forever: 100 }] }
+events:
+  - { at: 1m, steamid: "76561198000000001", action: start_game, appid: 493520 }
+  - { at: 31m, steamid: "76561198000000001", action: stop_game }
+  - { at: 40m, steamid: "76561198000000001", action: go_private }
+  - { at: 50m, steamid: "76561198000000001", action: go_public }
+  - { at: 50m, steamid: "76561198000000001", action: set_owned_games, mode: empty_response }
+`
+
+func TestFakeSteam(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(path, []byte(testScenario), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sc, err := LoadScenario(path)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	start := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	w := NewWorld(sc, func() time.Time { return now })
+
+	srv := httptest.NewServer(NewHandler(w, "testkey"))
+	defer srv.Close()
+
+	api := sptt.NewSteamAPIWithConfig("testkey", sptt.SteamAPIConfig{
+		WebAPIBaseURL: srv.URL,
+		StoreBaseURL:  srv.URL,
+	})
+
+	ctx := context.Background()
+	alice := sptt.SteamID(76561198000000001)
+	gtfo := sptt.AppID(493520)
+
+	t.Run("In game", func(t *testing.T) {
+		now = start.Add(10 * time.Minute)
+		summary, err := api.GetPlayerSummary(ctx, alice)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if summary.GameID == nil || *summary.GameID != gtfo {
+			t.Errorf("Expected to be in game %d, got %v", gtfo, summary.GameID)
+		}
+		if summary.Visibility != 3 {
+			t.Errorf("Expected visibility 3, got %d", summary.Visibility)
+		}
+	})
+
+	t.Run("Playtime after exit", func(t *testing.T) {
+		now = start.Add(35 * time.Minute)
+		summary, err := api.GetPlayerSummary(ctx, alice)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if summary.GameID != nil {
+			t.Errorf("Expected not in game, got %v", *summary.GameID)
+		}
+
+		game, err := api.GetOwnedGame(ctx, alice, gtfo)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if game.Playtime != 130 {
+			t.Errorf("Expected 130, got %d", game.Playtime)
+		}
+
+		recent, err := api.GetRecentlyPlayedGames(ctx, alice)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if recent[gtfo].Playtime != 130 {
+			t.Errorf("Expected 130, got %d", recent[gtfo].Playtime)
+		}
+	})
+
+	t.Run("Private", func(t *testing.T) {
+		now = start.Add(45 * time.Minute)
+		summary, err := api.GetPlayerSummary(ctx, alice)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if summary.Visibility != 1 {
+			t.Errorf("Expected visibility 1, got %d", summary.Visibility)
+		}
+		if _, err := api.GetOwnedGames(ctx, alice, []sptt.AppID{gtfo}); err != sptt.ErrEmptyGames {
+			t.Errorf("Expected ErrEmptyGames, got %v", err)
+		}
+	})
+
+	t.Run("Empty response", func(t *testing.T) {
+		now = start.Add(55 * time.Minute)
+		if _, err := api.GetOwnedGames(ctx, alice, []sptt.AppID{gtfo}); err != sptt.ErrEmptyResponse {
+			t.Errorf("Expected ErrEmptyResponse, got %v", err)
+		}
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		bad := sptt.NewSteamAPIWithConfig("badkey", sptt.SteamAPIConfig{WebAPIBaseURL: srv.URL})
+		if err := bad.TestAPIKey(ctx); err != sptt.ErrForbidden {
+			t.Errorf("Expected ErrForbidden, got %v", err)
+		}
+	})
+
+	t.Run("ResolveVanityURL", func(t *testing.T) {
+		id, err := sptt.ResolveSteamID(ctx, api, "https://steamcommunity.com/id/alice/")
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if id != alice {
+			t.Errorf("Expected %d, got %d", alice, id)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		w.Reset()
+		game, err := api.GetOwnedGame(ctx, alice, gtfo)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if game.Playtime != 100 {
+			t.Errorf("Expected 100, got %d", game.Playtime)
+		}
+	})
+
+	t.Run("Discord stand-in", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/_fake/discord", "application/json", strings.NewReader(`{"embeds": [{"description": "**Alice** started playing **GTFO**"}]}`))
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("Expected 204, got %d", resp.StatusCode)
+		}
+
+		resp, err = http.Get(srv.URL + "/_fake/discord")
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer resp.Body.Close()
+		var got struct {
+			Messages []json.RawMessage `json:"messages"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || len(got.Messages) != 1 {
+			t.Errorf("Expected 1 message, got %v (%v)", got.Messages, err)
+		}
+	})
+
+	t.Run("Example scenario", func(t *testing.T) {
+		if _, err := LoadScenario("scenarios/example.yaml"); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+// Scenario actions
+const (
+	ActionStartGame     = "start_game"      // user starts playing appid
+	ActionStopGame      = "stop_game"       // user quits the current game
+	ActionGoPrivate     = "go_private"      // profile becomes private
+	ActionGoPublic      = "go_public"       // profile becomes public again
+	ActionAddPlaytime   = "add_playtime"    // adds minutes to appid's playtime
+	ActionSetOwnedGames = "set_owned_games" // changes how GetOwnedGames answers for the user
+)
+
+// Modes for ActionSetOwnedGames
+const (
+	OwnedGamesOK            = "ok"             // library is returned normally
+	OwnedGamesPrivate       = "private"        // {"response":{}} -> ErrEmptyGames
+	OwnedGamesEmptyResponse = "empty_response" // {} -> ErrEmptyResponse
+	OwnedGamesError         = "error"          // 503 Service Unavailable
+)
+
+// Playtime update modes, controls when playtime_forever grows
+const (
+	PlaytimeOnExit = "exit" // once the game is stopped, like Steam usually does
+	PlaytimeLive   = "live" // every minute while in game
+)
+
+// Duration is a time.Duration read from a string such as "1h30m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+type ScenarioGame struct {
+	AppID           sptt.AppID `json:"appid"`
+	PlaytimeForever int32      `json:"playtime_forever"`
+	Playtime2Weeks  int32      `json:"playtime_2weeks"`
+}
+
+type ScenarioUser struct {
+	SteamID    sptt.SteamID   `json:"steamid"`
+	Name       string         `json:"name"`
+	Vanity     string         `json:"vanity"` // custom part of steamcommunity.com/id/<vanity>
+	Private    bool           `json:"private"`
+	OwnedGames string         `json:"owned_games"`
+	Games      []ScenarioGame `json:"games"`
+}
+
+type ScenarioApp struct {
+	AppID       sptt.AppID `json:"appid"`
+	Name        string     `json:"name"`
+	HeaderImage string     `json:"header_image"`
+	Developers  []string   `json:"developers"`
+	Publishers  []string   `json:"publishers"`
+}
+
+type ScenarioEvent struct {
+	At      Duration     `json:"at"`
+	SteamID sptt.SteamID `json:"steamid"`
+	Action  string       `json:"action"`
+	AppID   sptt.AppID   `json:"appid"`
+	Minutes int32        `json:"minutes"`
+	Mode    string       `json:"mode"`
+}
+
+// Scenario describes the initial state of the fake Steam API and the
+// events that change it over time. Event times are relative to the
+// start of the scenario.
+type Scenario struct {
+	Speed    float64         `json:"speed"` // scenario time elapsed per real second, defaults to 1
+	Playtime string          `json:"playtime_update"`
+	Users    []ScenarioUser  `json:"users"`
+	Apps     []ScenarioApp   `json:"apps"`
+	Events   []ScenarioEvent `json:"events"`
+}
+
+// LoadScenario reads a scenario from a .json, .yaml or .yml file.
+func LoadScenario(filename string) (*Scenario, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".yaml" || ext == ".yml" {
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("error converting %s to json: %w", filename, err)
+		}
+	}
+
+	var sc Scenario
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", filename, err)
+	}
+	return &sc, sc.validate()
+}
+
+func (sc *Scenario) validate() error {
+	if sc.Speed == 0 {
+		sc.Speed = 1
+	}
+	if sc.Speed < 0 {
+		return fmt.Errorf("speed must be positive")
+	}
+	switch sc.Playtime {
+	case "":
+		sc.Playtime = PlaytimeOnExit
+	case PlaytimeOnExit, PlaytimeLive:
+	default:
+		return fmt.Errorf("unknown playtime_update %q", sc.Playtime)
+	}
+
+	users := make(map[sptt.SteamID]bool)
+	for _, u := range sc.Users {
+		users[u.SteamID] = true
+		if !validOwnedGamesMode(u.OwnedGames) {
+			return fmt.Errorf("user %d: unknown owned_games mode %q", uint64(u.SteamID), u.OwnedGames)
+		}
+	}
+
+	for i, ev := range sc.Events {
+		if !users[ev.SteamID] {
+			return fmt.Errorf("event %d: unknown steamid %d", i, uint64(ev.SteamID))
+		}
+		switch ev.Action {
+		case ActionStartGame, ActionAddPlaytime:
+			if ev.AppID == 0 {
+				return fmt.Errorf("event %d: %s requires appid", i, ev.Action)
+			}
+		case ActionSetOwnedGames:
+			if ev.Mode == "" || !validOwnedGamesMode(ev.Mode) {
+				return fmt.Errorf("event %d: unknown owned_games mode %q", i, ev.Mode)
+			}
+		case ActionStopGame, ActionGoPrivate, ActionGoPublic:
+		default:
+			return fmt.Errorf("event %d: unknown action %q", i, ev.Action)
+		}
+	}
+
+	sort.SliceStable(sc.Events, func(i, j int) bool { return sc.Events[i].At < sc.Events[j].At })
+	return nil
+}
+
+func validOwnedGamesMode(mode string) bool {
+	switch mode {
+	case "", OwnedGamesOK, OwnedGamesPrivate, OwnedGamesEmptyResponse, OwnedGamesError:
+		return true
+	}
+	return false
+}
+
+// userState is the live state of a user while the scenario plays.
+type userState struct {
+	SteamID     sptt.SteamID
+	Name        string
+	Vanity      string
+	Private     bool
+	OwnedGames  string
+	Games       map[sptt.AppID]*ScenarioGame
+	InGame      *sptt.AppID
+	InGameSince time.Duration // scenario time the current game started
+}
+
+// World plays a scenario and answers questions about the current state.
+type World struct {
+	mu       sync.Mutex
+	scenario *Scenario
+	start    time.Time
+	now      func() time.Time
+	next     int // index of the next event to apply
+	users    map[sptt.SteamID]*userState
+	apps     map[sptt.AppID]ScenarioApp
+}
+
+func NewWorld(sc *Scenario, now func() time.Time) *World {
+	w := &World{scenario: sc, now: now}
+	w.Reset()
+	return w
+}
+
+// Reset restarts the scenario from its initial state.
+func (w *World) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.start = w.now()
+	w.next = 0
+	w.users = make(map[sptt.SteamID]*userState, len(w.scenario.Users))
+	for _, u := range w.scenario.Users {
+		st := &userState{
+			SteamID:    u.SteamID,
+			Name:       u.Name,
+			Vanity:     u.Vanity,
+			Private:    u.Private,
+			OwnedGames: u.OwnedGames,
+			Games:      make(map[sptt.AppID]*ScenarioGame, len(u.Games)),
+		}
+		if st.OwnedGames == "" {
+			st.OwnedGames = OwnedGamesOK
+		}
+		for _, g := range u.Games {
+			st.Games[g.AppID] = &g
+		}
+		w.users[u.SteamID] = st
+	}
+
+	w.apps = make(map[sptt.AppID]ScenarioApp, len(w.scenario.Apps))
+	for _, a := range w.scenario.Apps {
+		w.apps[a.AppID] = a
+	}
+}
+
+// elapsed returns the scenario time since start. Must hold w.mu.
+func (w *World) elapsed() time.Duration {
+	return time.Duration(float64(w.now().Sub(w.start)) * w.scenario.Speed)
+}
+
+// advance applies every event that is due. Must hold w.mu.
+func (w *World) advance() time.Duration {
+	elapsed := w.elapsed()
+	for w.next < len(w.scenario.Events) && time.Duration(w.scenario.Events[w.next].At) <= elapsed {
+		w.apply(w.scenario.Events[w.next])
+		w.next++
+	}
+	return elapsed
+}
+
+func (w *World) apply(ev ScenarioEvent) {
+	u := w.users[ev.SteamID]
+	at := time.Duration(ev.At)
+
+	logEvent(ev)
+
+	switch ev.Action {
+	case ActionStartGame:
+		w.stopGame(u, at)
+		appid := ev.AppID
+		u.InGame = &appid
+		u.InGameSince = at
+		if _, ok := u.Games[appid]; !ok {
+			// Newly added to the library, e.g. a free-to-play game
+			u.Games[appid] = &ScenarioGame{AppID: appid}
+		}
+	case ActionStopGame:
+		w.stopGame(u, at)
+	case ActionGoPrivate:
+		u.Private = true
+	case ActionGoPublic:
+		u.Private = false
+	case ActionAddPlaytime:
+		g, ok := u.Games[ev.AppID]
+		if !ok {
+			g = &ScenarioGame{AppID: ev.AppID}
+			u.Games[ev.AppID] = g
+		}
+		g.PlaytimeForever += ev.Minutes
+		g.Playtime2Weeks += ev.Minutes
+	case ActionSetOwnedGames:
+		u.OwnedGames = ev.Mode
+	}
+}
+
+// stopGame ends the current game of u at scenario time at and books
+// the minutes played onto the game.
+func (w *World) stopGame(u *userState, at time.Duration) {
+	if u.InGame == nil {
+		return
+	}
+	minutes := int32((at - u.InGameSince) / time.Minute)
+	g := u.Games[*u.InGame]
+	g.PlaytimeForever += minutes
+	g.Playtime2Weeks += minutes
+	u.InGame = nil
+}
+
+// gameView returns the playtime Steam would report for g right now.
+func (w *World) gameView(u *userState, g *ScenarioGame, elapsed time.Duration) ScenarioGame {
+	view := *g
+	if w.scenario.Playtime == PlaytimeLive && u.InGame != nil && *u.InGame == g.AppID {
+		minutes := int32((elapsed - u.InGameSince) / time.Minute)
+		view.PlaytimeForever += minutes
+		view.Playtime2Weeks += minutes
+	}
+	return view
+}
+
+func (w *World) appName(appid sptt.AppID) string {
+	if a, ok := w.apps[appid]; ok {
+		return a.Name
+	}
+	return fmt.Sprintf("App %d", uint32(appid))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt/discord"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/tracker"
+)
+
+// Monitor settings read from .env, every key is optional.
+type monitorConfig struct {
+	Workers           int
+	Poll              tracker.PollConfig
+	Tracker           tracker.Config
+	RecoveryThreshold time.Duration // Gap after which downtime is recorded and sessions are recovered
+	InstanceID        string        // Shown in metadata while this instance is the leader
+	LeaderRetry       time.Duration // How often standby instances try to take over
+}
+
+const defaultLeaderRetry = 15 * time.Second
+
+// defaultInstanceID identifies the process as hostname-pid.
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+func loadMonitorConfig(env map[string]string) (monitorConfig, error) {
+	cfg := monitorConfig{
+		Workers:           tracker.DefaultWorkers,
+		Poll:              tracker.DefaultPollConfig(),
+		Tracker:           tracker.DefaultConfig(),
+		RecoveryThreshold: tracker.DefaultRecoveryThreshold,
+		InstanceID:        defaultInstanceID(),
+		LeaderRetry:       defaultLeaderRetry,
+	}
+	if v := env["INSTANCE_ID"]; v != "" {
+		cfg.InstanceID = v
+	}
+
+	var tolerance, staleAfter int
+	settings := []struct {
+		key string
+		err error
+	}{
+		{"MONITOR_WORKERS", envInt(env, "MONITOR_WORKERS", &cfg.Workers)},
+		{"POLL_INTERVAL", envDuration(env, "POLL_INTERVAL", &cfg.Poll.Interval)},
+		{"POLL_INTERVAL_IN_GAME", envDuration(env, "POLL_INTERVAL_IN_GAME", &cfg.Poll.InGameInterval)},
+		{"POLL_INTERVAL_OFFLINE", envDuration(env, "POLL_INTERVAL_OFFLINE", &cfg.Poll.OfflineInterval)},
+		{"OFFLINE_AFTER", envDuration(env, "OFFLINE_AFTER", &cfg.Poll.OfflineAfter)},
+		{"STEAM_DAILY_BUDGET", envInt(env, "STEAM_DAILY_BUDGET", &cfg.Poll.DailyBudget)},
+		{"TIME_TOLERANCE", envInt(env, "TIME_TOLERANCE", &tolerance)},
+		{"STALE_SESSION_AFTER", envInt(env, "STALE_SESSION_AFTER", &staleAfter)},
+		{"RECOVERY_THRESHOLD", envDuration(env, "RECOVERY_THRESHOLD", &cfg.RecoveryThreshold)},
+		{"LEADER_RETRY_INTERVAL", envDuration(env, "LEADER_RETRY_INTERVAL", &cfg.LeaderRetry)},
+	}
+	for _, s := range settings {
+		if s.err != nil {
+			return cfg, fmt.Errorf("%s: %w", s.key, s.err)
+		}
+	}
+
+	if tolerance > 0 {
+		cfg.Tracker.TimeTolerance = int32(tolerance)
+	}
+	if staleAfter > 0 {
+		cfg.Tracker.StaleAfter = int32(staleAfter)
+	}
+
+	if cfg.Workers < 1 {
+		return cfg, fmt.Errorf("MONITOR_WORKERS must be at least 1")
+	}
+	if cfg.Poll.Interval <= 0 || cfg.Poll.InGameInterval <= 0 || cfg.Poll.OfflineInterval <= 0 {
+		return cfg, fmt.Errorf("poll intervals must be positive")
+	}
+	if cfg.LeaderRetry <= 0 {
+		return cfg, fmt.Errorf("LEADER_RETRY_INTERVAL must be positive")
+	}
+	// Heartbeats are written every tick, a shorter threshold would record
+	// every tick as downtime
+	if cfg.RecoveryThreshold <= 2*cfg.Poll.TickInterval() {
+		return cfg, fmt.Errorf("RECOVERY_THRESHOLD must be longer than twice the shortest poll interval")
+	}
+	return cfg, nil
+}
+
+// envInt sets *dst to the integer value of key if it is set
+func envInt(env map[string]string, key string, dst *int) error {
+	v, ok := env[key]
+	if !ok || v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*dst = n
+	return nil
+}
+
+// envDuration sets *dst to the duration value of key (e.g. 30s, 5m) if it is set
+func envDuration(env map[string]string, key string, dst *time.Duration) error {
+	v, ok := env[key]
+	if !ok || v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
+
+// loadDiscordConfig reads the Discord notifier settings, the notifier is
+// disabled unless DISCORD_WEBHOOK_URL is set.
+func loadDiscordConfig(env map[string]string) (discord.Config, error) {
+	cfg := discord.Config{
+		WebhookURL: env["DISCORD_WEBHOOK_URL"],
+		MinSession: discord.DefaultMinSession,
+		Username:   env["DISCORD_USERNAME"],
+	}
+	if err := envDuration(env, "DISCORD_MIN_SESSION", &cfg.MinSession); err != nil {
+		return cfg, fmt.Errorf("DISCORD_MIN_SESSION: %w", err)
+	}
+
+	loc := time.UTC
+	if v := env["DISCORD_TIMEZONE"]; v != "" {
+		var err error
+		if loc, err = time.LoadLocation(v); err != nil {
+			return cfg, fmt.Errorf("DISCORD_TIMEZONE: %w", err)
+		}
+	}
+	if v := env["DISCORD_QUIET_HOURS"]; v != "" {
+		q, err := discord.ParseQuietHours(v, loc)
+		if err != nil {
+			return cfg, fmt.Errorf("DISCORD_QUIET_HOURS: %w", err)
+		}
+		cfg.QuietHours = q
+	}
+	return cfg, nil
+}
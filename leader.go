@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/discord"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/webhook"
+)
+
+// Runs the monitor, the catalog fetcher, the webhook dispatcher and the
+// Discord notifier while this instance holds the leader lock. Standby
+// instances only serve the API and retry every LeaderRetry, the lock of a
+// leader that dies is released with its database connection and taken over
+// by one of them.
+func (app *Application) lead(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(app.LeaderRetry)
+	defer ticker.Stop()
+
+	standby := false
+	for {
+		lock, err := app.DB.TryLeaderLock(ctx)
+		if err != nil {
+			log.Error("Error while trying to take the leader lock: ", err)
+		} else if lock == nil {
+			if !standby {
+				log.Info("Another instance is the leader, standing by")
+				standby = true
+			}
+		} else {
+			standby = false
+			app.runAsLeader(ctx, lock)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Runs the leader routines until ctx is cancelled or the lock is lost
+func (app *Application) runAsLeader(ctx context.Context, lock *sptt.LeaderLock) {
+	defer lock.Release()
+
+	log.Infof("Became the leader as instance %s", app.InstanceID)
+	since := time.Now().UTC().Format(time.RFC3339)
+	if err := app.DB.SetMetadata(ctx, sptt.MetaKeyLeader, app.InstanceID); err != nil {
+		log.Error("Error while recording leader: ", err)
+	}
+	if err := app.DB.SetMetadata(ctx, sptt.MetaKeyLeaderSince, since); err != nil {
+		log.Error("Error while recording leader: ", err)
+	}
+
+	// Runs before the lock is released. A new leader may already have taken
+	// over if the lock was lost, its record is left alone then.
+	defer func() {
+		if err := app.DB.ClearLeader(context.Background(), app.InstanceID); err != nil {
+			log.Error("Error while clearing leader: ", err)
+		}
+	}()
+
+	// The user list may have changed while another instance was the leader
+	ids, err := app.DB.GetActiveSteamIDs(ctx)
+	if err != nil {
+		log.Error("Error while trying to get active steam ids from db: ", err)
+		return
+	}
+	app.setUserIDs(ids)
+
+	// Sessions orphaned by downtime have to be concluded before the first
+	// poll, a user back in the same game would continue them otherwise
+	if _, err := app.Tracker.Recover(ctx, app.RecoveryThreshold); err != nil {
+		log.Error("Error while recovering from downtime: ", err)
+	}
+	if err := app.Tracker.Heartbeat(ctx); err != nil {
+		log.Error("Error while writing heartbeat: ", err)
+	}
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	leaderWg := sync.WaitGroup{}
+
+	leaderWg.Add(1)
+	go app.monitor(leaderCtx, &leaderWg)
+
+	catalog := sptt.NewCatalogFetcher(leaderCtx, app.DB, app.CatalogSteam, &leaderWg, sptt.CatalogFetchInterval)
+	leaderWg.Add(1)
+	go catalog.Run()
+
+	// Events are published by this instance's tracker, webhooks are only
+	// delivered from here so standbys don't send duplicates
+	dispatcher := webhook.NewDispatcher(leaderCtx, app.DB, app.Events, &leaderWg)
+	leaderWg.Add(1)
+	go dispatcher.Run()
+
+	if app.Discord.WebhookURL != "" {
+		notifier := discord.NewNotifier(leaderCtx, app.DB, app.CatalogSteam, app.Events, &leaderWg, app.Discord)
+		leaderWg.Add(1)
+		go notifier.Run()
+	}
+
+	ticker := time.NewTicker(app.LeaderRetry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			leaderWg.Wait()
+			return
+		case <-ticker.C:
+			if err := lock.Check(ctx); err != nil {
+				log.Error("Lost the leader lock, stopping the monitor: ", err)
+				cancel()
+				leaderWg.Wait()
+				return
+			}
+		}
+	}
+}
@@ -2,21 +2,24 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"math"
+	"errors"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/sebun1/steamPlaytimeTracker/log"
 	"github.com/sebun1/steamPlaytimeTracker/sptt"
 	"github.com/sebun1/steamPlaytimeTracker/sptt/api"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/discord"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/tracker"
 )
 
-var timeTolerance int32 = 3
 var env map[string]string
+var dbConfig sptt.DBConfig
 
 func init() {
 	hadError := false
@@ -32,18 +35,16 @@ func init() {
 		log.SetLevelFromString(v)
 	}
 
-	varChecks := []string{
-		"STEAM_API_KEY",
-		"DB_USER",
-		"DB_PASSWORD",
-		"DB_NAME",
+	dbConfig, err = sptt.DBConfigFromEnv(env)
+	if err != nil {
+		log.Fatal(err)
+		hadError = true
 	}
 
-	for _, v := range varChecks {
-		if _, ok := env[v]; !ok {
-			log.Fatal(v + " is not set.")
-			hadError = true
-		}
+	// The migrate subcommand only needs the database
+	if _, ok := env["STEAM_API_KEY"]; !ok && !isMigrateCmd() {
+		log.Fatal("STEAM_API_KEY is not set.")
+		hadError = true
 	}
 
 	if hadError {
@@ -53,15 +54,35 @@ func init() {
 }
 
 type Application struct {
-	DB            *sptt.DB
-	SteamAPI      *sptt.SteamAPI
-	NotifChan     chan sptt.Notif
+	DB            sptt.Store
+	SteamAPI      sptt.SteamClient
+	Events        *events.Bus
 	UserIDsMu     sync.RWMutex
 	UserIDs       []sptt.SteamID
-	UserListDirty bool
+	UserListDirty atomic.Bool
+	Tracker       *tracker.Tracker
+	Scheduler     *tracker.Scheduler
+	Workers       int           // Users processed concurrently per cycle
+	Tick          time.Duration // How often the scheduler is asked for due users
+	lastReload    string        // MetaKeyLastUserReload seen by the last cycle
+	pollNow       chan struct{} // Starts a cycle without waiting for the next tick
+
+	CatalogSteam      sptt.SteamClient // Store requests, not counted against the Web API budget
+	InstanceID        string
+	LeaderRetry       time.Duration // How often standby instances try to take over
+	RecoveryThreshold time.Duration
+	Discord           discord.Config // Notifier runs on the leader if WebhookURL is set
+}
+
+func isMigrateCmd() bool {
+	return len(os.Args) > 1 && os.Args[1] == "migrate"
 }
 
 func main() {
+	if isMigrateCmd() {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	wg := sync.WaitGroup{}
 
 	ctx := context.Background()
@@ -72,15 +93,19 @@ func main() {
 	cancelChan := make(chan os.Signal, 1)
 	signal.Notify(cancelChan, os.Interrupt, syscall.SIGTERM)
 
-	stApi := sptt.NewSteamAPI(env["STEAM_API_KEY"])
+	// Base URLs can be overridden to point the tracker at a fake Steam API
+	stApi := sptt.NewSteamAPIWithConfig(env["STEAM_API_KEY"], sptt.SteamAPIConfig{
+		WebAPIBaseURL: env["STEAM_API_BASE_URL"],
+		StoreBaseURL:  env["STEAM_STORE_BASE_URL"],
+	})
 
-	db, err := sptt.NewDB(env["DB_USER"], env["DB_PASSWORD"], env["DB_NAME"])
+	db, err := sptt.NewStore(dbConfig)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 
-	defer func(db *sptt.DB) {
+	defer func(db sptt.Store) {
 		err := db.Close()
 		if err != nil {
 			log.Errorf("Error while closing database connection: %e", err)
@@ -106,8 +131,8 @@ func main() {
 		return
 	}
 
-	// monitor <-> API server communication channel.
-	notifChan := make(chan sptt.Notif, 10)
+	// Connects the monitor, the API server and everything listening to them
+	bus := events.NewBus()
 
 	port := "8080"
 	if v, ok := env["API_PORT"]; ok && v != "" {
@@ -119,25 +144,54 @@ func main() {
 		corsOrigin = v
 	}
 
-	apiServer := api.NewSptAPI(ctx, db, notifChan, &wg, ":"+port, corsOrigin)
+	apiServer := api.NewSptAPI(ctx, db, stApi, bus, &wg, ":"+port, corsOrigin)
 
-	ids, err := db.GetActiveSteamIDs(ctx)
+	monCfg, err := loadMonitorConfig(env)
 	if err != nil {
-		log.Fatal("Error while trying to get active steam ids from db: ", err)
+		log.Fatal("Invalid monitor config: ", err)
 		return
 	}
 
+	discordCfg, err := loadDiscordConfig(env)
+	if err != nil {
+		log.Fatal("Invalid Discord config: ", err)
+		return
+	}
+
+	// Every Web API request of the monitor is counted against the budget
+	scheduler := tracker.NewScheduler(monCfg.Poll, time.Now)
+	steam := scheduler.Client(stApi)
+
+	trackerCfg := monCfg.Tracker
+	trackerCfg.Events = bus
+
 	app := &Application{
-		DB:        db,
-		SteamAPI:  stApi,
-		NotifChan: notifChan,
-		UserIDs:   ids,
+		DB:                db,
+		SteamAPI:          steam,
+		Events:            bus,
+		pollNow:           make(chan struct{}, 1),
+		Tracker:           tracker.NewTrackerWithConfig(db, steam, time.Now, trackerCfg),
+		Scheduler:         scheduler,
+		Workers:           monCfg.Workers,
+		Tick:              monCfg.Poll.TickInterval(),
+		CatalogSteam:      stApi,
+		InstanceID:        monCfg.InstanceID,
+		LeaderRetry:       monCfg.LeaderRetry,
+		RecoveryThreshold: monCfg.RecoveryThreshold,
+		Discord:           discordCfg,
 	}
 
-	// Run routines for stApi and monitor
+	// The monitor and catalog only run while this instance is the leader,
+	// the API is served by every instance
 	wg.Add(1)
-	go app.monitor(ctx, &wg)
-	log.Info("Steam Playtime Tracker started.")
+	go app.lead(ctx, &wg)
+	log.Infof("Steam Playtime Tracker started as instance %s", app.InstanceID)
+
+	// Session events are only published by the leader, the streams of every
+	// instance receive them through the database
+	relay := events.NewRelay(ctx, bus, db, app.InstanceID, &wg, events.SessionTypes...)
+	wg.Add(1)
+	go relay.Run()
 
 	wg.Add(1)
 	go apiServer.Run()
@@ -148,7 +202,6 @@ func main() {
 	cancel()
 	log.Info("Shutting down Steam Playtime Tracker, waiting for routines to finish.")
 	wg.Wait()
-	close(notifChan)
 	log.Info("Exiting...")
 }
 
@@ -179,284 +232,179 @@ func (app *Application) setUserIDs(ids []sptt.SteamID) {
 	app.UserIDsMu.Unlock()
 }
 
-// Handles notifications for the monitor
+// Handles the events the monitor reacts to
 func monitorSignalHandler(ctx context.Context, app *Application, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	sub := app.Events.Subscribe(
+		events.TypeUserAdded,
+		events.TypeUserRemoved,
+		events.TypeUserPaused,
+		events.TypeUserResumed,
+		events.TypeUserListReloaded,
+		events.TypeForcePoll,
+	)
+	defer sub.Close()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
-		case notif := <-app.NotifChan:
-			if notif.IsUserListUpdate() {
-				log.Info("Processing user list update...")
+		case e := <-sub.C:
+			switch e := e.(type) {
+			case events.ForcePoll:
+				// The cycle takes the users from the poll request in the database
+				select {
+				case app.pollNow <- struct{}{}:
+				default:
+				}
+			default:
+				log.Infof("Processing user list update (%s)...", e.Type())
 				ids, err := app.DB.GetActiveSteamIDs(ctx)
 				if err != nil {
 					log.Error("Error while trying to get steam ids from db: ", err)
 					continue
 				}
 				app.setUserIDs(ids)
-				continue
 			}
-
-			log.Errorf("monitor: unknown notification received, type: %v payload: %v", notif.MessageType, notif.Payload)
 		}
 	}
 }
 
-// Main loop for minute-wise updates of user sessions
+// Main loop for updates of user sessions, polling the users the scheduler
+// says are due. Cycles never overlap, a tick arriving while the previous
+// cycle is still running is skipped.
 func monitorLoop(ctx context.Context, app *Application, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(app.Tick)
+	defer ticker.Stop()
+
+	cycleWg := sync.WaitGroup{}
+	defer cycleWg.Wait()
+
+	var running atomic.Bool
+	startCycle := func() {
+		if !running.CompareAndSwap(false, true) {
+			log.Warn("Previous monitor cycle is still running, skipping this tick")
+			return
+		}
+		cycleWg.Add(1)
+		go func() {
+			defer cycleWg.Done()
+			defer running.Store(false)
+			app.runCycle(ctx)
+		}()
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			ticker.Stop()
 			return
 		case <-ticker.C:
-			log.Debug("Running user updates for", time.Now().UTC())
-			ids := app.getUserIDsSnapshot()
-			summaries, err := app.SteamAPI.GetPlayerSummaries(ctx, ids)
-			if err != nil {
-				log.Error("Error while trying to get player summaries: ", err)
-				continue
-			}
-			for _, id := range ids {
-				summary, ok := summaries[id]
-				if !ok {
-					log.Error("Summary for user ", id, " not found in summaries, skipping")
-					continue
-				}
-				go app.processUser(ctx, id, summary)
-			}
-
-			if app.UserListDirty {
-				log.Info("User list is dirty, refreshing from DB")
-				ids, err := app.DB.GetActiveSteamIDs(ctx)
-				if err != nil {
-					log.Error("Error while trying to get active steam ids from db: ", err)
-					continue
-				}
-				app.setUserIDs(ids)
-				app.UserListDirty = false
+			// Written even if the cycle is skipped, the monitor is still up
+			if err := app.Tracker.Heartbeat(ctx); err != nil {
+				log.Error("Error while writing heartbeat: ", err)
 			}
+			startCycle()
+		case <-app.pollNow:
+			startCycle()
 		}
 	}
 }
 
-// Processes a user update
-func (app *Application) processUser(ctx context.Context, id sptt.SteamID, summary sptt.PlayerSummary) {
-	if summary.SteamID != id {
-		log.Error("SteamID mismatch, expected ", id, " got ", summary.SteamID)
-		log.Error("Skipping user ", id, "/", summary.SteamID)
-		return
+// Runs one update of the due users and logs how long it took
+func (app *Application) runCycle(ctx context.Context) {
+	start := time.Now()
+	log.Debug("Running user updates for", start.UTC())
+
+	// Admin reloads sent to other instances only reach the leader through metadata
+	if stamp, err := app.DB.GetMetadata(ctx, sptt.MetaKeyLastUserReload); err != nil {
+		log.Error("Error while trying to get last user reload: ", err)
+	} else if stamp != app.lastReload {
+		app.lastReload = stamp
+		app.UserListDirty.Store(true)
 	}
-
-	if summary.Visibility != 3 {
-		log.Debugf("User %v has a private profile", id)
-		log.Infof("Releasing active_sessions and deactivating %v b/c private profile", id)
-		err := app.DB.RemoveActiveSessions(ctx, id)
-		if err != nil {
-			log.Errorf("Error removing active_sessions for %v: %v", id, err)
-		}
-		err = app.DB.SetUserActive(ctx, id, false)
-		if err != nil {
-			log.Errorf("Error setting user %v inactive: %v", id, err)
-		}
-		app.UserListDirty = true
-		return
+	app.reloadUserListIfDirty(ctx)
+
+	// So are forced polls
+	if req, err := app.DB.TakePollRequest(ctx); err != nil {
+		log.Error("Error while trying to get poll request: ", err)
+	} else if req != nil {
+		log.Infof("Forcing a poll of %d users (0 for all)", len(req.SteamIDs))
+		app.Scheduler.PollNow(req.SteamIDs...)
 	}
 
-	// User in game
-	if summary.GameID != nil {
-		err := app.startSession(ctx, id, summary)
-		if err != nil {
-			log.Error("Failed to start session for %v", id)
-		}
+	allIDs := app.getUserIDsSnapshot()
+	ids := app.Scheduler.Due(allIDs)
+	if len(ids) == 0 {
+		log.Debug("No users due for an update")
 		return
 	}
+	summaries, err := app.SteamAPI.GetPlayerSummaries(ctx, ids)
+	summariesTook := time.Since(start)
 
-	// User not in game
-	log.Debugf("User %v is not in game", id)
-	activeSessions, err := app.DB.GetActiveSessions(ctx, id)
+	// Users in failed batches are skipped this cycle, the rest are processed as usual
+	failedIDs := make(map[sptt.SteamID]bool)
 	if err != nil {
-		log.Errorf("Error getting active_sessions for %v: %v", id, err)
-		return
-	}
-
-	if len(activeSessions) > 0 {
-		err := app.concludeSessions(ctx, id, activeSessions)
-		if err != nil {
-			log.Errorf("Failed to conclude sessions for %v", id)
+		var batchErr *sptt.BatchError
+		if !errors.As(err, &batchErr) || len(summaries) == 0 {
+			if errors.Is(err, sptt.ErrRateLimited) || errors.Is(err, sptt.ErrUpstreamUnavailable) {
+				log.Warn("Steam is rate limiting or unavailable, skipping this cycle: ", err)
+				return
+			}
+			log.Error("Error while trying to get player summaries: ", err)
+			return
+		}
+		log.Error("Error while trying to get some player summaries, continuing with partial results: ", err)
+		for _, failure := range batchErr.Failed {
+			for _, id := range failure.SteamIDs {
+				failedIDs[id] = true
+			}
 		}
-	}
-}
-
-func (app *Application) startSession(ctx context.Context, id sptt.SteamID, summary sptt.PlayerSummary) error {
-	if summary.GameID == nil {
-		return fmt.Errorf("GameID is nil for %v, cannot start session", id)
-	}
-
-	gameId := *summary.GameID
-
-	activeSessions, err := app.DB.GetActiveSessions(ctx, id)
-	if err != nil {
-		log.Errorf("Error while trying to get active sessions for user %v: %v", id, err)
-		return err
-	}
-
-	existingSession, alreadyPlaying := activeSessions[gameId]
-
-	// if gameId is already in active sessions, do nothing
-	if alreadyPlaying {
-		log.Debugf("User %v is already playing game %v since %v", id, gameId, existingSession.UTCStart)
-		return nil
 	}
 
-	var playtime int32 = 0
-	game, err := app.SteamAPI.GetOwnedGame(ctx, id, gameId)
-	if err != nil && err != sptt.ErrEmptyGames {
-		log.Errorf("Error while trying to get owned game for user %v: %v", id, err)
-		return err
+	toProcess := make(map[sptt.SteamID]sptt.PlayerSummary, len(ids))
+	for _, id := range ids {
+		if failedIDs[id] {
+			continue
+		}
+		summary, ok := summaries[id]
+		if !ok {
+			log.Error("Summary for user ", id, " not found in summaries, skipping")
+			continue
+		}
+		toProcess[id] = summary
+		app.Scheduler.Observe(summary)
 	}
 
-	// NOTE: used to assume absence of playtime_2weeks implies time hidden.
-	// This is INCORRECT - playtime_2weeks is also hidden if game is not played in the last 2 weeks, even if playtime_forever is visible.
-	// We now check if playtime is 0; however, this also overlooks the case where the game
-	// is newly added and never played -- need to be handled in concludeSessions.
-	if err == sptt.ErrEmptyGames || (game.Playtime2Weeks == nil && game.Playtime == 0) {
-		log.Warnf("Playtime of game for user %v is empty/private/new, PlaytimeForever will be recorded as -1", id)
-		playtime = -1
-	} else {
-		playtime = game.Playtime
+	processed, deactivated := app.Tracker.ProcessUsers(ctx, toProcess, app.Workers)
+	if deactivated {
+		app.UserListDirty.Store(true)
 	}
 
-	log.Debug("User %v has no active sessions, starting new session", id)
-	sess := sptt.ActiveSession{
-		SteamID:         id,
-		UTCStart:        time.Now().UTC().Truncate(time.Second),
-		PlaytimeForever: playtime,
-		AppID:           *summary.GameID,
-	}
+	app.reloadUserListIfDirty(ctx)
 
-	err = app.DB.AddActiveSession(ctx, sess)
-	if err != nil {
-		log.Errorf("Error adding active session for %v: %v", id, err)
-		return err
+	took := time.Since(start)
+	log.Infof("Monitor cycle took %v (summaries %v, processing %v): %d processed, %d skipped, %d not due, %d workers, interval scale %.2f",
+		took.Round(time.Millisecond), summariesTook.Round(time.Millisecond), (took - summariesTook).Round(time.Millisecond),
+		processed, len(ids)-processed, len(allIDs)-len(ids), app.Workers, app.Scheduler.Scale())
+	if took > app.Tick {
+		log.Warnf("Monitor cycle took %v, longer than the poll interval", took.Round(time.Second))
 	}
-	log.Infof("Started new session for %v in game %v", id, summary.GameID)
-
-	return nil
 }
 
-func (app *Application) concludeSessions(ctx context.Context, id sptt.SteamID, activeSessions map[sptt.AppID]sptt.ActiveSession) error {
-	log.Debug("User ", id, " has active sessions, releasing them")
-	now := time.Now().UTC().Truncate(time.Second)
-
-	appids := make([]sptt.AppID, 0, len(activeSessions))
-	for _, sess := range activeSessions {
-		appids = append(appids, sess.AppID)
-	}
-
-	games, err := app.SteamAPI.GetOwnedGames(ctx, id, appids)
-
-	// TODO: use GetRecentlyPlayedGames as backup or vice versa
-
-	// Case 3: Steam returned an empty response envelope - data is unavailable, defer to next cycle
-	if err == sptt.ErrEmptyResponse {
-		log.Warnf("GetOwnedGames returned empty response for user %v, deferring session conclusion", id)
-		return nil
-	}
-
-	if err != nil && err != sptt.ErrEmptyGames {
-		return fmt.Errorf("error getting owned games for user %v: %v", id, err)
-	}
-
-	// Case 1 (partial): ErrEmptyGames means the library is private or empty - no playtime data available
-	playtimeAvailable := err == nil
-	if !playtimeAvailable {
-		log.Warnf("Games for user %v are empty/private, concluding sessions without playtime_forever", id)
+func (app *Application) reloadUserListIfDirty(ctx context.Context) {
+	if !app.UserListDirty.Load() {
+		return
 	}
-
-	for _, sess := range activeSessions {
-		newSession := sptt.Session{
-			SteamID:  id,
-			UTCStart: sess.UTCStart,
-			AppID:    sess.AppID,
-		}
-
-		game, gameFound := games[sess.AppID]
-
-		if playtimeAvailable && gameFound && sess.PlaytimeForever != -1 {
-			// Case 2: playtime_forever is available from Steam and we have a baseline from session start
-			playtimeDiffSteam := game.Playtime - sess.PlaytimeForever
-			playtimeDiffServer := int32(now.Sub(sess.UTCStart).Abs().Minutes())
-			playtimeDiffDiff := math.Abs(float64(playtimeDiffServer - playtimeDiffSteam))
-
-			if playtimeDiffSteam == 0 {
-				log.Debugf("No playtime difference for game %v for user %v, defer if not too old (>%vm)", sess.AppID, id, timeTolerance)
-
-				if playtimeDiffServer <= timeTolerance {
-					continue
-				}
-
-				if err := app.DB.RemoveActiveSession(ctx, id, sess.AppID); err != nil {
-					return fmt.Errorf("error removing active_session for user %v: %v", id, err)
-				}
-
-				log.Infof("Removed stale 0-playtime session for user %v in game %v after %d server minutes", id, sess.AppID, playtimeDiffServer)
-
-				continue
-			}
-
-			newSession.PlaytimeForever = game.Playtime
-			if playtimeDiffDiff > float64(timeTolerance) {
-				log.Warnf("Significant playtime difference for game %v user %v: steam=%d server=%d minutes, using Steam's value", sess.AppID, id, playtimeDiffSteam, playtimeDiffServer)
-				newSession.UTCEnd = sess.UTCStart.Add(time.Duration(playtimeDiffSteam) * time.Minute)
-			} else {
-				newSession.UTCEnd = now
-			}
-		} else {
-			// Case 1: playtime_forever unavailable — library private/empty, game missing from response,
-			// or session started without a playtime baseline (sess.PlaytimeForever == -1)
-			if playtimeAvailable && !gameFound {
-				log.Errorf("Game %v not found in owned games for user %v, concluding without playtime_forever", sess.AppID, id)
-			}
-
-			if sess.PlaytimeForever == -1 && playtimeAvailable && gameFound {
-				// No baseline at session start, but Steam has data now.
-				// If game.Playtime ≈ server duration, this is a first-play (newly added game).
-				// Otherwise the profile was hidden at start — we have no valid baseline.
-				playtimeDiffServer := int32(now.Sub(sess.UTCStart).Abs().Minutes())
-				if math.Abs(float64(game.Playtime)-float64(playtimeDiffServer)) <= float64(timeTolerance) {
-					log.Infof("Game %v for user %v appears newly added (steam=%d ≈ server=%d min), recording playtime_forever", sess.AppID, id, game.Playtime, playtimeDiffServer)
-					newSession.PlaytimeForever = game.Playtime
-				} else {
-					log.Warnf("Game %v for user %v had no playtime baseline and steam playtime (%d) diverges from server duration (%d min), concluding without playtime_forever", sess.AppID, id, game.Playtime, playtimeDiffServer)
-					newSession.PlaytimeForever = -1
-				}
-			} else {
-				if sess.PlaytimeForever == -1 {
-					log.Warnf("Session for game %v user %v had no playtime baseline, concluding without playtime_forever", sess.AppID, id)
-				}
-				newSession.PlaytimeForever = -1
-			}
-
-			newSession.UTCEnd = now
-		}
-
-		if err := app.DB.AddSession(ctx, newSession); err != nil {
-			log.Errorf("Error adding session for user %v game %v: %v; session will be released anyways", id, sess.AppID, err)
-		}
-
-		if err := app.DB.RemoveActiveSession(ctx, id, sess.AppID); err != nil {
-			return fmt.Errorf("error removing active_session for user %v: %v", id, err)
-		}
-
-		log.Infof("Released session for user %v in game %v", id, sess.AppID)
+	log.Info("User list is dirty, refreshing from DB")
+	ids, err := app.DB.GetActiveSteamIDs(ctx)
+	if err != nil {
+		log.Error("Error while trying to get active steam ids from db: ", err)
+		return
 	}
-	return nil
+	app.setUserIDs(ids)
+	app.UserListDirty.Store(false)
 }
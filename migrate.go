@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+const migrateUsage = `usage: spt migrate <command>
+
+commands:
+  up          apply all pending migrations
+  down [n]    revert the last n migrations (default 1)
+  status      list migrations and whether they are applied`
+
+// runMigrate implements the `migrate` subcommand and returns the exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := sptt.OpenDBWithConfig(dbConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error connecting to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Applied %d migrations.\n", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				fmt.Fprintln(os.Stderr, "error: n must be a positive integer")
+				return 2
+			}
+		}
+		reverted, err := db.MigrateDown(ctx, steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Reverted %d migrations.\n", reverted)
+
+	case "status":
+		status, err := db.MigrationStatus(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		for _, s := range status {
+			name := s.Name
+			if name == "" {
+				name = "(unknown to this binary)"
+			}
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Local().Format(time.DateTime)
+			}
+			fmt.Printf("%04d  %-30s %s\n", s.Version, name, applied)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
@@ -5,7 +5,6 @@
 // ─────────────────────────────────────────
 const API_BASE              = 'https://api.takina.io/sptt/v1';
 const STEAM_CDN             = 'https://cdn.cloudflare.steamstatic.com/steam/apps';
-const STEAM_STORE_API       = 'https://api.takina.io/proxy/steamstore/api/appdetails';
 const STEAM_COMMUNITY_PROXY = 'https://api.takina.io/proxy/steamcommunity'
 const STEAM_STORE_URL       = 'https://store.steampowered.com/app';
 
@@ -14,6 +13,7 @@ const STEAM_STORE_URL       = 'https://store.steampowered.com/app';
 // ─────────────────────────────────────────
 const state = {
   steamId:        null,
+  readToken:      null, // ?token=, lets a non-public user view their own data
   profile:        null,
   stats:          null,
   sessions:       [],
@@ -128,7 +128,8 @@ async function apiFetch(path, params = {}) {
       url.searchParams.set(k, String(v));
     }
   }
-  const res = await fetch(url.toString());
+  const headers = state.readToken ? { 'X-Read-Token': state.readToken } : {};
+  const res = await fetch(url.toString(), { headers });
   if (!res.ok) throw new Error(`API ${res.status}: ${path}`);
   return res.json();
 }
@@ -197,11 +198,11 @@ async function fetchSteamProfile(steamId) {
 }
 
 // ─────────────────────────────────────────
-// Steam: App Details (cached)
+// Game Catalog: App Details (cached)
 // ─────────────────────────────────────────
 
 /**
- * Resolve a Steam appId to { name, headerImage }.
+ * Resolve a Steam appId to { name, headerImage } via the server's game catalog.
  * Results are cached in state.gameCache for the lifetime of the page.
  * Overlapping requests for the same id are deduplicated via a pending Map.
  */
@@ -214,18 +215,11 @@ async function getGameDetails(appId) {
   const promise = (async () => {
     const fallback = { name: null, headerImage: thumbUrl(appId) };
     try {
-      const res  = await fetch(
-        `${STEAM_STORE_API}?appids=${appId}&filters=basic`,
-        { signal: AbortSignal.timeout(5_000) }
-      );
-      const data = await res.json();
-      if (data[appId]?.success) {
-        const d = data[appId].data;
-        fallback.name        = d.name          || null;
-        fallback.headerImage = d.header_image  || fallback.headerImage;
-      }
+      const d = await apiFetch(`/games/${appId}`);
+      fallback.name        = d.name          || null;
+      fallback.headerImage = d.header_image  || fallback.headerImage;
     } catch {
-      // Network or parse error — use fallback with CDN thumb
+      // Not in the catalog yet, network or parse error — use fallback with CDN thumb
     }
     state.gameCache.set(appId, fallback);
     _pendingDetails.delete(appId);
@@ -316,12 +310,25 @@ function renderStats() {
   }
 
   const totalSessions = state.stats?.total_sessions ?? '—';
+  const totalMins     = state.stats?.total_minutes;
+  const streak        = state.stats?.current_streak_days ?? 0;
+  const longestStreak = state.stats?.longest_streak_days ?? 0;
 
   el.innerHTML = `
     <div class="stat-card">
       <div class="stat-label">Total Sessions</div>
       <div class="stat-value">${esc(String(totalSessions))}</div>
     </div>
+    <div class="stat-card">
+      <div class="stat-label">Total Playtime</div>
+      <div class="stat-value" style="font-size:20px">${totalMins == null ? '—' : fmtPlaytime(totalMins)}</div>
+      <div class="stat-sub">all sessions</div>
+    </div>
+    <div class="stat-card">
+      <div class="stat-label">Streak</div>
+      <div class="stat-value">${streak}<span style="font-size:14px;color:var(--txt-muted);font-weight:400"> days</span></div>
+      <div class="stat-sub">longest ${longestStreak} days</div>
+    </div>
     <div class="stat-card">
       <div class="stat-label">Filtered</div>
       <div class="stat-value">${state.totalCount}</div>
@@ -521,8 +528,13 @@ async function loadUser(steamId) {
   state.sessions      = [];
   state.activeSessions = [];
 
-  // Reflect in URL without reloading
+  // Reflect in URL without reloading. A read token only belongs to the
+  // user it was issued for.
   const url = new URL(window.location.href);
+  if (url.searchParams.get('steamid') !== steamId) {
+    state.readToken = null;
+    url.searchParams.delete('token');
+  }
   url.searchParams.set('steamid', steamId);
   window.history.replaceState(null, '', url);
 
@@ -745,6 +757,7 @@ function init() {
   // ── Bootstrap from URL param ──
   const params  = new URLSearchParams(window.location.search);
   const steamId = params.get('steamid');
+  state.readToken = params.get('token');
   if (steamId) {
     document.getElementById('steamid-input').value = steamId;
     loadUser(steamId);
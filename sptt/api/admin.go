@@ -3,6 +3,7 @@ package api
 import (
 	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -10,6 +11,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sebun1/steamPlaytimeTracker/log"
 	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/webhook"
 )
 
 const (
@@ -45,23 +48,40 @@ func checkClearance(c *gin.Context, required int) bool {
 	return true
 }
 
+// parseAdminSteamID accepts a 64-bit id, STEAM_X:Y:Z, [U:1:N] or a
+// /profiles/<id> link. Vanity links need resolveAdminSteamID.
 func parseAdminSteamID(raw string) (sptt.SteamID, bool) {
-	raw = strings.TrimSpace(raw)
-	if raw == "" {
+	id, vanity, err := sptt.ParseSteamIDInput(raw)
+	if err != nil || vanity != "" {
 		return 0, false
 	}
-	v, err := strconv.ParseUint(raw, 10, 64)
-	if err != nil || v == 0 {
-		return 0, false
+	return id, true
+}
+
+// resolveAdminSteamID is parseAdminSteamID that also resolves
+// steamcommunity.com/id/<vanity> links through the Steam API.
+// On failure it writes the error response and returns false.
+func (a *SptAPI) resolveAdminSteamID(c *gin.Context, raw string) (sptt.SteamID, bool) {
+	id, err := sptt.ResolveSteamID(a.ctx, a.resolver, raw)
+	switch {
+	case err == nil:
+		return id, true
+	case errors.Is(err, sptt.ErrInvalidSteamID):
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+	case errors.Is(err, sptt.ErrVanityNotFound):
+		c.JSON(http.StatusNotFound, errResp("vanity_not_found"))
+	default:
+		log.Errorf("Error while trying to resolve steamid %q: %v", raw, err)
+		c.JSON(http.StatusBadGateway, errResp("steam_error"))
 	}
-	return sptt.SteamID(v), true
+	return 0, false
 }
 
 // ── Middleware ────────────────────────────────────────────────────────────────
 
 // AdminAuthMiddleware authenticates every request in the /admin group using
 // X-Admin-Name and X-Admin-Token headers.
-func AdminAuthMiddleware(db *sptt.DB) gin.HandlerFunc {
+func AdminAuthMiddleware(db sptt.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		name := c.GetHeader("X-Admin-Name")
 		token := c.GetHeader("X-Admin-Token")
@@ -78,15 +98,11 @@ func AdminAuthMiddleware(db *sptt.DB) gin.HandlerFunc {
 
 // ── Reload Helper ─────────────────────────────────────────────────────────────
 
-// reloadActiveUsers pushes a UserListUpdate notification on the channel and
-// stamps the metadata with the current time.
-func reloadActiveUsers(a *SptAPI) error {
-	select {
-	case a.notifChan <- sptt.NotifUserListUpdate():
-	default:
-		// Channel full — the monitor will still pick up the reload on its next
-		// tick, so this is not fatal.
-	}
+// reloadActiveUsers publishes the user change e on the bus and stamps the
+// metadata with the current time. The stamp is how the leader learns about
+// changes made through another instance.
+func reloadActiveUsers(a *SptAPI, e events.Event) error {
+	a.events.Publish(e)
 	return a.db.SetMetadata(a.ctx, sptt.MetaKeyLastUserReload, time.Now().UTC().Format(time.RFC3339))
 }
 
@@ -105,7 +121,7 @@ func (a *SptAPI) handleAdminReload(c *gin.Context) {
 	if !checkClearance(c, ClearanceAdminModifyDelete) {
 		return
 	}
-	if err := reloadActiveUsers(a); err != nil {
+	if err := reloadActiveUsers(a, events.UserListReloaded{}); err != nil {
 		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
 		return
 	}
@@ -140,19 +156,23 @@ func (a *SptAPI) handleAdminGetUsers(c *gin.Context) {
 	lastReload, _ := a.db.GetMetadata(a.ctx, sptt.MetaKeyLastUserReload)
 
 	type userRow struct {
-		SteamID  string `json:"steamid"`
-		Username string `json:"username"`
-		Active   bool   `json:"active"`
-		Public   bool   `json:"public"`
+		SteamID       string `json:"steamid"`
+		Username      string `json:"username"`
+		Active        bool   `json:"active"`
+		Public        bool   `json:"public"`
+		DiscordNotify bool   `json:"discord_notify"`
+		TimeZone      string `json:"timezone"`
 	}
 
 	rows := make([]userRow, 0, len(users))
 	for _, u := range users {
 		nextRow := userRow{
-			SteamID:  strconv.FormatUint(uint64(u.SteamID), 10),
-			Username: u.Username,
-			Active:   u.Active,
-			Public:   u.Public,
+			SteamID:       strconv.FormatUint(uint64(u.SteamID), 10),
+			Username:      u.Username,
+			Active:        u.Active,
+			Public:        u.Public,
+			DiscordNotify: u.DiscordNotify,
+			TimeZone:      u.TimeZone,
 		}
 		rows = append(rows, nextRow)
 	}
@@ -167,24 +187,27 @@ func (a *SptAPI) handleAdminGetUsers(c *gin.Context) {
 }
 
 // POST /admin/users/add
+//
+// steamid may be any format accepted by sptt.ParseSteamIDInput, including
+// vanity links. The resolved 64-bit id is returned as "steamid".
 func (a *SptAPI) handleAdminAddUser(c *gin.Context) {
 	if !checkClearance(c, ClearanceAdminBase) {
 		return
 	}
 
 	var body struct {
-		SteamID  string `json:"steamid"`
-		Username string `json:"username"`
-		Active   *bool  `json:"active"`
-		Public   *bool  `json:"public"`
+		SteamID       string `json:"steamid"`
+		Username      string `json:"username"`
+		Active        *bool  `json:"active"`
+		Public        *bool  `json:"public"`
+		DiscordNotify *bool  `json:"discord_notify"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil || strings.TrimSpace(body.Username) == "" {
 		c.JSON(http.StatusBadRequest, errResp("bad_request"))
 		return
 	}
-	id, ok := parseAdminSteamID(body.SteamID)
+	id, ok := a.resolveAdminSteamID(c, body.SteamID)
 	if !ok {
-		c.JSON(http.StatusBadRequest, errResp("bad_request"))
 		return
 	}
 
@@ -206,9 +229,15 @@ func (a *SptAPI) handleAdminAddUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
 		return
 	}
+	if body.DiscordNotify != nil && *body.DiscordNotify {
+		if err := a.db.ModifyUser(a.ctx, id, sptt.ModifyUserParams{DiscordNotify: body.DiscordNotify}); err != nil {
+			c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+			return
+		}
+	}
 
-	_ = reloadActiveUsers(a)
-	c.JSON(http.StatusOK, okResp())
+	_ = reloadActiveUsers(a, events.UserAdded{SteamID: id, Username: strings.TrimSpace(body.Username), Active: active})
+	c.JSON(http.StatusOK, gin.H{"ok": true, "reason": "", "steamid": strconv.FormatUint(uint64(id), 10)})
 }
 
 // POST /admin/users/remove
@@ -240,7 +269,7 @@ func (a *SptAPI) handleAdminRemoveUser(c *gin.Context) {
 		return
 	}
 
-	_ = reloadActiveUsers(a)
+	_ = reloadActiveUsers(a, events.UserRemoved{SteamID: id})
 	c.JSON(http.StatusOK, okResp())
 }
 
@@ -251,10 +280,12 @@ func (a *SptAPI) handleAdminModifyUser(c *gin.Context) {
 	}
 
 	var body struct {
-		SteamID  string  `json:"steamid"`
-		Username *string `json:"username"`
-		Active   *bool   `json:"active"`
-		Public   *bool   `json:"public"`
+		SteamID       string  `json:"steamid"`
+		Username      *string `json:"username"`
+		Active        *bool   `json:"active"`
+		Public        *bool   `json:"public"`
+		DiscordNotify *bool   `json:"discord_notify"`
+		TimeZone      *string `json:"timezone"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, errResp("bad_request"))
@@ -269,11 +300,24 @@ func (a *SptAPI) handleAdminModifyUser(c *gin.Context) {
 		trimmed := strings.TrimSpace(*body.Username)
 		body.Username = &trimmed
 	}
+	if body.TimeZone != nil {
+		// "" and "Local" load too but aren't a home timezone
+		tz := strings.TrimSpace(*body.TimeZone)
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "" || loc == time.Local {
+			c.JSON(http.StatusBadRequest, errResp("bad_timezone"))
+			return
+		}
+		name := loc.String()
+		body.TimeZone = &name
+	}
 
 	err := a.db.ModifyUser(a.ctx, id, sptt.ModifyUserParams{
-		Username: body.Username,
-		Active:   body.Active,
-		Public:   body.Public,
+		Username:      body.Username,
+		Active:        body.Active,
+		Public:        body.Public,
+		DiscordNotify: body.DiscordNotify,
+		TimeZone:      body.TimeZone,
 	})
 	if err != nil {
 		if errors.Is(err, sptt.ErrUserNotFound) {
@@ -284,7 +328,126 @@ func (a *SptAPI) handleAdminModifyUser(c *gin.Context) {
 		return
 	}
 
-	_ = reloadActiveUsers(a)
+	var e events.Event = events.UserListReloaded{}
+	if body.Active != nil && *body.Active {
+		e = events.UserResumed{SteamID: id}
+	} else if body.Active != nil {
+		e = events.UserPaused{SteamID: id}
+	}
+	_ = reloadActiveUsers(a, e)
+	c.JSON(http.StatusOK, okResp())
+}
+
+// POST /admin/users/read_token/create
+//
+// Issues a read token for the user, replacing any previous one. The token
+// lets the user read their own data while not public, send it as
+// X-Read-Token or ?token=. It is only returned here.
+func (a *SptAPI) handleAdminCreateReadToken(c *gin.Context) {
+	if !checkClearance(c, ClearanceAdminModifyDelete) {
+		return
+	}
+
+	var body struct {
+		SteamID string `json:"steamid"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+	id, ok := parseAdminSteamID(body.SteamID)
+	if !ok {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+	if _, err := a.db.GetUser(a.ctx, id); err != nil {
+		if errors.Is(err, sptt.ErrUserNotFound) {
+			c.JSON(http.StatusNotFound, errResp("not_found"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+
+	tokenHex, saltHex, secretHex, err := sptt.GenerateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+	if err := a.db.SetReadToken(a.ctx, id, saltHex, secretHex); err != nil {
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true, "reason": "", "token": tokenHex})
+}
+
+// POST /admin/users/read_token/delete
+func (a *SptAPI) handleAdminDeleteReadToken(c *gin.Context) {
+	if !checkClearance(c, ClearanceAdminModifyDelete) {
+		return
+	}
+
+	var body struct {
+		SteamID string `json:"steamid"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+	id, ok := parseAdminSteamID(body.SteamID)
+	if !ok {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+
+	if err := a.db.DeleteReadToken(a.ctx, id); err != nil {
+		if errors.Is(err, sptt.ErrReadTokenNotFound) {
+			c.JSON(http.StatusNotFound, errResp("not_found"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, okResp())
+}
+
+// POST /admin/poll
+//
+// Polls the given users, or every user if steamids is empty, on the
+// monitor's next tick instead of when they are due. The request reaches the
+// leader through the database, the event only starts the tick right away if
+// this instance is the leader.
+func (a *SptAPI) handleAdminPoll(c *gin.Context) {
+	if !checkClearance(c, ClearanceAdminBase) {
+		return
+	}
+
+	var body struct {
+		SteamIDs []string `json:"steamids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+
+	ids := make([]sptt.SteamID, 0, len(body.SteamIDs))
+	for _, raw := range body.SteamIDs {
+		id, ok := parseAdminSteamID(raw)
+		if !ok {
+			c.JSON(http.StatusBadRequest, errResp("bad_request"))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	if err := a.db.RequestPoll(a.ctx, ids); err != nil {
+		log.Errorf("RequestPoll DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+	a.events.Publish(events.ForcePoll{SteamIDs: ids})
 	c.JSON(http.StatusOK, okResp())
 }
 
@@ -391,3 +554,249 @@ func (a *SptAPI) handleAdminDeleteToken(c *gin.Context) {
 
 	c.JSON(http.StatusOK, okResp())
 }
+
+// ── Webhooks ──────────────────────────────────────────────────────────────────
+
+type webhookRow struct {
+	ID         int64    `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	SteamIDs   []string `json:"steamids"`
+	CreateDate string   `json:"create_date"`
+}
+
+type webhookDeliveryRow struct {
+	ID          int64  `json:"id"`
+	EventType   string `json:"event_type"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	NextAttempt string `json:"next_attempt,omitempty"` // only while pending
+	StatusCode  int    `json:"status_code"`
+	LastError   string `json:"last_error"`
+	CreateDate  string `json:"create_date"`
+}
+
+func toWebhookDeliveryRow(d sptt.WebhookDelivery) webhookDeliveryRow {
+	row := webhookDeliveryRow{
+		ID:         d.ID,
+		EventType:  d.EventType,
+		Status:     string(d.Status),
+		Attempts:   d.Attempts,
+		StatusCode: d.StatusCode,
+		LastError:  d.LastError,
+		CreateDate: d.CreateDate.UTC().Format(time.RFC3339),
+	}
+	if d.Status == sptt.WebhookPending {
+		row.NextAttempt = d.NextAttempt.UTC().Format(time.RFC3339)
+	}
+	return row
+}
+
+// getAdminWebhook loads the webhook with the given id. On failure it
+// writes the error response and returns false.
+func (a *SptAPI) getAdminWebhook(c *gin.Context, id int64) (sptt.Webhook, bool) {
+	w, err := a.db.GetWebhook(a.ctx, id)
+	if errors.Is(err, sptt.ErrWebhookNotFound) {
+		c.JSON(http.StatusNotFound, errResp("not_found"))
+		return w, false
+	}
+	if err != nil {
+		log.Errorf("GetWebhook DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return w, false
+	}
+	return w, true
+}
+
+// GET /admin/webhooks
+//
+// Secrets are only returned when a webhook is added.
+func (a *SptAPI) handleAdminListWebhooks(c *gin.Context) {
+	if !checkClearance(c, ClearanceAdminBase) {
+		return
+	}
+
+	webhooks, err := a.db.GetWebhooks(a.ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+
+	rows := make([]webhookRow, 0, len(webhooks))
+	for _, w := range webhooks {
+		row := webhookRow{
+			ID:         w.ID,
+			URL:        w.URL,
+			EventTypes: append([]string{}, w.EventTypes...),
+			SteamIDs:   make([]string, 0, len(w.SteamIDs)),
+			CreateDate: w.CreateDate.UTC().Format(time.RFC3339),
+		}
+		for _, id := range w.SteamIDs {
+			row.SteamIDs = append(row.SteamIDs, strconv.FormatUint(uint64(id), 10))
+		}
+		rows = append(rows, row)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true, "reason": "", "webhooks": rows})
+}
+
+// POST /admin/webhooks/add
+//
+// event_types and steamids are optional, empty subscribes to every session
+// event of every user. A secret is generated unless one is given, it is
+// returned once and signs every delivery.
+func (a *SptAPI) handleAdminAddWebhook(c *gin.Context) {
+	if !checkClearance(c, ClearanceAdminModifyDelete) {
+		return
+	}
+
+	var body struct {
+		URL        string   `json:"url"`
+		Secret     string   `json:"secret"`
+		EventTypes []string `json:"event_types"`
+		SteamIDs   []string `json:"steamids"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+	u, err := url.Parse(body.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, errResp("bad_url"))
+		return
+	}
+	for _, t := range body.EventTypes {
+		if !webhook.IsEventType(t) {
+			c.JSON(http.StatusBadRequest, errResp("bad_event_type"))
+			return
+		}
+	}
+
+	w := sptt.Webhook{URL: body.URL, Secret: body.Secret, EventTypes: body.EventTypes}
+	for _, raw := range body.SteamIDs {
+		id, ok := parseAdminSteamID(raw)
+		if !ok {
+			c.JSON(http.StatusBadRequest, errResp("bad_request"))
+			return
+		}
+		w.SteamIDs = append(w.SteamIDs, id)
+	}
+	if w.Secret == "" {
+		if w.Secret, err = webhook.GenerateSecret(); err != nil {
+			c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+			return
+		}
+	}
+
+	id, err := a.db.AddWebhook(a.ctx, w)
+	if err != nil {
+		log.Errorf("AddWebhook DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true, "reason": "", "id": id, "secret": w.Secret})
+}
+
+// POST /admin/webhooks/remove
+//
+// Also deletes the webhook's delivery log and pending retries.
+func (a *SptAPI) handleAdminRemoveWebhook(c *gin.Context) {
+	if !checkClearance(c, ClearanceAdminModifyDelete) {
+		return
+	}
+
+	var body struct {
+		ID int64 `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+
+	if err := a.db.RemoveWebhook(a.ctx, body.ID); err != nil {
+		if errors.Is(err, sptt.ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, errResp("not_found"))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, okResp())
+}
+
+// POST /admin/webhooks/test
+//
+// Sends a ping delivery right away and returns its outcome. Failed pings
+// are not retried. ok is true even if the receiver failed.
+func (a *SptAPI) handleAdminTestWebhook(c *gin.Context) {
+	if !checkClearance(c, ClearanceAdminBase) {
+		return
+	}
+
+	var body struct {
+		ID int64 `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+	w, ok := a.getAdminWebhook(c, body.ID)
+	if !ok {
+		return
+	}
+
+	delivery, err := webhook.Ping(c.Request.Context(), a.db, a.webhooks, w)
+	if err != nil && delivery.ID == 0 {
+		log.Errorf("Error while sending test delivery to webhook %d: %v", w.ID, err)
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true, "reason": "", "delivery": toWebhookDeliveryRow(delivery)})
+}
+
+// GET /admin/webhooks/deliveries?id=1&limit=50&offset=0
+//
+// Delivery log of a webhook, newest first. Finished deliveries are kept
+// for 30 days.
+func (a *SptAPI) handleAdminWebhookDeliveries(c *gin.Context) {
+	if !checkClearance(c, ClearanceAdminBase) {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Query("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errResp("bad_request"))
+		return
+	}
+	limit := 50
+	offset := 0
+	if v := c.Query("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+	if _, ok := a.getAdminWebhook(c, id); !ok {
+		return
+	}
+
+	deliveries, err := a.db.GetWebhookDeliveries(a.ctx, id, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errResp("internal_error"))
+		return
+	}
+
+	rows := make([]webhookDeliveryRow, 0, len(deliveries))
+	for _, d := range deliveries {
+		rows = append(rows, toWebhookDeliveryRow(d))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ok": true, "reason": "", "deliveries": rows})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+)
+
+// adminClient sends authenticated admin requests to srv.
+type adminClient struct {
+	t     *testing.T
+	srv   *httptest.Server
+	token string
+}
+
+func newAdminClient(t *testing.T, a *SptAPI, srv *httptest.Server, clearance int) *adminClient {
+	t.Helper()
+	token, salt, secret, err := sptt.GenerateToken()
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if err := a.db.CreateAuthToken(a.ctx, "test", salt, secret, clearance); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	return &adminClient{t: t, srv: srv, token: token}
+}
+
+// do sends body as JSON, or no body if it is nil, and decodes the response
+// into out.
+func (c *adminClient) do(method, path string, body, out any) int {
+	c.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, c.srv.URL+path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Admin-Name", "test")
+	req.Header.Set("X-Admin-Token", c.token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("Expected nil, got %v", err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func TestAdminWebhooks(t *testing.T) {
+	a, srv := newTestAPI(t)
+	admin := newAdminClient(t, a, srv, ClearanceAdminModifyDelete+1)
+
+	received := make(chan string, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Sptt-Event")
+	}))
+	defer hook.Close()
+
+	for _, body := range []map[string]any{
+		{"url": "ftp://example.com"},
+		{"url": hook.URL, "event_types": []string{"user_added"}},
+		{"url": hook.URL, "steamids": []string{"alice"}},
+	} {
+		if code := admin.do(http.MethodPost, "/admin/webhooks/add", body, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %v, got %d", body, code)
+		}
+	}
+
+	var added struct {
+		ID     int64  `json:"id"`
+		Secret string `json:"secret"`
+	}
+	body := map[string]any{"url": hook.URL, "event_types": []string{"session_started"}, "steamids": []string{strconv.FormatUint(uint64(alice), 10)}}
+	if code := admin.do(http.MethodPost, "/admin/webhooks/add", body, &added); code != http.StatusOK || added.Secret == "" {
+		t.Fatalf("Expected 200 with a secret, got %d %+v", code, added)
+	}
+
+	var list struct {
+		Webhooks []webhookRow `json:"webhooks"`
+	}
+	admin.do(http.MethodGet, "/admin/webhooks", nil, &list)
+	if len(list.Webhooks) != 1 || list.Webhooks[0].ID != added.ID || list.Webhooks[0].SteamIDs[0] != strconv.FormatUint(uint64(alice), 10) {
+		t.Errorf("Expected webhook %d, got %+v", added.ID, list.Webhooks)
+	}
+
+	var tested struct {
+		Delivery webhookDeliveryRow `json:"delivery"`
+	}
+	if code := admin.do(http.MethodPost, "/admin/webhooks/test", map[string]any{"id": added.ID}, &tested); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	if tested.Delivery.Status != string(sptt.WebhookDelivered) || <-received != "ping" {
+		t.Errorf("Expected a delivered ping, got %+v", tested.Delivery)
+	}
+
+	var log struct {
+		Deliveries []webhookDeliveryRow `json:"deliveries"`
+	}
+	admin.do(http.MethodGet, "/admin/webhooks/deliveries?id="+strconv.FormatInt(added.ID, 10), nil, &log)
+	if len(log.Deliveries) != 1 || log.Deliveries[0].ID != tested.Delivery.ID {
+		t.Errorf("Expected the ping in the log, got %+v", log.Deliveries)
+	}
+
+	if code := admin.do(http.MethodPost, "/admin/webhooks/remove", map[string]any{"id": added.ID}, nil); code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", code)
+	}
+	if code := admin.do(http.MethodPost, "/admin/webhooks/test", map[string]any{"id": added.ID}, nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 after removal, got %d", code)
+	}
+}
+
+func TestAdminPollOnStandby(t *testing.T) {
+	leader, _ := newTestAPI(t)
+	// Shares the store but not the bus, like an instance that isn't the leader
+	standby := NewSptAPI(leader.ctx, leader.db, nil, events.NewBus(), &sync.WaitGroup{}, "", "*")
+	srv := httptest.NewServer(standby.router())
+	defer srv.Close()
+	admin := newAdminClient(t, standby, srv, ClearanceAdminBase)
+
+	body := map[string][]string{"steamids": {strconv.FormatUint(uint64(alice), 10)}}
+	if code := admin.do(http.MethodPost, "/admin/poll", body, nil); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+
+	req, err := leader.db.TakePollRequest(leader.ctx)
+	if err != nil || req == nil || len(req.SteamIDs) != 1 || req.SteamIDs[0] != alice {
+		t.Errorf("Expected a poll request for alice, got %+v (%v)", req, err)
+	}
+}
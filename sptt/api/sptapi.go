@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"sync"
@@ -10,6 +11,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sebun1/steamPlaytimeTracker/log"
 	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/webhook"
 )
 
 const (
@@ -19,21 +22,26 @@ const (
 
 type SptAPI struct {
 	ctx        context.Context
-	db         *sptt.DB
-	notifChan  chan sptt.Notif
+	db         sptt.Store
+	resolver   sptt.VanityResolver
+	events     *events.Bus
 	wg         *sync.WaitGroup
 	addr       string
 	corsOrigin string
+	webhooks   *http.Client // sends test deliveries
+	public     publicCache  // public flags for the event streams
 }
 
-func NewSptAPI(ctx context.Context, db *sptt.DB, notifChan chan sptt.Notif, wg *sync.WaitGroup, addr string, corsOrigin string) *SptAPI {
+func NewSptAPI(ctx context.Context, db sptt.Store, resolver sptt.VanityResolver, bus *events.Bus, wg *sync.WaitGroup, addr string, corsOrigin string) *SptAPI {
 	return &SptAPI{
 		ctx:        ctx,
 		db:         db,
-		notifChan:  notifChan,
+		resolver:   resolver,
+		events:     bus,
 		wg:         wg,
 		addr:       addr,
 		corsOrigin: corsOrigin,
+		webhooks:   &http.Client{Timeout: webhook.DefaultTimeout},
 	}
 }
 
@@ -44,7 +52,7 @@ func corsMiddleware(origin string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", origin)
 		c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, X-Admin-Name, X-Admin-Token")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, X-Admin-Name, X-Admin-Token, X-Read-Token")
 
 		if c.Request.Method == http.MethodOptions {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -57,6 +65,28 @@ func corsMiddleware(origin string) gin.HandlerFunc {
 func (a *SptAPI) Run() {
 	defer a.wg.Done()
 
+	srv := &http.Server{
+		Addr:    a.addr,
+		Handler: a.router(),
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Errorf("API server error: %v", err)
+		}
+	}()
+
+	<-a.ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("API server shutdown error: %v", err)
+	}
+}
+
+func (a *SptAPI) router() *gin.Engine {
 	r := gin.Default()
 	r.Use(corsMiddleware(a.corsOrigin))
 
@@ -65,45 +95,127 @@ func (a *SptAPI) Run() {
 	})
 
 	users := r.Group("/users/:id")
+	users.Use(a.userAccessMiddleware)
 	{
 		users.GET("/sessions", a.getSessions)
 		users.GET("/active_sessions", a.getActiveSessions)
 		users.GET("/stats", a.getUserStats)
+		users.GET("/playtime/series", a.getPlaytimeSeries)
+		users.GET("/coplay", a.getUserCoPlay)
 	}
 
+	r.GET("/games/:appid", a.getGame)
+	r.GET("/games/:appid/leaderboard", a.getGameLeaderboard)
+	r.GET("/leaderboard", a.getLeaderboard)
+	r.GET("/coplay/sessions", a.getCoPlaySessions)
+	r.GET("/downtime", a.getDowntime)
+	r.GET("/events", a.getEvents)
+	r.GET("/events/ws", a.getEventsWS)
+
 	admin := r.Group("/admin")
 	admin.Use(AdminAuthMiddleware(a.db))
 	{
 		admin.GET("/test", a.handleAdminTest)
 		admin.POST("/reload", a.handleAdminReload)
+		admin.POST("/poll", a.handleAdminPoll)
 		admin.GET("/users", a.handleAdminGetUsers)
 		admin.POST("/users/add", a.handleAdminAddUser)
 		admin.POST("/users/remove", a.handleAdminRemoveUser)
 		admin.POST("/users/modify", a.handleAdminModifyUser)
+		admin.POST("/users/read_token/create", a.handleAdminCreateReadToken)
+		admin.POST("/users/read_token/delete", a.handleAdminDeleteReadToken)
 		admin.GET("/tokens", a.handleAdminListTokens)
 		admin.POST("/tokens/create", a.handleAdminCreateToken)
 		admin.POST("/tokens/delete", a.handleAdminDeleteToken)
+		admin.GET("/webhooks", a.handleAdminListWebhooks)
+		admin.POST("/webhooks/add", a.handleAdminAddWebhook)
+		admin.POST("/webhooks/remove", a.handleAdminRemoveWebhook)
+		admin.POST("/webhooks/test", a.handleAdminTestWebhook)
+		admin.GET("/webhooks/deliveries", a.handleAdminWebhookDeliveries)
 	}
 
-	srv := &http.Server{
-		Addr:    a.addr,
-		Handler: r,
+	return r
+}
+
+// userAccessMiddleware guards the /users/:id routes. Steamids that aren't
+// registered get a 404, and so do users that aren't public unless the
+// caller sends an admin token or the user's read token. A user the caller
+// may not read looks the same as a user that doesn't exist.
+func (a *SptAPI) userAccessMiddleware(c *gin.Context) {
+	id, ok := parseSteamID(c)
+	if !ok {
+		c.Abort()
+		return
 	}
 
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Errorf("API server error: %v", err)
+	user, allowed, err := a.canReadUser(c, id)
+	if err != nil {
+		log.Errorf("Error while checking access to user %v: %v", id, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if !allowed {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	c.Set("user", user)
+	c.Next()
+}
+
+// canReadUser returns user id and whether the caller may read their data.
+func (a *SptAPI) canReadUser(c *gin.Context, id sptt.SteamID) (sptt.User, bool, error) {
+	user, err := a.db.GetUser(a.ctx, id)
+	if errors.Is(err, sptt.ErrUserNotFound) {
+		return user, false, nil
+	}
+	if err != nil {
+		return user, false, err
+	}
+	if user.Public {
+		return user, true, nil
+	}
+
+	if name := c.GetHeader("X-Admin-Name"); name != "" {
+		if _, ok := sptt.Authenticate(a.db, name, c.GetHeader("X-Admin-Token")); ok {
+			return user, true, nil
 		}
-	}()
+	}
+	if token := readToken(c); token != "" {
+		return user, sptt.AuthenticateReadToken(a.ctx, a.db, id, token), nil
+	}
+	return user, false, nil
+}
 
-	<-a.ctx.Done()
+// userLocation returns the time zone aggregations of the user are in: the
+// tz query param if set, otherwise the user's home timezone. It responds
+// with 400 and returns false if tz is invalid.
+func userLocation(c *gin.Context) (*time.Location, bool) {
+	if tz := c.Query("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tz"})
+			return nil, false
+		}
+		return loc, true
+	}
+	v, _ := c.Get("user")
+	user, _ := v.(sptt.User)
+	return user.Location(), true
+}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// formatTime formats t as RFC3339 in UTC, whatever location the driver
+// returned it in.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
 
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Errorf("API server shutdown error: %v", err)
+// readToken returns the read token sent with X-Read-Token or, for clients
+// that can't set headers like EventSource, the token query param.
+func readToken(c *gin.Context) string {
+	if token := c.GetHeader("X-Read-Token"); token != "" {
+		return token
 	}
+	return c.Query("token")
 }
 
 // parseSteamID extracts and validates the :id path param as a SteamID.
@@ -117,6 +229,17 @@ func parseSteamID(c *gin.Context) (sptt.SteamID, bool) {
 	return sptt.SteamID(v), true
 }
 
+// parseAppID extracts and validates the :appid path param as an AppID.
+func parseAppID(c *gin.Context) (sptt.AppID, bool) {
+	raw := c.Param("appid")
+	v, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid app id"})
+		return 0, false
+	}
+	return sptt.AppID(v), true
+}
+
 // parsePage parses ?page= (0-based) and ?page_size= query params.
 func parsePage(c *gin.Context) (page int32, pageSize int32) {
 	page = 0
@@ -144,15 +267,40 @@ type sessionResponse struct {
 	UTCStart        string `json:"utc_start"`
 	UTCEnd          string `json:"utc_end"`
 	PlaytimeForever int32  `json:"playtime_forever"`
+	PlaytimeSource  string `json:"playtime_source"`
+	Recovered       bool   `json:"recovered"` // utc_end is estimated after downtime
 }
 
 type activeSessionResponse struct {
 	SteamID         uint64 `json:"steam_id"`
 	AppID           uint32 `json:"app_id"`
 	UTCStart        string `json:"utc_start"`
+	LastSeen        string `json:"last_seen"`
 	PlaytimeForever int32  `json:"playtime_forever"`
 }
 
+func toSessionResponse(s sptt.Session) sessionResponse {
+	return sessionResponse{
+		SteamID:         uint64(s.SteamID),
+		AppID:           uint32(s.AppID),
+		UTCStart:        formatTime(s.UTCStart),
+		UTCEnd:          formatTime(s.UTCEnd),
+		PlaytimeForever: s.PlaytimeForever,
+		PlaytimeSource:  string(s.PlaytimeSource),
+		Recovered:       s.Recovered,
+	}
+}
+
+func toActiveSessionResponse(s sptt.ActiveSession) activeSessionResponse {
+	return activeSessionResponse{
+		SteamID:         uint64(s.SteamID),
+		AppID:           uint32(s.AppID),
+		UTCStart:        formatTime(s.UTCStart),
+		LastSeen:        formatTime(s.LastSeen),
+		PlaytimeForever: s.PlaytimeForever,
+	}
+}
+
 type paginatedSessions struct {
 	Data       []sessionResponse `json:"data"`
 	Page       int32             `json:"page"`
@@ -262,13 +410,7 @@ func (a *SptAPI) getSessions(c *gin.Context) {
 
 	data := make([]sessionResponse, 0, len(sessions))
 	for _, s := range sessions {
-		data = append(data, sessionResponse{
-			SteamID:         uint64(s.SteamID),
-			AppID:           uint32(s.AppID),
-			UTCStart:        s.UTCStart.Format("2006-01-02T15:04:05Z"),
-			UTCEnd:          s.UTCEnd.Format("2006-01-02T15:04:05Z"),
-			PlaytimeForever: s.PlaytimeForever,
-		})
+		data = append(data, toSessionResponse(s))
 	}
 
 	totalPages := int32((totalCount + int64(q.PageSize) - 1) / int64(q.PageSize))
@@ -298,38 +440,554 @@ func (a *SptAPI) getActiveSessions(c *gin.Context) {
 
 	data := make([]activeSessionResponse, 0, len(sessionsMap))
 	for _, s := range sessionsMap {
-		data = append(data, activeSessionResponse{
-			SteamID:         uint64(s.SteamID),
-			AppID:           uint32(s.AppID),
-			UTCStart:        s.UTCStart.Format("2006-01-02T15:04:05Z"),
-			PlaytimeForever: s.PlaytimeForever,
-		})
+		data = append(data, toActiveSessionResponse(s))
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }
 
 type userStatsResponse struct {
-	SteamID       uint64 `json:"steam_id"`
-	TotalSessions int64  `json:"total_sessions"`
+	SteamID           uint64              `json:"steam_id"`
+	TZ                string              `json:"tz"`
+	TotalSessions     int64               `json:"total_sessions"`    // matching the filter, as in /sessions
+	SessionsInRange   int64               `json:"sessions_in_range"` // the sessions the minutes are summed over
+	TotalMinutes      int64               `json:"total_minutes"`
+	AvgSessionMinutes int64               `json:"avg_session_minutes"`
+	LongestSession    *sessionResponse    `json:"longest_session"`
+	Games             []gameStatsResponse `json:"games"`
+	WeekdayMinutes    [7]int64            `json:"weekday_minutes"` // Sunday first
+	HourMinutes       [24]int64           `json:"hour_minutes"`    // in tz
+	FirstSeen         *string             `json:"first_seen"`
+	LastSeen          *string             `json:"last_seen"`
+	CurrentStreakDays int                 `json:"current_streak_days"`
+	LongestStreakDays int                 `json:"longest_streak_days"`
+}
+
+type gameStatsResponse struct {
+	AppID    uint32 `json:"app_id"`
+	Name     string `json:"name"`
+	Sessions int64  `json:"sessions"`
+	Minutes  int64  `json:"minutes"`
+}
+
+func minutes(d time.Duration) int64 {
+	return int64(d / time.Minute)
 }
 
 // GET /users/:id/stats
+//
+// Query params: app_id, utcstart_from, utcstart_to, utcend_from, utcend_to,
+// playtime_min, playtime_max, tz (IANA name, default the user's timezone).
+// total_sessions counts the sessions matching the params exactly like
+// /sessions does. Everything else takes the time bounds as one range, the
+// later lower and earlier upper bound, and only counts the part of a session
+// inside it: sessions_in_range leaves out sessions without time in it,
+// zero-length ones included. Weekdays, hours and streak days are those of tz.
 func (a *SptAPI) getUserStats(c *gin.Context) {
 	id, ok := parseSteamID(c)
 	if !ok {
 		return
 	}
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
 
-	totalSessions, err := a.db.GetSessionCount(a.ctx, id, sptt.SessionFilter{})
+	filter := parseSessionQuery(c).Filter
+	total, err := a.db.GetSessionCount(a.ctx, id, filter)
+	if err != nil {
+		log.Errorf("GetSessionCount DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get stats"})
+		return
+	}
+	stats, err := a.db.GetUserStats(a.ctx, id, filter, loc, time.Now())
 	if err != nil {
 		log.Errorf("GetUserStats DB error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get stats"})
 		return
 	}
 
-	c.JSON(http.StatusOK, userStatsResponse{
-		SteamID:       uint64(id),
-		TotalSessions: totalSessions,
+	resp := userStatsResponse{
+		SteamID:           uint64(id),
+		TZ:                loc.String(),
+		TotalSessions:     total,
+		SessionsInRange:   stats.Sessions,
+		TotalMinutes:      minutes(stats.Total),
+		AvgSessionMinutes: minutes(stats.Average),
+		Games:             make([]gameStatsResponse, 0, len(stats.Games)),
+		CurrentStreakDays: stats.CurrentStreak,
+		LongestStreakDays: stats.LongestStreak,
+	}
+	if stats.Longest != nil {
+		longest := toSessionResponse(*stats.Longest)
+		resp.LongestSession = &longest
+	}
+	for _, g := range stats.Games {
+		resp.Games = append(resp.Games, gameStatsResponse{
+			AppID:    uint32(g.AppID),
+			Name:     g.Name,
+			Sessions: g.Sessions,
+			Minutes:  minutes(g.Playtime),
+		})
+	}
+	for i, d := range stats.Weekdays {
+		resp.WeekdayMinutes[i] = minutes(d)
+	}
+	for i, d := range stats.Hours {
+		resp.HourMinutes[i] = minutes(d)
+	}
+	if !stats.FirstSeen.IsZero() {
+		first, last := formatTime(stats.FirstSeen), formatTime(stats.LastSeen)
+		resp.FirstSeen, resp.LastSeen = &first, &last
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+type seriesResponse struct {
+	SteamID uint64                `json:"steam_id"`
+	Bucket  string                `json:"bucket"`
+	TZ      string                `json:"tz"`
+	Data    []seriesPointResponse `json:"data"`
+}
+
+type seriesPointResponse struct {
+	Start   string               `json:"start"`
+	End     string               `json:"end"`
+	Minutes int64                `json:"minutes"`
+	Apps    []appMinutesResponse `json:"apps,omitempty"`
+}
+
+type appMinutesResponse struct {
+	AppID   uint32 `json:"app_id"`
+	Minutes int64  `json:"minutes"`
+}
+
+// defaultSeriesRange is how many buckets GET /users/:id/playtime/series
+// returns without from.
+var defaultSeriesRange = map[sptt.Bucket]int{
+	sptt.BucketDay:   365,
+	sptt.BucketWeek:  52,
+	sptt.BucketMonth: 12,
+}
+
+// parseSeriesTime accepts RFC3339 or a date, which is midnight in loc.
+func parseSeriesTime(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, loc); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// GET /users/:id/playtime/series
+//
+// Query params: bucket (day|week|month, default day), from, to (RFC3339 or
+// YYYY-MM-DD, default the last year of days, 52 weeks or 12 months), tz
+// (IANA name, default the user's timezone), app_id, by_app (true to break
+// buckets down per game). Buckets start at midnight in tz, weeks on Monday.
+// Every bucket up to to is returned, a session counts in each bucket with
+// the part inside it.
+func (a *SptAPI) getPlaytimeSeries(c *gin.Context) {
+	id, ok := parseSteamID(c)
+	if !ok {
+		return
+	}
+
+	bucket := sptt.Bucket(c.DefaultQuery("bucket", string(sptt.BucketDay)))
+	count, ok := defaultSeriesRange[bucket]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucket"})
+		return
+	}
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
+
+	var err error
+	q := sptt.SeriesQuery{Bucket: bucket, To: time.Now().In(loc), Location: loc, ByApp: c.Query("by_app") == "true"}
+	if v := c.Query("to"); v != "" {
+		if q.To, err = parseSeriesTime(v, loc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+	}
+	q.From = bucket.Start(q.To.In(loc))
+	for i := 1; i < count; i++ {
+		q.From = bucket.Start(q.From.Add(-time.Hour))
+	}
+	if v := c.Query("from"); v != "" {
+		if q.From, err = parseSeriesTime(v, loc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+	}
+	if v := c.Query("app_id"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid app_id"})
+			return
+		}
+		appid := sptt.AppID(n)
+		q.AppID = &appid
+	}
+
+	points, err := a.db.GetPlaytimeSeries(a.ctx, id, q)
+	if errors.Is(err, sptt.ErrTooManyBuckets) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "range too long for bucket"})
+		return
+	}
+	if err != nil {
+		log.Errorf("GetPlaytimeSeries DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get playtime series"})
+		return
+	}
+
+	resp := seriesResponse{
+		SteamID: uint64(id),
+		Bucket:  string(bucket),
+		TZ:      loc.String(),
+		Data:    make([]seriesPointResponse, 0, len(points)),
+	}
+	for _, p := range points {
+		point := seriesPointResponse{
+			Start:   p.Start.Format(time.RFC3339),
+			End:     p.End.Format(time.RFC3339),
+			Minutes: minutes(p.Playtime),
+		}
+		if q.ByApp {
+			point.Apps = make([]appMinutesResponse, 0, len(p.Apps))
+			for _, app := range p.Apps {
+				point.Apps = append(point.Apps, appMinutesResponse{AppID: uint32(app.AppID), Minutes: minutes(app.Playtime)})
+			}
+		}
+		resp.Data = append(resp.Data, point)
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+type gameResponse struct {
+	AppID           uint32 `json:"app_id"`
+	Name            string `json:"name"`
+	Publisher       string `json:"publisher"`
+	Developer       string `json:"developer"`
+	HeaderImage     string `json:"header_image"`
+	Recommendations uint32 `json:"recommendations"`
+}
+
+// GET /games/:appid
+//
+// Serves store metadata from the game catalog. Games are added to the
+// catalog in the background once they show up in a session.
+func (a *SptAPI) getGame(c *gin.Context) {
+	appid, ok := parseAppID(c)
+	if !ok {
+		return
+	}
+
+	game, err := a.db.GetGameCache(a.ctx, appid)
+	if err != nil {
+		if errors.Is(err, sptt.ErrGameNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+			return
+		}
+		log.Errorf("GetGameCache DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get game"})
+		return
+	}
+
+	if !game.Available {
+		c.JSON(http.StatusNotFound, gin.H{"error": "game not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gameResponse{
+		AppID:           uint32(game.AppID),
+		Name:            game.Name,
+		Publisher:       game.Publisher,
+		Developer:       game.Developer,
+		HeaderImage:     game.HeaderImage,
+		Recommendations: game.Recommendations,
+	})
+}
+
+type leaderboardEntryResponse struct {
+	Rank     int64  `json:"rank"`
+	SteamID  uint64 `json:"steam_id"`
+	Username string `json:"username"`
+	Sessions int64  `json:"sessions"`
+	Minutes  int64  `json:"minutes"`
+}
+
+type paginatedLeaderboard struct {
+	Data       []leaderboardEntryResponse `json:"data"`
+	Page       int32                      `json:"page"`
+	PageSize   int32                      `json:"page_size"`
+	TotalCount int64                      `json:"total_count"`
+	TotalPages int32                      `json:"total_pages"`
+}
+
+// parseTimeRange parses the optional from and to query params (RFC3339).
+// It responds with 400 and returns false if either is invalid.
+func parseTimeRange(c *gin.Context) (from, to *time.Time, ok bool) {
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return nil, nil, false
+		}
+		from = &t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return nil, nil, false
+		}
+		to = &t
+	}
+	return from, to, true
+}
+
+// GET /leaderboard
+//
+// Query params: page, page_size, from, to (RFC3339). Ranks public users by
+// the minutes they played across all games.
+func (a *SptAPI) getLeaderboard(c *gin.Context) {
+	a.leaderboard(c, nil)
+}
+
+// GET /games/:appid/leaderboard
+//
+// Query params: page, page_size, from, to (RFC3339). Ranks public users by
+// the minutes they played the game.
+func (a *SptAPI) getGameLeaderboard(c *gin.Context) {
+	appid, ok := parseAppID(c)
+	if !ok {
+		return
+	}
+	a.leaderboard(c, &appid)
+}
+
+func (a *SptAPI) leaderboard(c *gin.Context, appid *sptt.AppID) {
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	page, pageSize := parsePage(c)
+
+	entries, total, err := a.db.GetLeaderboard(a.ctx, sptt.LeaderboardQuery{
+		AppID:    appid,
+		From:     from,
+		To:       to,
+		Page:     page,
+		PageSize: pageSize,
 	})
+	if err != nil {
+		log.Errorf("GetLeaderboard DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get leaderboard"})
+		return
+	}
+
+	data := make([]leaderboardEntryResponse, 0, len(entries))
+	for _, e := range entries {
+		data = append(data, leaderboardEntryResponse{
+			Rank:     e.Rank,
+			SteamID:  uint64(e.SteamID),
+			Username: e.Username,
+			Sessions: e.Sessions,
+			Minutes:  minutes(e.Playtime),
+		})
+	}
+
+	c.JSON(http.StatusOK, paginatedLeaderboard{
+		Data:       data,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalCount: total,
+		TotalPages: int32((total + int64(pageSize) - 1) / int64(pageSize)),
+	})
+}
+
+type coPlayPartnerResponse struct {
+	SteamID       uint64 `json:"steam_id"`
+	Username      string `json:"username"`
+	Sessions      int64  `json:"sessions"`
+	SharedMinutes int64  `json:"shared_minutes"`
+	LastSeen      string `json:"last_seen"`
+}
+
+type userCoPlayResponse struct {
+	SteamID            uint64                  `json:"steam_id"`
+	TotalSharedMinutes int64                   `json:"total_shared_minutes"` // with anyone, overlaps counted once
+	Partners           []coPlayPartnerResponse `json:"partners"`
+}
+
+type coPlaySessionResponse struct {
+	AppID     uint32    `json:"app_id"`
+	SteamIDs  [2]uint64 `json:"steam_ids"`
+	Usernames [2]string `json:"usernames"`
+	UTCStart  string    `json:"utc_start"`
+	UTCEnd    string    `json:"utc_end"`
+	Minutes   int64     `json:"minutes"`
+}
+
+type paginatedCoPlaySessions struct {
+	Data       []coPlaySessionResponse `json:"data"`
+	Page       int32                   `json:"page"`
+	PageSize   int32                   `json:"page_size"`
+	TotalCount int64                   `json:"total_count"`
+	TotalPages int32                   `json:"total_pages"`
+}
+
+// parseCoPlayQuery parses the from, to (RFC3339) and app_id query params.
+// It responds with 400 and returns false if any is invalid.
+func parseCoPlayQuery(c *gin.Context) (sptt.CoPlayQuery, bool) {
+	var q sptt.CoPlayQuery
+	var ok bool
+	if q.From, q.To, ok = parseTimeRange(c); !ok {
+		return q, false
+	}
+	if v := c.Query("app_id"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid app_id"})
+			return q, false
+		}
+		appid := sptt.AppID(n)
+		q.AppID = &appid
+	}
+	return q, true
+}
+
+// GET /users/:id/coplay
+//
+// Query params: from, to (RFC3339), app_id. Lists the public users the user
+// played the same game with at the same time, most shared minutes first.
+// Only the part of a session inside the range counts.
+func (a *SptAPI) getUserCoPlay(c *gin.Context) {
+	id, ok := parseSteamID(c)
+	if !ok {
+		return
+	}
+	q, ok := parseCoPlayQuery(c)
+	if !ok {
+		return
+	}
+
+	partners, total, err := a.db.GetCoPlayPartners(a.ctx, id, q)
+	if err != nil {
+		log.Errorf("GetCoPlayPartners DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get co-play"})
+		return
+	}
+
+	resp := userCoPlayResponse{
+		SteamID:            uint64(id),
+		TotalSharedMinutes: minutes(total),
+		Partners:           make([]coPlayPartnerResponse, 0, len(partners)),
+	}
+	for _, p := range partners {
+		resp.Partners = append(resp.Partners, coPlayPartnerResponse{
+			SteamID:       uint64(p.SteamID),
+			Username:      p.Username,
+			Sessions:      p.Sessions,
+			SharedMinutes: minutes(p.Shared),
+			LastSeen:      formatTime(p.LastSeen),
+		})
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /coplay/sessions
+//
+// Query params: page, page_size, from, to (RFC3339), app_id. Lists the times
+// two public users played the same game together, latest first, clipped to
+// the range.
+func (a *SptAPI) getCoPlaySessions(c *gin.Context) {
+	q, ok := parseCoPlayQuery(c)
+	if !ok {
+		return
+	}
+	q.Page, q.PageSize = parsePage(c)
+
+	sessions, total, err := a.db.GetCoPlaySessions(a.ctx, q)
+	if err != nil {
+		log.Errorf("GetCoPlaySessions DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get co-play sessions"})
+		return
+	}
+
+	data := make([]coPlaySessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		data = append(data, coPlaySessionResponse{
+			AppID:     uint32(s.AppID),
+			SteamIDs:  [2]uint64{uint64(s.SteamIDs[0]), uint64(s.SteamIDs[1])},
+			Usernames: s.Usernames,
+			UTCStart:  formatTime(s.UTCStart),
+			UTCEnd:    formatTime(s.UTCEnd),
+			Minutes:   minutes(s.UTCEnd.Sub(s.UTCStart)),
+		})
+	}
+
+	c.JSON(http.StatusOK, paginatedCoPlaySessions{
+		Data:       data,
+		Page:       q.Page,
+		PageSize:   q.PageSize,
+		TotalCount: total,
+		TotalPages: int32((total + int64(q.PageSize) - 1) / int64(q.PageSize)),
+	})
+}
+
+type downtimeResponse struct {
+	UTCStart string `json:"utc_start"`
+	UTCEnd   string `json:"utc_end"`
+}
+
+// defaultDowntimeRange is how far back GET /downtime looks without from.
+const defaultDowntimeRange = 30 * 24 * time.Hour
+
+// GET /downtime
+//
+// Query params: from, to (RFC3339, default the last 30 days).
+// Lists the windows the tracker was down, sessions in them are missing or
+// recovered.
+func (a *SptAPI) getDowntime(c *gin.Context) {
+	to := time.Now().UTC()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"})
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultDowntimeRange)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"})
+			return
+		}
+		from = t
+	}
+
+	downtimes, err := a.db.GetDowntimes(a.ctx, from, to)
+	if err != nil {
+		log.Errorf("GetDowntimes DB error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get downtime"})
+		return
+	}
+
+	data := make([]downtimeResponse, 0, len(downtimes))
+	for _, dt := range downtimes {
+		data = append(data, downtimeResponse{
+			UTCStart: formatTime(dt.UTCStart),
+			UTCEnd:   formatTime(dt.UTCEnd),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
 }
@@ -0,0 +1,340 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+func get(t *testing.T, url string, header http.Header) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestUserAccess(t *testing.T) {
+	a, srv := newTestAPI(t)
+	aliceID := strconv.FormatUint(uint64(alice), 10)
+	bobID := strconv.FormatUint(uint64(bob), 10)
+	admin := newAdminClient(t, a, srv, ClearanceAdminModifyDelete)
+
+	var created struct {
+		OK    bool   `json:"ok"`
+		Token string `json:"token"`
+	}
+	if code := admin.do(http.MethodPost, "/admin/users/read_token/create", map[string]string{"steamid": bobID}, &created); code != http.StatusOK || created.Token == "" {
+		t.Fatalf("Expected a token, got %d %+v", code, created)
+	}
+	adminHeader := http.Header{"X-Admin-Name": {"test"}, "X-Admin-Token": {admin.token}}
+
+	tests := []struct {
+		name   string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"Public user", "/users/" + aliceID + "/sessions", nil, http.StatusOK},
+		{"Unregistered user", "/users/76561198000000003/sessions", nil, http.StatusNotFound},
+		{"Unregistered user as admin", "/users/76561198000000003/stats", adminHeader, http.StatusNotFound},
+		{"Private user", "/users/" + bobID + "/sessions", nil, http.StatusNotFound},
+		{"Private user as admin", "/users/" + bobID + "/sessions", adminHeader, http.StatusOK},
+		{"Read token header", "/users/" + bobID + "/active_sessions", http.Header{"X-Read-Token": {created.Token}}, http.StatusOK},
+		{"Read token query", "/users/" + bobID + "/stats?token=" + created.Token, nil, http.StatusOK},
+		{"Read token of another user", "/users/" + bobID + "/stats?token=00", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := get(t, srv.URL+tt.path, tt.header); got != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Deleted read token", func(t *testing.T) {
+		if code := admin.do(http.MethodPost, "/admin/users/read_token/delete", map[string]string{"steamid": bobID}, nil); code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if got := get(t, srv.URL+"/users/"+bobID+"/stats?token="+created.Token, nil); got != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", got)
+		}
+		if code := admin.do(http.MethodPost, "/admin/users/read_token/delete", map[string]string{"steamid": bobID}, nil); code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", code)
+		}
+	})
+
+	t.Run("Read token for unregistered user", func(t *testing.T) {
+		if code := admin.do(http.MethodPost, "/admin/users/read_token/create", map[string]string{"steamid": "76561198000000003"}, nil); code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", code)
+		}
+	})
+}
+
+func TestUserStats(t *testing.T) {
+	a, srv := newTestAPI(t)
+	start := time.Date(2025, time.January, 1, 23, 0, 0, 0, time.UTC)
+	a.db.AddSession(a.ctx, sptt.Session{SteamID: alice, UTCStart: start, UTCEnd: start.Add(2 * time.Hour), AppID: gtfo})
+
+	var stats userStatsResponse
+	resp, err := http.Get(srv.URL + "/users/" + strconv.FormatUint(uint64(alice), 10) + "/stats?utcstart_from=2025-01-02T00:00:00Z")
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(&stats)
+
+	if stats.SessionsInRange != 1 || stats.TotalMinutes != 60 || stats.HourMinutes[0] != 60 || stats.WeekdayMinutes[time.Thursday] != 60 {
+		t.Errorf("Expected the hour after midnight, got %+v", stats)
+	}
+	// The session started before utcstart_from, /sessions doesn't list it
+	if stats.TotalSessions != 0 {
+		t.Errorf("Expected 0, got %d", stats.TotalSessions)
+	}
+	if len(stats.Games) != 1 || stats.Games[0].AppID != uint32(gtfo) || stats.Games[0].Minutes != 60 {
+		t.Errorf("Expected 60 minutes of GTFO, got %+v", stats.Games)
+	}
+	if stats.LongestSession == nil || stats.LongestSession.UTCStart != "2025-01-02T00:00:00Z" || *stats.FirstSeen != "2025-01-02T00:00:00Z" {
+		t.Errorf("Expected times clipped to the range, got %+v", stats.LongestSession)
+	}
+}
+
+func TestUserStatsSessionCount(t *testing.T) {
+	a, srv := newTestAPI(t)
+	aliceID := strconv.FormatUint(uint64(alice), 10)
+	start := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	a.db.AddSession(a.ctx, sptt.Session{SteamID: alice, UTCStart: start, UTCEnd: start.Add(time.Hour), AppID: gtfo})
+	a.db.AddSession(a.ctx, sptt.Session{SteamID: alice, UTCStart: start.Add(2 * time.Hour), UTCEnd: start.Add(2 * time.Hour), AppID: gtfo})
+
+	for _, query := range []string{"", "?app_id=493520&utcend_to=2025-01-01T15:00:00Z"} {
+		var stats userStatsResponse
+		var sessions paginatedSessions
+		for path, v := range map[string]interface{}{"/stats": &stats, "/sessions": &sessions} {
+			resp, err := http.Get(srv.URL + "/users/" + aliceID + path + query)
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			json.NewDecoder(resp.Body).Decode(v)
+			resp.Body.Close()
+		}
+
+		if stats.TotalSessions != 2 || stats.TotalSessions != sessions.TotalCount {
+			t.Errorf("Expected 2 sessions like /sessions%s, got %d and %d", query, stats.TotalSessions, sessions.TotalCount)
+		}
+		// The zero-length session has no time in any range
+		if stats.SessionsInRange != 1 {
+			t.Errorf("Expected 1, got %d", stats.SessionsInRange)
+		}
+	}
+}
+
+func TestPlaytimeSeries(t *testing.T) {
+	a, srv := newTestAPI(t)
+	// 22:30 to 01:30 in Berlin
+	start := time.Date(2025, time.January, 1, 21, 30, 0, 0, time.UTC)
+	a.db.AddSession(a.ctx, sptt.Session{SteamID: alice, UTCStart: start, UTCEnd: start.Add(3 * time.Hour), AppID: gtfo})
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"Invalid bucket", "bucket=year", http.StatusBadRequest},
+		{"Invalid tz", "tz=Mars/Olympus", http.StatusBadRequest},
+		{"Invalid from", "from=yesterday", http.StatusBadRequest},
+		{"Range too long", "from=2000-01-01&to=2025-01-01", http.StatusBadRequest},
+		{"Default range", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := get(t, srv.URL+"/users/"+strconv.FormatUint(uint64(alice), 10)+"/playtime/series?"+tt.query, nil); got != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Midnight in tz", func(t *testing.T) {
+		var series seriesResponse
+		resp, err := http.Get(srv.URL + "/users/" + strconv.FormatUint(uint64(alice), 10) + "/playtime/series?from=2025-01-01&to=2025-01-03&tz=Europe/Berlin&by_app=true")
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&series)
+
+		if len(series.Data) != 2 || series.Data[0].Start != "2025-01-01T00:00:00+01:00" {
+			t.Fatalf("Expected 2 days in Berlin, got %+v", series.Data)
+		}
+		if series.Data[0].Minutes != 90 || series.Data[1].Minutes != 90 || series.Data[1].Apps[0].AppID != uint32(gtfo) {
+			t.Errorf("Expected 90 minutes on each day, got %+v", series.Data)
+		}
+	})
+}
+
+func TestUserTimeZone(t *testing.T) {
+	a, srv := newTestAPI(t)
+	admin := newAdminClient(t, a, srv, ClearanceAdminModifyDelete)
+	aliceID := strconv.FormatUint(uint64(alice), 10)
+	// 23:30 to 00:30 in UTC, 08:30 to 09:30 in Tokyo
+	start := time.Date(2025, time.January, 1, 23, 30, 0, 0, time.UTC)
+	a.db.AddSession(a.ctx, sptt.Session{SteamID: alice, UTCStart: start, UTCEnd: start.Add(time.Hour), AppID: gtfo})
+
+	for _, tz := range []string{"Mars/Olympus", "", "Local"} {
+		if code := admin.do(http.MethodPost, "/admin/users/modify", map[string]string{"steamid": aliceID, "timezone": tz}, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %q, got %d", tz, code)
+		}
+	}
+	if code := admin.do(http.MethodPost, "/admin/users/modify", map[string]string{"steamid": aliceID, "timezone": "Asia/Tokyo"}, nil); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		tz    string
+		hour  int
+	}{
+		{"Home timezone", "", "Asia/Tokyo", 8},
+		{"tz override", "?tz=UTC", "UTC", 23},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats userStatsResponse
+			resp, err := http.Get(srv.URL + "/users/" + aliceID + "/stats" + tt.query)
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			defer resp.Body.Close()
+			json.NewDecoder(resp.Body).Decode(&stats)
+
+			if stats.TZ != tt.tz || stats.HourMinutes[tt.hour] != 30 || stats.HourMinutes[(tt.hour+1)%24] != 30 {
+				t.Errorf("Expected 30 minutes at %d and the hour after in %s, got %+v", tt.hour, tt.tz, stats)
+			}
+			if stats.LongestSession.UTCStart != "2025-01-01T23:30:00Z" {
+				t.Errorf("Expected times in UTC, got %s", stats.LongestSession.UTCStart)
+			}
+		})
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	a, srv := newTestAPI(t)
+	carol := sptt.SteamID(76561198000000003)
+	a.db.AddUser(a.ctx, carol, "carol", true, true)
+
+	start := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	for _, s := range []sptt.Session{
+		{SteamID: alice, UTCStart: start, UTCEnd: start.Add(time.Hour), AppID: gtfo},
+		{SteamID: carol, UTCStart: start, UTCEnd: start.Add(2 * time.Hour), AppID: gtfo},
+		{SteamID: carol, UTCStart: start.Add(3 * time.Hour), UTCEnd: start.Add(4 * time.Hour), AppID: dota},
+		{SteamID: bob, UTCStart: start, UTCEnd: start.Add(10 * time.Hour), AppID: gtfo}, // not public
+	} {
+		if err := a.db.AddSession(a.ctx, s); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+	}
+
+	leaderboard := func(path string) paginatedLeaderboard {
+		t.Helper()
+		var lb paginatedLeaderboard
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+		json.NewDecoder(resp.Body).Decode(&lb)
+		return lb
+	}
+
+	lb := leaderboard("/leaderboard")
+	if lb.TotalCount != 2 || len(lb.Data) != 2 || lb.Data[0].Username != "carol" || lb.Data[0].Minutes != 180 || lb.Data[0].Sessions != 2 {
+		t.Errorf("Expected carol first with 180 minutes, got %+v", lb)
+	}
+
+	lb = leaderboard("/games/570/leaderboard")
+	if lb.TotalCount != 1 || lb.Data[0].Username != "carol" {
+		t.Errorf("Expected only carol to play Dota, got %+v", lb)
+	}
+
+	lb = leaderboard("/games/493520/leaderboard?to=2025-01-01T12:30:00Z&page=1&page_size=1")
+	if lb.TotalCount != 2 || lb.TotalPages != 2 || len(lb.Data) != 1 || lb.Data[0].Rank != 1 || lb.Data[0].Minutes != 30 || lb.Data[0].Username != "carol" {
+		t.Errorf("Expected alice and carol tied at 30 minutes, got %+v", lb)
+	}
+
+	if code := get(t, srv.URL+"/leaderboard?from=yesterday", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", code)
+	}
+}
+
+func TestCoPlay(t *testing.T) {
+	a, srv := newTestAPI(t)
+	carol := sptt.SteamID(76561198000000003)
+	a.db.AddUser(a.ctx, carol, "carol", true, true)
+	aliceID := strconv.FormatUint(uint64(alice), 10)
+
+	start := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	for _, s := range []sptt.Session{
+		{SteamID: alice, UTCStart: start, UTCEnd: start.Add(time.Hour), AppID: gtfo},
+		{SteamID: carol, UTCStart: start.Add(30 * time.Minute), UTCEnd: start.Add(2 * time.Hour), AppID: gtfo},
+		{SteamID: carol, UTCStart: start, UTCEnd: start.Add(time.Hour), AppID: dota}, // other game
+		{SteamID: bob, UTCStart: start, UTCEnd: start.Add(time.Hour), AppID: gtfo},   // not public
+	} {
+		if err := a.db.AddSession(a.ctx, s); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+	}
+
+	fetch := func(path string, v interface{}) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+		json.NewDecoder(resp.Body).Decode(v)
+	}
+
+	var user userCoPlayResponse
+	fetch("/users/"+aliceID+"/coplay", &user)
+	if user.TotalSharedMinutes != 30 || len(user.Partners) != 1 || user.Partners[0].Username != "carol" || user.Partners[0].SharedMinutes != 30 {
+		t.Errorf("Expected 30 minutes with carol only, got %+v", user)
+	}
+	if user.Partners[0].LastSeen != "2025-01-01T13:00:00Z" {
+		t.Errorf("Expected 2025-01-01T13:00:00Z, got %s", user.Partners[0].LastSeen)
+	}
+
+	user = userCoPlayResponse{}
+	fetch("/users/"+aliceID+"/coplay?from=2025-01-01T12:45:00Z", &user)
+	if user.TotalSharedMinutes != 15 {
+		t.Errorf("Expected 15 minutes after 12:45, got %+v", user)
+	}
+
+	var sessions paginatedCoPlaySessions
+	fetch("/coplay/sessions?app_id=493520", &sessions)
+	if sessions.TotalCount != 1 || len(sessions.Data) != 1 || sessions.Data[0].Minutes != 30 || sessions.Data[0].UTCStart != "2025-01-01T12:30:00Z" {
+		t.Errorf("Expected one session of alice and carol, got %+v", sessions)
+	}
+
+	if code := get(t, srv.URL+"/coplay/sessions?app_id=gtfo", nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", code)
+	}
+	if code := get(t, srv.URL+"/users/"+strconv.FormatUint(uint64(bob), 10)+"/coplay", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", code)
+	}
+}
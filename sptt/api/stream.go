@@ -0,0 +1,348 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebun1/steamPlaytimeTracker/log"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+	"golang.org/x/net/websocket"
+)
+
+// streamEventTypes are the events pushed to /events subscribers.
+var streamEventTypes = []events.Type{
+	events.TypeSessionStarted,
+	events.TypeSessionConcluded,
+	events.TypeProfileWentPrivate,
+}
+
+// streamKeepAlive is how often an idle SSE stream sends a comment, so
+// proxies don't close it.
+const streamKeepAlive = 30 * time.Second
+
+// streamQueueLimit is how many events a subscriber may fall behind before
+// its stream is closed.
+const streamQueueLimit = 256
+
+// streamWriteTimeout is how long a write to a subscriber may take, streams
+// of peers that stopped reading are closed after it.
+const streamWriteTimeout = 10 * time.Second
+
+// publicCacheTTL is how long the streams reuse the public flag of a user.
+const publicCacheTTL = 10 * time.Second
+
+// publicCache holds the public flags of users for the event streams, so an
+// event costs one lookup however many anonymous subscribers receive it.
+type publicCache struct {
+	mu    sync.Mutex
+	users map[sptt.SteamID]*publicEntry
+}
+
+type publicEntry struct {
+	public  bool
+	fetched time.Time
+	done    chan struct{} // closed once public and fetched are set
+}
+
+// isPublic reports whether events of id may be sent to anyone. Subscribers
+// of an event for id wait for the first one's lookup, those of other users
+// don't.
+func (a *SptAPI) isPublic(id sptt.SteamID) bool {
+	p := &a.public
+	p.mu.Lock()
+	e := p.users[id]
+	if e != nil {
+		select {
+		case <-e.done:
+			if time.Since(e.fetched) >= publicCacheTTL {
+				e = nil
+			}
+		default: // looked up by another stream
+		}
+	}
+	if e != nil {
+		p.mu.Unlock()
+		<-e.done
+		return e.public
+	}
+	e = &publicEntry{done: make(chan struct{})}
+	if p.users == nil {
+		p.users = make(map[sptt.SteamID]*publicEntry)
+	}
+	p.users[id] = e
+	p.mu.Unlock()
+
+	user, err := a.db.GetUser(a.ctx, id)
+	e.public, e.fetched = err == nil && user.Public, time.Now()
+	close(e.done)
+
+	if err != nil && !errors.Is(err, sptt.ErrUserNotFound) {
+		log.Errorf("GetUser DB error: %v", err)
+		// Not cached, the next event looks it up again
+		p.mu.Lock()
+		if p.users[id] == e {
+			delete(p.users, id)
+		}
+		p.mu.Unlock()
+	}
+	return e.public
+}
+
+// streamMessage is an event as sent to subscribers. Session is an
+// activeSessionResponse for session_started and a sessionResponse for
+// session_concluded.
+type streamMessage struct {
+	Type    events.Type `json:"type"`
+	SteamID uint64      `json:"steam_id"`
+	Session any         `json:"session,omitempty"`
+}
+
+// streamFilter holds what a subscriber asked for and which users that are
+// not public it may see.
+type streamFilter struct {
+	steamids      map[sptt.SteamID]bool // nil for every user
+	appids        map[sptt.AppID]bool   // nil for every game
+	authenticated bool                  // admin, sees every user
+	readable      map[sptt.SteamID]bool // users the read token was issued for
+}
+
+// parseStreamFilter reads the steamid and appid query params, each may be
+// repeated or comma separated. Subscribers sending X-Admin-Name and
+// X-Admin-Token also receive events of users that are not public, a read
+// token (X-Read-Token or token) does so for the user it was issued for,
+// who has to be in the steamid filter.
+// On failure it writes the error response and returns false.
+func (a *SptAPI) parseStreamFilter(c *gin.Context) (streamFilter, bool) {
+	var f streamFilter
+
+	for _, raw := range splitQuery(c, "steamid") {
+		v, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid steam id"})
+			return f, false
+		}
+		if f.steamids == nil {
+			f.steamids = make(map[sptt.SteamID]bool)
+		}
+		f.steamids[sptt.SteamID(v)] = true
+	}
+	for _, raw := range splitQuery(c, "appid") {
+		v, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid app id"})
+			return f, false
+		}
+		if f.appids == nil {
+			f.appids = make(map[sptt.AppID]bool)
+		}
+		f.appids[sptt.AppID(v)] = true
+	}
+
+	if name := c.GetHeader("X-Admin-Name"); name != "" {
+		if _, ok := sptt.Authenticate(a.db, name, c.GetHeader("X-Admin-Token")); !ok {
+			c.JSON(http.StatusUnauthorized, errResp("bad_auth"))
+			return f, false
+		}
+		f.authenticated = true
+	}
+	if token := readToken(c); token != "" && !f.authenticated {
+		for id := range f.steamids {
+			if sptt.AuthenticateReadToken(a.ctx, a.db, id, token) {
+				if f.readable == nil {
+					f.readable = make(map[sptt.SteamID]bool)
+				}
+				f.readable[id] = true
+			}
+		}
+		if f.readable == nil {
+			c.JSON(http.StatusUnauthorized, errResp("bad_auth"))
+			return f, false
+		}
+	}
+	return f, true
+}
+
+func splitQuery(c *gin.Context, key string) []string {
+	var values []string
+	for _, v := range c.QueryArray(key) {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+// streamMessage converts e into the message sent to a subscriber with
+// filter f, false if the subscriber doesn't want or may not see it.
+// Events without a game never match an appid filter.
+func (a *SptAPI) streamMessage(f streamFilter, e events.Event) (streamMessage, bool) {
+	msg := streamMessage{Type: e.Type()}
+	var appid sptt.AppID
+	var steamid sptt.SteamID
+
+	switch e := e.(type) {
+	case events.SessionStarted:
+		steamid, appid = e.Session.SteamID, e.Session.AppID
+		msg.Session = toActiveSessionResponse(e.Session)
+	case events.SessionConcluded:
+		steamid, appid = e.Session.SteamID, e.Session.AppID
+		msg.Session = toSessionResponse(e.Session)
+	case events.ProfileWentPrivate:
+		steamid = e.SteamID
+	default:
+		return msg, false
+	}
+	msg.SteamID = uint64(steamid)
+
+	if f.steamids != nil && !f.steamids[steamid] {
+		return msg, false
+	}
+	if f.appids != nil && !f.appids[appid] {
+		return msg, false
+	}
+
+	// The flag may change while the stream is open, it isn't kept for longer
+	// than publicCacheTTL
+	if !f.authenticated && !f.readable[steamid] && !a.isPublic(steamid) {
+		return msg, false
+	}
+	return msg, true
+}
+
+// GET /events
+//
+// Server-Sent Events stream of session_started, session_concluded and
+// profile_went_private events. Query params: steamid, appid.
+func (a *SptAPI) getEvents(c *gin.Context) {
+	f, ok := a.parseStreamFilter(c)
+	if !ok {
+		return
+	}
+
+	sub := a.events.SubscribeWithLimit(streamQueueLimit, streamEventTypes...)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	// A write failing its deadline cancels the request context, which ends
+	// the stream
+	rc := http.NewResponseController(c.Writer)
+	rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	reqCtx := c.Request.Context()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-reqCtx.Done():
+			return false
+		case <-a.ctx.Done():
+			return false
+		case <-keepAlive.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			_, err := fmt.Fprint(w, ": keepalive\n\n")
+			return err == nil
+		case e, ok := <-sub.C:
+			if !ok {
+				logOverflow(c.ClientIP(), sub)
+				return false
+			}
+			if msg, ok := a.streamMessage(f, e); ok {
+				rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				c.SSEvent(string(msg.Type), msg)
+			}
+			return true
+		}
+	})
+}
+
+// GET /events/ws
+//
+// WebSocket equivalent of GET /events, every event is sent as a JSON text
+// message. Messages sent by the client are ignored.
+func (a *SptAPI) getEventsWS(c *gin.Context) {
+	f, ok := a.parseStreamFilter(c)
+	if !ok {
+		return
+	}
+
+	srv := websocket.Server{
+		Handshake: a.checkWSOrigin,
+		Handler: func(ws *websocket.Conn) {
+			a.serveEventsWS(ws, f)
+		},
+	}
+	srv.ServeHTTP(c.Writer, c.Request)
+}
+
+// checkWSOrigin applies the CORS origin to browser WebSocket clients, which
+// send an Origin header. Other clients don't and are always accepted.
+func (a *SptAPI) checkWSOrigin(cfg *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if a.corsOrigin == "*" || origin == "" || origin == a.corsOrigin {
+		return nil
+	}
+	return fmt.Errorf("origin %q not allowed", origin)
+}
+
+func (a *SptAPI) serveEventsWS(ws *websocket.Conn, f streamFilter) {
+	defer ws.Close()
+
+	sub := a.events.SubscribeWithLimit(streamQueueLimit, streamEventTypes...)
+	defer sub.Close()
+
+	// Reads only fail once the client is gone
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		var discard []byte
+		for {
+			if err := websocket.Message.Receive(ws, &discard); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case <-a.ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				logOverflow(ws.Request().RemoteAddr, sub)
+				return
+			}
+			msg, ok := a.streamMessage(f, e)
+			if !ok {
+				continue
+			}
+			ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := websocket.JSON.Send(ws, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func logOverflow(client string, sub *events.Subscription) {
+	if sub.Overflowed() {
+		log.Warnf("Closing event stream of %s, it fell behind by %d events", client, streamQueueLimit)
+	}
+}
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/sptttest"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	alice = sptttest.Alice // public
+	bob   = sptttest.Bob   // not public
+	gtfo  = sptttest.GTFO
+	dota  = sptt.AppID(570)
+)
+
+func newTestAPI(t *testing.T) (*SptAPI, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db := sptttest.NewSQLiteStore(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := db.AddUser(ctx, alice, "alice", true, true); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if err := db.AddUser(ctx, bob, "bob", true, false); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	a := NewSptAPI(ctx, db, nil, events.NewBus(), &sync.WaitGroup{}, "", "*")
+	srv := httptest.NewServer(a.router())
+	t.Cleanup(srv.Close)
+	return a, srv
+}
+
+func started(id sptt.SteamID, appid sptt.AppID) events.SessionStarted {
+	return events.SessionStarted{Session: sptt.ActiveSession{SteamID: id, AppID: appid, UTCStart: time.Now()}}
+}
+
+// readSSE returns the data of the next event on an SSE stream
+func readSSE(t *testing.T, r *bufio.Reader) streamMessage {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected an event, got %v", err)
+		}
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			var msg streamMessage
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				t.Fatalf("Expected JSON, got %q", data)
+			}
+			return msg
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	a, srv := newTestAPI(t)
+
+	tests := []struct {
+		name    string
+		query   string
+		publish []events.Event
+		want    []sptt.SteamID
+	}{
+		{
+			name:    "Users that are not public are hidden",
+			publish: []events.Event{started(bob, gtfo), events.ProfileWentPrivate{SteamID: bob}, started(alice, gtfo)},
+			want:    []sptt.SteamID{alice},
+		},
+		{
+			name:    "Filter by appid",
+			query:   "?appid=570",
+			publish: []events.Event{started(alice, gtfo), events.ProfileWentPrivate{SteamID: alice}, started(alice, dota)},
+			want:    []sptt.SteamID{alice},
+		},
+		{
+			name:    "Filter by steamid",
+			query:   "?steamid=1,76561198000000001",
+			publish: []events.Event{events.SessionConcluded{Session: sptt.Session{SteamID: alice}}},
+			want:    []sptt.SteamID{alice},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + "/events" + tt.query)
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			defer resp.Body.Close()
+			if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("Expected text/event-stream, got %s", ct)
+			}
+
+			for _, e := range tt.publish {
+				a.events.Publish(e)
+			}
+			// Only the events in want pass the filter, in order
+			r := bufio.NewReader(resp.Body)
+			for _, id := range tt.want {
+				if msg := readSSE(t, r); msg.SteamID != uint64(id) {
+					t.Errorf("Expected event for %v, got %+v", id, msg)
+				}
+			}
+		})
+	}
+
+	t.Run("Invalid filter", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/events?appid=x")
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", resp.StatusCode)
+		}
+	})
+}
+
+// countingStore counts the user lookups of the event streams
+type countingStore struct {
+	sptt.Store
+	mu      sync.Mutex
+	lookups int
+}
+
+func (s *countingStore) GetUser(ctx context.Context, id sptt.SteamID) (sptt.User, error) {
+	s.mu.Lock()
+	s.lookups++
+	s.mu.Unlock()
+	return s.Store.GetUser(ctx, id)
+}
+
+func TestEventStreamPublicLookup(t *testing.T) {
+	a, srv := newTestAPI(t)
+	store := &countingStore{Store: a.db}
+	a.db = store
+
+	var readers []*bufio.Reader
+	for i := 0; i < 5; i++ {
+		resp, err := http.Get(srv.URL + "/events")
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer resp.Body.Close()
+		readers = append(readers, bufio.NewReader(resp.Body))
+	}
+
+	a.events.Publish(started(alice, gtfo))
+	for _, r := range readers {
+		if msg := readSSE(t, r); msg.SteamID != uint64(alice) {
+			t.Errorf("Expected event for %v, got %+v", alice, msg)
+		}
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.lookups != 1 {
+		t.Errorf("Expected 1 lookup for 5 subscribers, got %d", store.lookups)
+	}
+}
+
+// slowStore blocks lookups of bob until release is closed
+type slowStore struct {
+	sptt.Store
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) GetUser(ctx context.Context, id sptt.SteamID) (sptt.User, error) {
+	if id == bob {
+		close(s.entered)
+		<-s.release
+	}
+	return s.Store.GetUser(ctx, id)
+}
+
+func TestEventStreamSlowLookup(t *testing.T) {
+	a, srv := newTestAPI(t)
+	store := &slowStore{Store: a.db, entered: make(chan struct{}), release: make(chan struct{})}
+	a.db = store
+	defer close(store.release)
+
+	var readers []*bufio.Reader
+	for _, id := range []sptt.SteamID{bob, alice} {
+		resp, err := http.Get(srv.URL + "/events?steamid=" + strconv.FormatUint(uint64(id), 10))
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer resp.Body.Close()
+		readers = append(readers, bufio.NewReader(resp.Body))
+	}
+
+	a.events.Publish(started(bob, gtfo))
+	select {
+	case <-store.entered:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a lookup of bob, got none")
+	}
+
+	// Waits for the lookup of alice only
+	a.events.Publish(started(alice, gtfo))
+	if msg := readSSE(t, readers[1]); msg.SteamID != uint64(alice) {
+		t.Errorf("Expected event for %v, got %+v", alice, msg)
+	}
+}
+
+func TestEventStreamWebSocket(t *testing.T) {
+	a, srv := newTestAPI(t)
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/events/ws?appid=493520", "", srv.URL)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer ws.Close()
+
+	// The subscription is made after the handshake, wait until it exists
+	deadline := time.Now().Add(time.Second)
+	for a.events.Subscribers() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	a.events.Publish(started(bob, gtfo))
+	a.events.Publish(started(alice, dota))
+	a.events.Publish(started(alice, gtfo))
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var msg streamMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if msg.Type != events.TypeSessionStarted || msg.SteamID != uint64(alice) {
+		t.Errorf("Expected session_started for %v, got %+v", alice, msg)
+	}
+}
+
+func TestEventStreamRelay(t *testing.T) {
+	leader, _ := newTestAPI(t)
+	// Shares the store but not the bus, like an instance that isn't the leader
+	standby := NewSptAPI(leader.ctx, leader.db, nil, events.NewBus(), &sync.WaitGroup{}, "", "*")
+	srv := httptest.NewServer(standby.router())
+	defer srv.Close()
+
+	wg := sync.WaitGroup{}
+	for instance, a := range map[string]*SptAPI{"leader": leader, "standby": standby} {
+		wg.Add(1)
+		go events.NewRelay(leader.ctx, a.events, a.db, instance, &wg, events.SessionTypes...).Run()
+	}
+	// The relays listen before they subscribe, wait until both subscribed
+	deadline := time.Now().Add(time.Second)
+	for (leader.events.Subscribers() == 0 || standby.events.Subscribers() == 0) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	local := leader.events.Subscribe()
+	defer local.Close()
+
+	resp, err := http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	leader.events.Publish(started(alice, gtfo))
+	leader.events.Publish(events.SessionConcluded{Session: sptt.Session{SteamID: alice, AppID: gtfo}})
+	r := bufio.NewReader(resp.Body)
+	for _, typ := range []events.Type{events.TypeSessionStarted, events.TypeSessionConcluded} {
+		if msg := readSSE(t, r); msg.Type != typ || msg.SteamID != uint64(alice) {
+			t.Errorf("Expected %s for %v, got %+v", typ, alice, msg)
+		}
+	}
+
+	// Relayed events are not sent back to the leader
+	for i := 0; i < 2; i++ {
+		<-local.C
+	}
+	select {
+	case e := <-local.C:
+		t.Errorf("Expected no more events, got %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
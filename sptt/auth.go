@@ -1,6 +1,7 @@
 package sptt
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha512"
 	"crypto/subtle"
@@ -61,27 +62,40 @@ func GenerateToken() (tokenHex, saltHex, secretHex string, err error) {
 // Authenticate verifies a token against the database.
 // It returns (clearance, true) on success or (0, false) on any failure.
 // The reason for failure (name not found vs. wrong token) is never disclosed.
-func Authenticate(db *DB, name, tokenHex string) (int, bool) {
+func Authenticate(db Store, name, tokenHex string) (int, bool) {
 	row, err := db.GetAuthToken(name)
+	if !verifyToken(row.Salt, row.Secret, tokenHex, err == nil) {
+		return 0, false
+	}
+	return row.Clearance, true
+}
+
+// AuthenticateReadToken verifies the read token of a user, it fails the
+// same way whether or not the user has one.
+func AuthenticateReadToken(ctx context.Context, db Store, id SteamID, tokenHex string) bool {
+	row, err := db.GetReadToken(ctx, id)
+	return verifyToken(row.Salt, row.Secret, tokenHex, err == nil)
+}
 
+// verifyToken reports whether tokenHex hashes to secretHex with saltHex.
+// If the row wasn't found or doesn't decode, the hash is still computed
+// against the dummy values, so every failure takes the same time.
+func verifyToken(saltHex, secretHex, tokenHex string, found bool) bool {
 	var saltBytes, secretBytes []byte
+	ok := found
 
-	if err != nil {
-		// Name not found — use dummy values to keep timing constant.
+	if ok {
+		var err error
+		saltBytes, err = hex.DecodeString(saltHex)
+		if err == nil {
+			secretBytes, err = hex.DecodeString(secretHex)
+		}
+		ok = err == nil
+	}
+	if !ok {
+		// Not found or undecodable — use dummy values to keep timing constant.
 		saltBytes = dummySalt
 		secretBytes = dummySecret
-	} else {
-		saltBytes, err = hex.DecodeString(row.Salt)
-		if err != nil {
-			saltBytes = dummySalt
-			secretBytes = dummySecret
-		} else {
-			secretBytes, err = hex.DecodeString(row.Secret)
-			if err != nil {
-				saltBytes = dummySalt
-				secretBytes = dummySecret
-			}
-		}
 	}
 
 	providedBytes, decErr := hex.DecodeString(tokenHex)
@@ -98,8 +112,5 @@ func Authenticate(db *DB, name, tokenHex string) (int, bool) {
 	// Always constant-time compare — never short-circuit.
 	match := subtle.ConstantTimeCompare(computed, secretBytes) == 1
 
-	if err != nil || decErr != nil || !match {
-		return 0, false
-	}
-	return row.Clearance, true
+	return ok && decErr == nil && match
 }
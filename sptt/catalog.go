@@ -0,0 +1,113 @@
+package sptt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+)
+
+const (
+	// How often the catalog looks for appids without metadata
+	CatalogFetchInterval = 10 * time.Minute
+	// Maximum appids fetched per round, half of the store's 5 minute budget
+	CatalogFetchBatchSize = StoreRequestsPer5Min / 2
+	// How long an appid whose fetch failed is left alone
+	CatalogRetryDelay = 24 * time.Hour
+)
+
+// CatalogFetcher fills the games table with store metadata for every appid
+// seen in sessions. Requests go through the SteamClient, which holds them
+// back to stay within the store rate limit.
+type CatalogFetcher struct {
+	ctx      context.Context
+	db       Store
+	steam    SteamClient
+	wg       *sync.WaitGroup
+	interval time.Duration
+}
+
+func NewCatalogFetcher(ctx context.Context, db Store, steam SteamClient, wg *sync.WaitGroup, interval time.Duration) *CatalogFetcher {
+	return &CatalogFetcher{
+		ctx:      ctx,
+		db:       db,
+		steam:    steam,
+		wg:       wg,
+		interval: interval,
+	}
+}
+
+// Run fetches missing metadata once and then every interval until the
+// context is cancelled.
+func (f *CatalogFetcher) Run() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		f.fetchMissing()
+
+		select {
+		case <-f.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchMissing fetches and stores metadata for up to CatalogFetchBatchSize
+// appids that are not in the catalog yet. Appids that fail are retried after
+// CatalogRetryDelay.
+func (f *CatalogFetcher) fetchMissing() {
+	appids, err := f.db.GetUncachedAppIDs(f.ctx, CatalogFetchBatchSize)
+	if err != nil {
+		log.Error("Error while trying to get uncached appids: ", err)
+		return
+	}
+	if len(appids) == 0 {
+		return
+	}
+
+	log.Infof("Fetching store metadata for %d games", len(appids))
+
+	fetched := 0
+	for i, appid := range appids {
+		data, err := f.steam.GetGameDetails(f.ctx, appid)
+		if err == ErrRateLimited || err == ErrUpstreamUnavailable {
+			log.Warnf("Store unavailable (%v), postponing remaining %d games", err, len(appids)-i)
+			return
+		}
+		if f.ctx.Err() != nil {
+			return
+		}
+
+		var game GameCache
+		switch err {
+		case nil:
+			game = GameCacheFromData(data)
+			// The store may answer with the parent app, keep the appid we asked for
+			game.AppID = appid
+		case ErrNoGameData:
+			// Remember that there's nothing to fetch so we don't keep asking
+			log.Debugf("No store data for game %v", appid)
+			game = GameCache{AppID: appid, Available: false}
+		default:
+			// Record the failure, the same appids would take up every round otherwise
+			log.Errorf("Error while trying to get details of game %v, retrying in %v: %v", appid, CatalogRetryDelay, err)
+			retryAfter := time.Now().Add(CatalogRetryDelay)
+			game = GameCache{AppID: appid, Available: false, RetryAfter: &retryAfter}
+		}
+
+		if err := f.db.AddGameCache(f.ctx, game); err != nil {
+			log.Errorf("Error while trying to cache game %v: %v", appid, err)
+			continue
+		}
+		if game.RetryAfter == nil {
+			fetched++
+		}
+	}
+
+	log.Infof("Fetched store metadata for %d of %d games", fetched, len(appids))
+}
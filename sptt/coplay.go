@@ -0,0 +1,169 @@
+package sptt
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// CoPlayQuery selects overlapping sessions of registered users in the same
+// game. Only the part of a session inside From and To counts.
+type CoPlayQuery struct {
+	AppID    *AppID
+	From     *time.Time
+	To       *time.Time
+	Page     int32
+	PageSize int32
+}
+
+// CoPlaySession is the time two users played the same game together.
+type CoPlaySession struct {
+	AppID     AppID
+	SteamIDs  [2]SteamID
+	Usernames [2]string
+	UTCStart  time.Time // start of the overlap
+	UTCEnd    time.Time
+}
+
+// CoPlayPartner is how much a user played with another user.
+type CoPlayPartner struct {
+	SteamID  SteamID
+	Username string
+	Sessions int64
+	Shared   time.Duration
+	LastSeen time.Time // end of the last session together
+}
+
+// coplayCTE builds "overlaps", the parts of sessions matching q that users
+// played together, as x and y and the overlap in Unix seconds. With id, x is
+// id and y any public user, otherwise every pair of public users is in it
+// once.
+func (d *DB) coplayCTE(q CoPlayQuery, id *SteamID) (string, []interface{}) {
+	lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+	if q.From != nil {
+		lo = q.From.Unix()
+	}
+	if q.To != nil {
+		hi = q.To.Unix()
+	}
+	args := []interface{}{lo, hi}
+
+	clause := ""
+	if q.AppID != nil {
+		clause = " WHERE s.appid = $3"
+		args = append(args, *q.AppID)
+	}
+	pair := "x.public = true AND y.public = true AND x.steamid < y.steamid"
+	if id != nil {
+		args = append(args, *id)
+		pair = fmt.Sprintf("x.steamid = $%d AND y.public = true", len(args))
+	}
+
+	greatest, least := d.greatestSQL(), d.leastSQL()
+	cte := fmt.Sprintf(`WITH bounds(lo, hi) AS (
+			SELECT CAST($1 AS bigint), CAST($2 AS bigint)
+		), spans AS (
+			SELECT s.steamid, s.appid, u.username, u.public, %s(%s, b.lo) AS st, %s(%s, b.hi) AS en
+			FROM sessions s JOIN users u ON u.steamid = s.steamid, bounds b%s
+		), overlaps AS (
+			SELECT x.appid, x.steamid AS x_id, x.username AS x_name, y.steamid AS y_id, y.username AS y_name,
+				%s(x.st, y.st) AS st, %s(x.en, y.en) AS en
+			FROM spans x JOIN spans y ON y.appid = x.appid AND y.steamid <> x.steamid AND y.st < x.en AND x.st < y.en
+			WHERE x.st < x.en AND y.st < y.en AND %s
+		) `, greatest, d.epochSQL("s.utcstart"), least, d.epochSQL("s.utcend"), clause, greatest, least, pair)
+	return cte, args
+}
+
+// GetCoPlaySessions returns a page of the times two public users played the
+// same game together, latest first, and the number of them.
+func (d *DB) GetCoPlaySessions(ctx context.Context, q CoPlayQuery) ([]CoPlaySession, int64, error) {
+	cte, args := d.coplayCTE(q, nil)
+
+	var total int64
+	if err := d.db.QueryRowContext(ctx, cte+"SELECT COUNT(*) FROM overlaps", args...).Scan(&total); err != nil {
+		return nil, 0, wrapErr(err)
+	}
+
+	n := len(args) + 1
+	query := cte + fmt.Sprintf(`SELECT appid, x_id, x_name, y_id, y_name, st, en FROM overlaps
+		ORDER BY st DESC, x_id, y_id, appid
+		LIMIT $%d OFFSET $%d`, n, n+1)
+	args = append(args, q.PageSize, q.PageSize*q.Page)
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, wrapErr(err)
+	}
+	defer rows.Close()
+
+	sessions := []CoPlaySession{}
+	for rows.Next() {
+		var s CoPlaySession
+		var st, en int64
+		if err := rows.Scan(&s.AppID, &s.SteamIDs[0], &s.Usernames[0], &s.SteamIDs[1], &s.Usernames[1], &st, &en); err != nil {
+			return nil, 0, wrapErr(err)
+		}
+		s.UTCStart, s.UTCEnd = time.Unix(st, 0).UTC(), time.Unix(en, 0).UTC()
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, wrapErr(err)
+	}
+	return sessions, total, nil
+}
+
+// GetCoPlayPartners returns the public users id played with, most shared
+// time first, and the time id played with at least one of them. Paging of
+// q is ignored.
+func (d *DB) GetCoPlayPartners(ctx context.Context, id SteamID, q CoPlayQuery) ([]CoPlayPartner, time.Duration, error) {
+	cte, args := d.coplayCTE(q, &id)
+
+	rows, err := d.db.QueryContext(ctx, cte+"SELECT y_id, y_name, st, en FROM overlaps ORDER BY st", args...)
+	if err != nil {
+		return nil, 0, wrapErr(err)
+	}
+	defer rows.Close()
+
+	partners := make(map[SteamID]*CoPlayPartner)
+	var total time.Duration
+	var end int64 = math.MinInt64 // end of the time together counted so far
+	for rows.Next() {
+		var p CoPlayPartner
+		var st, en int64
+		if err := rows.Scan(&p.SteamID, &p.Username, &st, &en); err != nil {
+			return nil, 0, wrapErr(err)
+		}
+		if partners[p.SteamID] == nil {
+			partners[p.SteamID] = &p
+		}
+		partner := partners[p.SteamID]
+		partner.Sessions++
+		partner.Shared += time.Duration(en-st) * time.Second
+		if last := time.Unix(en, 0).UTC(); last.After(partner.LastSeen) {
+			partner.LastSeen = last
+		}
+
+		// Overlaps come by start, only the part after the counted time adds
+		if en > end {
+			total += time.Duration(en-max(st, end)) * time.Second
+			end = en
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, wrapErr(err)
+	}
+
+	ranked := make([]CoPlayPartner, 0, len(partners))
+	for _, p := range partners {
+		ranked = append(ranked, *p)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Shared != ranked[j].Shared {
+			return ranked[i].Shared > ranked[j].Shared
+		}
+		return ranked[i].SteamID < ranked[j].SteamID
+	})
+	return ranked, total, nil
+}
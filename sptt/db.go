@@ -3,9 +3,10 @@ package sptt
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,10 +15,6 @@ import (
 	"github.com/sebun1/steamPlaytimeTracker/log"
 )
 
-const (
-	ErrSQLFileNotFound = DBError("SQL file (db.sql) not found")
-)
-
 type DBError string
 
 func (e DBError) Error() string {
@@ -25,55 +22,131 @@ func (e DBError) Error() string {
 }
 
 type DB struct {
-	db *sql.DB
+	db     *sql.DB
+	driver Driver
+	dsn    string // Postgres connection string, used by Listen
+	local  *localNotifier
 }
 
-// For testing purposes only.
-// Create db instance with a custom sql file.
-func newDBWithSQLFile(user, pwd, dbname, sqlfile string) (*DB, error) {
-	//ssl_mode := "verify-full"
-	ssl_mode := "disable"
+// Driver selects the database backend, set with DB_DRIVER.
+type Driver string
 
-	log.Info("Connecting to database...")
-	db, err := sql.Open("postgres", fmt.Sprintf("user=%s password=%s dbname=%s sslmode=%s", user, pwd, dbname, ssl_mode))
-	if err != nil {
-		return nil, err
-	}
+const (
+	DriverPostgres Driver = "postgres"
+	DriverSQLite   Driver = "sqlite"
+)
 
-	thisdb := &DB{db}
+const ErrUnknownDriver = DBError("unknown database driver")
 
-	log.Info("Initializing database...")
-	err = thisdb.init(sqlfile)
-	if err != nil {
-		return nil, err
+// DBConfig configures a database connection.
+// User and Password are ignored for SQLite, Name is the database name for
+// Postgres and the file path for SQLite.
+type DBConfig struct {
+	Driver   Driver
+	User     string
+	Password string
+	Name     string
+}
+
+// DBConfigFromEnv reads DB_DRIVER (default postgres) and the settings the
+// driver needs: DB_USER, DB_PASSWORD and DB_NAME for Postgres, DB_PATH for
+// SQLite.
+func DBConfigFromEnv(env map[string]string) (DBConfig, error) {
+	cfg := DBConfig{Driver: Driver(strings.ToLower(env["DB_DRIVER"]))}
+	if cfg.Driver == "" {
+		cfg.Driver = DriverPostgres
+	}
+
+	var required []string
+	switch cfg.Driver {
+	case DriverPostgres:
+		required = []string{"DB_USER", "DB_PASSWORD", "DB_NAME"}
+		cfg.User, cfg.Password, cfg.Name = env["DB_USER"], env["DB_PASSWORD"], env["DB_NAME"]
+	case DriverSQLite:
+		required = []string{"DB_PATH"}
+		cfg.Name = env["DB_PATH"]
+	default:
+		return cfg, fmt.Errorf("%w: %s", ErrUnknownDriver, cfg.Driver)
 	}
 
-	return thisdb, nil
+	for _, key := range required {
+		if _, ok := env[key]; !ok {
+			return cfg, fmt.Errorf("%s is not set", key)
+		}
+	}
+	return cfg, nil
 }
 
-// Creates a new database instance.
-// Expects a steamtrack schema.
-func NewDB(user, pwd, dbname string) (*DB, error) {
-	//ssl_mode := "verify-full"
-	ssl_mode := "disable"
+// Opens a Postgres database connection without touching the schema.
+func OpenDB(user, pwd, dbname string) (*DB, error) {
+	return OpenDBWithConfig(DBConfig{Driver: DriverPostgres, User: user, Password: pwd, Name: dbname})
+}
 
+// Opens a database connection without touching the schema.
+// Used by the migrate subcommand, everything else should use NewDBWithConfig.
+func OpenDBWithConfig(cfg DBConfig) (*DB, error) {
 	log.Info("Connecting to database...")
-	db, err := sql.Open("postgres", fmt.Sprintf("user=%s password=%s dbname=%s sslmode=%s", user, pwd, dbname, ssl_mode))
+	switch cfg.Driver {
+	case DriverPostgres:
+		//ssl_mode := "verify-full"
+		ssl_mode := "disable"
+
+		dsn := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=%s", cfg.User, cfg.Password, cfg.Name, ssl_mode)
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, err
+		}
+		return &DB{db: db, driver: DriverPostgres, dsn: dsn}, nil
+	case DriverSQLite:
+		return openSQLite(cfg.Name)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownDriver, cfg.Driver)
+}
+
+// Creates a new Postgres database instance and applies pending migrations.
+func NewDB(user, pwd, dbname string) (*DB, error) {
+	return NewDBWithConfig(DBConfig{Driver: DriverPostgres, User: user, Password: pwd, Name: dbname})
+}
+
+// Creates a new database instance and applies pending migrations.
+// Refuses to start with ErrSchemaTooNew if the database was migrated by a
+// newer binary.
+func NewDBWithConfig(cfg DBConfig) (*DB, error) {
+	thisdb, err := OpenDBWithConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	thisdb := &DB{db}
+	ctx := context.Background()
+
+	version, err := thisdb.SchemaVersion(ctx)
+	if err != nil {
+		thisdb.Close()
+		return nil, err
+	}
+	if latest := thisdb.LatestSchemaVersion(); version > latest {
+		thisdb.Close()
+		return nil, fmt.Errorf("%w (database at version %d, binary supports up to %d)", ErrSchemaTooNew, version, latest)
+	}
 
-	log.Info("Initializing database...")
-	err = thisdb.init("db.sql")
+	log.Info("Migrating database...")
+	applied, err := thisdb.MigrateUp(ctx)
 	if err != nil {
+		thisdb.Close()
 		return nil, err
 	}
+	if applied > 0 {
+		log.Infof("Applied %d migrations", applied)
+	}
 
 	return thisdb, nil
 }
 
+// Driver returns the backend this instance is connected to.
+func (d *DB) Driver() Driver {
+	return d.driver
+}
+
 // Pings underlying database instance
 func (d *DB) Ping(ctx context.Context) error {
 	return d.db.PingContext(ctx)
@@ -84,28 +157,6 @@ func (d *DB) Close() error {
 	return d.db.Close()
 }
 
-// Initializes database with schema.
-// Reads from `db.sql` file.
-func (d *DB) init(filename string) error {
-	var query []byte
-	query, err := os.ReadFile(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return ErrSQLFileNotFound
-		}
-		return err
-	}
-
-	log.Debug("Create DB Query:\n", string(query))
-
-	_, err = d.db.Exec(string(query))
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // Queries steam ID of all registered users
 func (d *DB) GetSteamIDs(ctx context.Context) ([]SteamID, error) {
 	rows, err := d.db.QueryContext(ctx, "SELECT steamid FROM users")
@@ -198,12 +249,31 @@ type ActiveSession struct {
 	UTCStart        time.Time
 	PlaytimeForever int32 // -1 if private or error getting
 	AppID           AppID
+	LastSeen        time.Time // Last poll that saw the user in the game
+}
+
+const activeSessionColumns = "steamid, utcstart, playtime_forever, appid, last_seen"
+
+// scanActiveSession scans a row of activeSessionColumns. Sessions started
+// before last_seen was tracked count as last seen at their start.
+func scanActiveSession(rows *sql.Rows) (ActiveSession, error) {
+	var session ActiveSession
+	var lastSeen sql.NullTime
+	err := rows.Scan(&session.SteamID, &session.UTCStart, &session.PlaytimeForever, &session.AppID, &lastSeen)
+	if err != nil {
+		return session, err
+	}
+	session.LastSeen = session.UTCStart
+	if lastSeen.Valid {
+		session.LastSeen = lastSeen.Time
+	}
+	return session, nil
 }
 
 // GetActiveSessions returns all active sessions for a steamid
 // This is used to check if a user is already active in a game
 func (d *DB) GetActiveSessions(ctx context.Context, id SteamID) (map[AppID]ActiveSession, error) {
-	rows, err := d.db.QueryContext(ctx, "SELECT steamid, utcstart, playtime_forever, appid FROM active_sessions WHERE steamid = $1", id)
+	rows, err := d.db.QueryContext(ctx, "SELECT "+activeSessionColumns+" FROM active_sessions WHERE steamid = $1", id)
 	if err != nil {
 		return nil, err
 	}
@@ -215,8 +285,7 @@ func (d *DB) GetActiveSessions(ctx context.Context, id SteamID) (map[AppID]Activ
 
 	sessionsMap := make(map[AppID]ActiveSession)
 	for rows.Next() {
-		var session ActiveSession
-		err := rows.Scan(&session.SteamID, &session.UTCStart, &session.PlaytimeForever, &session.AppID)
+		session, err := scanActiveSession(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -230,6 +299,33 @@ func (d *DB) GetActiveSessions(ctx context.Context, id SteamID) (map[AppID]Activ
 	return sessionsMap, nil
 }
 
+// GetAllActiveSessions returns the active sessions of every user, used to
+// find sessions orphaned by downtime.
+func (d *DB) GetAllActiveSessions(ctx context.Context) ([]ActiveSession, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+activeSessionColumns+" FROM active_sessions ORDER BY steamid, utcstart")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []ActiveSession
+	for rows.Next() {
+		session, err := scanActiveSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// TouchActiveSession records that the user was still seen in the game at t.
+func (d *DB) TouchActiveSession(ctx context.Context, steamid SteamID, appid AppID, t time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE active_sessions SET last_seen = $1 WHERE steamid = $2 AND appid = $3",
+		t.UTC(), steamid, appid)
+	return wrapErr(err)
+}
+
 // AddActiveSession
 //
 // Adds an active session to the database
@@ -241,14 +337,18 @@ func (d *DB) AddActiveSession(ctx context.Context, session ActiveSession) error
 //
 // Adds multiple active sessions to database
 func (d *DB) AddActiveSessions(ctx context.Context, sessions []ActiveSession) error {
-	stmt, err := d.db.PrepareContext(ctx, "INSERT INTO active_sessions(steamid, utcstart, playtime_forever, appid) VALUES($1, $2, $3, $4)")
+	stmt, err := d.db.PrepareContext(ctx, "INSERT INTO active_sessions(steamid, utcstart, playtime_forever, appid, last_seen) VALUES($1, $2, $3, $4, $5)")
 	if err != nil {
 		return wrapErr(err)
 	}
 	defer stmt.Close()
 
 	for _, session := range sessions {
-		_, err = stmt.ExecContext(ctx, session.SteamID, session.UTCStart, session.PlaytimeForever, session.AppID)
+		lastSeen := session.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = session.UTCStart
+		}
+		_, err = stmt.ExecContext(ctx, session.SteamID, session.UTCStart, session.PlaytimeForever, session.AppID, lastSeen)
 		if err != nil {
 			return wrapErr(err)
 		}
@@ -325,8 +425,20 @@ type Session struct {
 	UTCEnd          time.Time
 	PlaytimeForever int32
 	AppID           AppID
+	PlaytimeSource  PlaytimeSource // Endpoint that supplied PlaytimeForever
+	Recovered       bool           // Concluded after downtime, UTCEnd is an estimate
 }
 
+// PlaytimeSource records which Steam endpoint supplied the final
+// playtime_forever of a concluded session.
+type PlaytimeSource string
+
+const (
+	PlaytimeSourceNone           PlaytimeSource = "none" // PlaytimeForever is -1
+	PlaytimeSourceOwnedGames     PlaytimeSource = "owned_games"
+	PlaytimeSourceRecentlyPlayed PlaytimeSource = "recently_played"
+)
+
 // SessionSortBy is a whitelisted set of columns sessions can be sorted by.
 type SessionSortBy string
 
@@ -369,6 +481,7 @@ type SessionQuery struct {
 // sessionWhereArgs builds the WHERE clause (excluding the fixed steamid=$1
 // condition) and the corresponding args slice. argStart is the next $N index.
 // Returns (clause, args, nextArgIdx).
+// Times are passed in UTC, SQLite compares them as text.
 func sessionWhereArgs(f SessionFilter, argStart int) (string, []interface{}, int) {
 	var conds []string
 	var args []interface{}
@@ -381,22 +494,22 @@ func sessionWhereArgs(f SessionFilter, argStart int) (string, []interface{}, int
 	}
 	if f.UTCStartFrom != nil {
 		conds = append(conds, fmt.Sprintf("utcstart >= $%d", i))
-		args = append(args, *f.UTCStartFrom)
+		args = append(args, f.UTCStartFrom.UTC())
 		i++
 	}
 	if f.UTCStartTo != nil {
 		conds = append(conds, fmt.Sprintf("utcstart <= $%d", i))
-		args = append(args, *f.UTCStartTo)
+		args = append(args, f.UTCStartTo.UTC())
 		i++
 	}
 	if f.UTCEndFrom != nil {
 		conds = append(conds, fmt.Sprintf("utcend >= $%d", i))
-		args = append(args, *f.UTCEndFrom)
+		args = append(args, f.UTCEndFrom.UTC())
 		i++
 	}
 	if f.UTCEndTo != nil {
 		conds = append(conds, fmt.Sprintf("utcend <= $%d", i))
-		args = append(args, *f.UTCEndTo)
+		args = append(args, f.UTCEndTo.UTC())
 		i++
 	}
 	if f.PlaytimeForeverMin != nil {
@@ -446,7 +559,7 @@ func (d *DB) GetSessions(ctx context.Context, id SteamID, q SessionQuery) ([]Ses
 	filterClause, filterArgs, nextIdx := sessionWhereArgs(q.Filter, 2)
 
 	query := fmt.Sprintf(
-		"SELECT steamid, utcstart, utcend, playtime_forever, appid FROM sessions WHERE steamid = $1%s ORDER BY %s %s LIMIT $%d OFFSET $%d",
+		"SELECT steamid, utcstart, utcend, playtime_forever, appid, COALESCE(playtime_source, ''), recovered FROM sessions WHERE steamid = $1%s ORDER BY %s %s LIMIT $%d OFFSET $%d",
 		filterClause,
 		safeSessionSortCol(q.SortBy),
 		safeSortDir(q.SortDir),
@@ -466,7 +579,7 @@ func (d *DB) GetSessions(ctx context.Context, id SteamID, q SessionQuery) ([]Ses
 	sessions := []Session{}
 	for rows.Next() {
 		var session Session
-		err := rows.Scan(&session.SteamID, &session.UTCStart, &session.UTCEnd, &session.PlaytimeForever, &session.AppID)
+		err := rows.Scan(&session.SteamID, &session.UTCStart, &session.UTCEnd, &session.PlaytimeForever, &session.AppID, &session.PlaytimeSource, &session.Recovered)
 		if err != nil {
 			return nil, err
 		}
@@ -500,13 +613,13 @@ func (d *DB) GetSessionCount(ctx context.Context, id SteamID, f SessionFilter) (
 //
 // Add a concluded session to the database
 func (d *DB) AddSession(ctx context.Context, session Session) error {
-	stmt, err := d.db.PrepareContext(ctx, "INSERT INTO sessions(steamid, utcstart, utcend, playtime_forever, appid) VALUES($1, $2, $3, $4, $5)")
+	stmt, err := d.db.PrepareContext(ctx, "INSERT INTO sessions(steamid, utcstart, utcend, playtime_forever, appid, playtime_source, recovered) VALUES($1, $2, $3, $4, $5, $6, $7)")
 	if err != nil {
 		return wrapErr(err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.ExecContext(ctx, session.SteamID, session.UTCStart, session.UTCEnd, session.PlaytimeForever, session.AppID)
+	_, err = stmt.ExecContext(ctx, session.SteamID, session.UTCStart, session.UTCEnd, session.PlaytimeForever, session.AppID, session.PlaytimeSource, session.Recovered)
 	if err != nil {
 		return wrapErr(err)
 	}
@@ -514,6 +627,36 @@ func (d *DB) AddSession(ctx context.Context, session Session) error {
 	return nil
 }
 
+// ConcludeSession
+//
+// Moves an active session to the history in one transaction: inserts the
+// concluded session and deletes the active session it started from.
+// Re-running it for the same session is a no-op, the insert is skipped on
+// conflict and only the active session with the same start is deleted.
+func (d *DB) ConcludeSession(ctx context.Context, session Session) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO sessions(steamid, utcstart, utcend, playtime_forever, appid, playtime_source, recovered)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (steamid, utcstart) DO NOTHING`,
+		session.SteamID, session.UTCStart, session.UTCEnd, session.PlaytimeForever, session.AppID, session.PlaytimeSource, session.Recovered)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM active_sessions WHERE steamid = $1 AND appid = $2 AND utcstart = $3",
+		session.SteamID, session.AppID, session.UTCStart)
+	if err != nil {
+		return wrapErr(err)
+	}
+
+	return wrapErr(tx.Commit())
+}
+
 type GameCache struct {
 	AppID           AppID
 	Name            string
@@ -521,6 +664,25 @@ type GameCache struct {
 	Developer       string
 	HeaderImage     string
 	Recommendations uint32
+	Available       bool // false if the store has no data for the app
+	FetchedAt       time.Time
+	RetryAfter      *time.Time // set if fetching failed, when to fetch again
+}
+
+// ErrGameNotFound is returned when a game is not in the catalog.
+var ErrGameNotFound = errors.New("game not found")
+
+// GameCacheFromData converts store details into a catalog entry.
+func GameCacheFromData(data GameData) GameCache {
+	return GameCache{
+		AppID:           data.AppID,
+		Name:            data.Name,
+		Publisher:       strings.Join(data.Publishers, ", "),
+		Developer:       strings.Join(data.Developers, ", "),
+		HeaderImage:     data.HeaderImage,
+		Recommendations: data.Recommendations.Total,
+		Available:       true,
+	}
 }
 
 // GetGameCache
@@ -528,7 +690,10 @@ type GameCache struct {
 // returns game information from cache
 func (d *DB) GetGameCache(ctx context.Context, appid AppID) (*GameCache, error) {
 	var game GameCache
-	err := d.db.QueryRowContext(ctx, "SELECT appid, name, publisher, developer, header_image, recommendations FROM games WHERE appid = $1", appid).Scan(&game.AppID, &game.Name, &game.Publisher, &game.Developer, &game.HeaderImage, &game.Recommendations)
+	err := d.db.QueryRowContext(ctx, "SELECT appid, name, publisher, developer, header_image, recommendations, available, fetched_at, retry_after FROM games WHERE appid = $1", appid).Scan(&game.AppID, &game.Name, &game.Publisher, &game.Developer, &game.HeaderImage, &game.Recommendations, &game.Available, &game.FetchedAt, &game.RetryAfter)
+	if err == sql.ErrNoRows {
+		return nil, ErrGameNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -537,15 +702,22 @@ func (d *DB) GetGameCache(ctx context.Context, appid AppID) (*GameCache, error)
 
 // AddGameCache
 //
-// Adds game information to cache
+// Adds game information to cache, replacing any existing entry
 func (d *DB) AddGameCache(ctx context.Context, game GameCache) error {
-	stmt, err := d.db.PrepareContext(ctx, "INSERT INTO games(appid, name, publisher, developer, header_image, recommendations) VALUES($1, $2, $3, $4, $5, $6)")
+	stmt, err := d.db.PrepareContext(ctx, `INSERT INTO games(appid, name, publisher, developer, header_image, recommendations, available, retry_after, fetched_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+		ON CONFLICT (appid) DO UPDATE SET name = $2, publisher = $3, developer = $4, header_image = $5, recommendations = $6, available = $7, retry_after = $8, fetched_at = CURRENT_TIMESTAMP`)
 	if err != nil {
 		return wrapErr(err)
 	}
 	defer stmt.Close()
 
-	_, err = stmt.ExecContext(ctx, game.AppID, game.Name, game.Publisher, game.Developer, game.HeaderImage, game.Recommendations)
+	var retryAfter *time.Time
+	if game.RetryAfter != nil {
+		t := game.RetryAfter.UTC()
+		retryAfter = &t
+	}
+	_, err = stmt.ExecContext(ctx, game.AppID, game.Name, game.Publisher, game.Developer, game.HeaderImage, game.Recommendations, game.Available, retryAfter)
 	if err != nil {
 		return wrapErr(err)
 	}
@@ -553,13 +725,52 @@ func (d *DB) AddGameCache(ctx context.Context, game GameCache) error {
 	return nil
 }
 
+// GetUncachedAppIDs returns up to limit appids seen in sessions or
+// active sessions that have no catalog entry yet, or one whose fetch failed
+// and is due to be retried.
+func (d *DB) GetUncachedAppIDs(ctx context.Context, limit int) ([]AppID, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT appid FROM (
+			SELECT appid FROM sessions
+			UNION
+			SELECT appid FROM active_sessions
+		) seen
+		WHERE appid NOT IN (SELECT appid FROM games WHERE retry_after IS NULL OR retry_after > $1)
+		ORDER BY appid
+		LIMIT $2`, time.Now().UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var appids []AppID
+	for rows.Next() {
+		var appid AppID
+		if err := rows.Scan(&appid); err != nil {
+			return nil, err
+		}
+		appids = append(appids, appid)
+	}
+	return appids, rows.Err()
+}
+
 // --- Users (Admin) ---
 
 type User struct {
-	SteamID  SteamID
-	Username string
-	Active   bool
-	Public   bool
+	SteamID       SteamID
+	Username      string
+	Active        bool
+	Public        bool
+	DiscordNotify bool   // Opted in to Discord notifications of their sessions
+	TimeZone      string // IANA name, day boundaries of their stats
+}
+
+// Location returns the user's time zone, UTC if TimeZone doesn't load.
+func (u User) Location() *time.Location {
+	loc, err := time.LoadLocation(u.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
 }
 
 // ErrDuplicateSteamID is returned when inserting a user that already exists.
@@ -568,6 +779,16 @@ var ErrDuplicateSteamID = errors.New("duplicate steamid")
 // ErrUserNotFound is returned when a user operation targets a non-existent row.
 var ErrUserNotFound = errors.New("user not found")
 
+// isUniqueViolation reports whether err is a unique constraint violation
+// on either backend.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return isSQLiteUniqueViolation(err)
+}
+
 // GetUsers returns a paginated list of all users and the total count.
 func (d *DB) GetUsers(ctx context.Context, limit, offset int) ([]User, int64, error) {
 	var total int64
@@ -576,7 +797,7 @@ func (d *DB) GetUsers(ctx context.Context, limit, offset int) ([]User, int64, er
 	}
 
 	rows, err := d.db.QueryContext(ctx,
-		"SELECT steamid, username, active, public FROM users ORDER BY steamid LIMIT $1 OFFSET $2",
+		"SELECT steamid, username, active, public, discord_notify, timezone FROM users ORDER BY steamid LIMIT $1 OFFSET $2",
 		limit, offset)
 	if err != nil {
 		return nil, 0, err
@@ -586,7 +807,7 @@ func (d *DB) GetUsers(ctx context.Context, limit, offset int) ([]User, int64, er
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.SteamID, &u.Username, &u.Active, &u.Public); err != nil {
+		if err := rows.Scan(&u.SteamID, &u.Username, &u.Active, &u.Public, &u.DiscordNotify, &u.TimeZone); err != nil {
 			return nil, 0, err
 		}
 		users = append(users, u)
@@ -594,13 +815,25 @@ func (d *DB) GetUsers(ctx context.Context, limit, offset int) ([]User, int64, er
 	return users, total, rows.Err()
 }
 
+// GetUser returns a single user, ErrUserNotFound if there is none.
+func (d *DB) GetUser(ctx context.Context, id SteamID) (User, error) {
+	var u User
+	err := d.db.QueryRowContext(ctx,
+		"SELECT steamid, username, active, public, discord_notify, timezone FROM users WHERE steamid = $1", id,
+	).Scan(&u.SteamID, &u.Username, &u.Active, &u.Public, &u.DiscordNotify, &u.TimeZone)
+	if err == sql.ErrNoRows {
+		return u, ErrUserNotFound
+	}
+	return u, err
+}
+
 // AddUser inserts a new user row.
 func (d *DB) AddUser(ctx context.Context, id SteamID, username string, active, public bool) error {
 	_, err := d.db.ExecContext(ctx,
 		"INSERT INTO users(steamid, username, active, public) VALUES($1, $2, $3, $4)",
 		id, username, active, public)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		if isUniqueViolation(err) {
 			return ErrDuplicateSteamID
 		}
 		return wrapErr(err)
@@ -626,9 +859,11 @@ func (d *DB) RemoveUser(ctx context.Context, id SteamID) error {
 // ModifyUserParams holds the optional fields for ModifyUser.
 // A nil pointer means "do not update this field".
 type ModifyUserParams struct {
-	Username *string
-	Active   *bool
-	Public   *bool
+	Username      *string
+	Active        *bool
+	Public        *bool
+	DiscordNotify *bool
+	TimeZone      *string // must be a name time.LoadLocation accepts
 }
 
 // SetUserActive sets the active status of a user.
@@ -658,6 +893,16 @@ func (d *DB) ModifyUser(ctx context.Context, id SteamID, p ModifyUserParams) err
 		args = append(args, *p.Public)
 		i++
 	}
+	if p.DiscordNotify != nil {
+		setClauses = append(setClauses, fmt.Sprintf("discord_notify = $%d", i))
+		args = append(args, *p.DiscordNotify)
+		i++
+	}
+	if p.TimeZone != nil {
+		setClauses = append(setClauses, fmt.Sprintf("timezone = $%d", i))
+		args = append(args, *p.TimeZone)
+		i++
+	}
 
 	if len(setClauses) == 0 {
 		return nil // nothing to update
@@ -759,7 +1004,7 @@ func (d *DB) CreateAuthToken(ctx context.Context, name, salt, secret string, cle
 		"INSERT INTO auth_tokens(name, salt, secret, clearance) VALUES($1, $2, $3, $4)",
 		name, salt, secret, clearance)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		if isUniqueViolation(err) {
 			return ErrDuplicateTokenName
 		}
 		return wrapErr(err)
@@ -773,9 +1018,63 @@ func (d *DB) DeleteAuthToken(ctx context.Context, name string) error {
 	return wrapErr(err)
 }
 
+// --- Read Tokens ---
+
+// ErrReadTokenNotFound is returned when a user has no read token.
+var ErrReadTokenNotFound = errors.New("read token not found")
+
+// ReadToken lets the holder read the data of one user while the user is
+// not public. Stored hashed like AuthToken.
+type ReadToken struct {
+	SteamID    SteamID
+	Salt       string
+	Secret     string
+	CreateDate time.Time
+}
+
+// GetReadToken fetches the read token of a user.
+func (d *DB) GetReadToken(ctx context.Context, id SteamID) (ReadToken, error) {
+	var t ReadToken
+	err := d.db.QueryRowContext(ctx,
+		"SELECT steamid, salt, secret, create_date FROM read_tokens WHERE steamid = $1", id,
+	).Scan(&t.SteamID, &t.Salt, &t.Secret, &t.CreateDate)
+	if err == sql.ErrNoRows {
+		return t, ErrReadTokenNotFound
+	}
+	return t, err
+}
+
+// SetReadToken creates the read token of a user, replacing any previous one.
+func (d *DB) SetReadToken(ctx context.Context, id SteamID, salt, secret string) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO read_tokens(steamid, salt, secret) VALUES($1, $2, $3)
+		ON CONFLICT (steamid) DO UPDATE SET salt = $2, secret = $3, create_date = CURRENT_TIMESTAMP`,
+		id, salt, secret)
+	return wrapErr(err)
+}
+
+// DeleteReadToken removes the read token of a user.
+func (d *DB) DeleteReadToken(ctx context.Context, id SteamID) error {
+	res, err := d.db.ExecContext(ctx, "DELETE FROM read_tokens WHERE steamid = $1", id)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrReadTokenNotFound
+	}
+	return nil
+}
+
 // --- Metadata ---
 
-const MetaKeyLastUserReload = "last_user_reload"
+const (
+	MetaKeyLastUserReload = "last_user_reload"
+	MetaKeyHeartbeat      = "heartbeat"    // RFC3339, last time the monitor was known to run
+	MetaKeyLeader         = "leader"       // Instance ID of the instance holding the leader lock, empty without one
+	MetaKeyLeaderSince    = "leader_since" // RFC3339, when the leader took the lock, empty without one
+	MetaKeyPollRequest    = "poll_request" // JSON PollRequest of the last POST /admin/poll
+	MetaKeyPollHandled    = "poll_handled" // At of the last PollRequest the leader took
+)
 
 // GetMetadata fetches the data value for a metadata key.
 func (d *DB) GetMetadata(ctx context.Context, key string) (string, error) {
@@ -794,3 +1093,297 @@ func (d *DB) SetMetadata(ctx context.Context, key, data string) error {
 		key, data)
 	return wrapErr(err)
 }
+
+// PollRequest asks the leader to poll users on its next cycle. It goes
+// through the database, so it reaches the leader from any instance.
+type PollRequest struct {
+	At       string    `json:"at"`       // RFC3339Nano, identifies the request
+	SteamIDs []SteamID `json:"steamids"` // empty for every user
+}
+
+// RequestPoll records a PollRequest for ids, or every user if ids is empty.
+// A request the leader hasn't taken yet is merged into it.
+func (d *DB) RequestPoll(ctx context.Context, ids []SteamID) error {
+	pending, err := d.pendingPollRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if pending != nil && len(pending.SteamIDs) > 0 && len(ids) > 0 {
+		ids = append(pending.SteamIDs, ids...)
+	} else if pending != nil {
+		ids = nil
+	}
+
+	data, err := json.Marshal(PollRequest{At: time.Now().UTC().Format(time.RFC3339Nano), SteamIDs: ids})
+	if err != nil {
+		return err
+	}
+	return d.SetMetadata(ctx, MetaKeyPollRequest, string(data))
+}
+
+// TakePollRequest returns the PollRequest not taken yet and marks it taken,
+// nil if there is none.
+func (d *DB) TakePollRequest(ctx context.Context) (*PollRequest, error) {
+	pending, err := d.pendingPollRequest(ctx)
+	if err != nil || pending == nil {
+		return nil, err
+	}
+	if err := d.SetMetadata(ctx, MetaKeyPollHandled, pending.At); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func (d *DB) pendingPollRequest(ctx context.Context) (*PollRequest, error) {
+	data, err := d.GetMetadata(ctx, MetaKeyPollRequest)
+	if err != nil || data == "" {
+		return nil, wrapErr(err)
+	}
+	var req PollRequest
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		return nil, err
+	}
+	handled, err := d.GetMetadata(ctx, MetaKeyPollHandled)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	if handled == req.At {
+		return nil, nil
+	}
+	return &req, nil
+}
+
+// --- Downtime ---
+
+// Downtime is a window in which the monitor was not tracking sessions.
+type Downtime struct {
+	UTCStart time.Time
+	UTCEnd   time.Time
+}
+
+// AddDowntime records a downtime window, a window with the same start is
+// extended instead of duplicated.
+func (d *DB) AddDowntime(ctx context.Context, dt Downtime) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO downtime(utcstart, utcend) VALUES($1, $2) ON CONFLICT (utcstart) DO UPDATE SET utcend = $2",
+		dt.UTCStart.UTC(), dt.UTCEnd.UTC())
+	return wrapErr(err)
+}
+
+// GetDowntimes returns the downtime windows overlapping [from, to], oldest first.
+func (d *DB) GetDowntimes(ctx context.Context, from, to time.Time) ([]Downtime, error) {
+	rows, err := d.db.QueryContext(ctx,
+		"SELECT utcstart, utcend FROM downtime WHERE utcend >= $1 AND utcstart <= $2 ORDER BY utcstart",
+		from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	downtimes := []Downtime{}
+	for rows.Next() {
+		var dt Downtime
+		if err := rows.Scan(&dt.UTCStart, &dt.UTCEnd); err != nil {
+			return nil, err
+		}
+		downtimes = append(downtimes, dt)
+	}
+	return downtimes, rows.Err()
+}
+
+// --- Webhooks ---
+
+// ErrWebhookNotFound is returned when a webhook operation targets a non-existent row.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Webhook is a subscription to session events, delivered as signed JSON
+// POSTs to URL.
+type Webhook struct {
+	ID         int64
+	URL        string
+	Secret     string
+	EventTypes []string  // empty for every type
+	SteamIDs   []SteamID // empty for every user
+	CreateDate time.Time
+}
+
+// WebhookStatus is the state of a delivery.
+type WebhookStatus string
+
+const (
+	WebhookPending   WebhookStatus = "pending" // queued, possibly retried
+	WebhookDelivered WebhookStatus = "delivered"
+	WebhookFailed    WebhookStatus = "failed" // out of attempts
+)
+
+// WebhookDelivery is one event sent to one webhook, both the retry queue
+// entry and the delivery log.
+type WebhookDelivery struct {
+	ID          int64
+	WebhookID   int64
+	EventType   string
+	Payload     string
+	Status      WebhookStatus
+	Attempts    int
+	NextAttempt time.Time
+	StatusCode  int // of the last attempt, 0 without a response
+	LastError   string
+	CreateDate  time.Time
+}
+
+const webhookColumns = "id, url, secret, event_types, steamids, create_date"
+
+func scanWebhook(row interface{ Scan(...any) error }) (Webhook, error) {
+	var w Webhook
+	var types, ids string
+	if err := row.Scan(&w.ID, &w.URL, &w.Secret, &types, &ids, &w.CreateDate); err != nil {
+		return w, err
+	}
+	if types != "" {
+		w.EventTypes = strings.Split(types, ",")
+	}
+	for _, raw := range strings.Split(ids, ",") {
+		if raw == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return w, fmt.Errorf("invalid steamid %q in webhook %d", raw, w.ID)
+		}
+		w.SteamIDs = append(w.SteamIDs, SteamID(id))
+	}
+	return w, nil
+}
+
+// AddWebhook inserts a webhook and returns its id.
+func (d *DB) AddWebhook(ctx context.Context, w Webhook) (int64, error) {
+	ids := make([]string, 0, len(w.SteamIDs))
+	for _, id := range w.SteamIDs {
+		ids = append(ids, strconv.FormatUint(uint64(id), 10))
+	}
+
+	var id int64
+	err := d.db.QueryRowContext(ctx,
+		"INSERT INTO webhooks(url, secret, event_types, steamids) VALUES($1, $2, $3, $4) RETURNING id",
+		w.URL, w.Secret, strings.Join(w.EventTypes, ","), strings.Join(ids, ",")).Scan(&id)
+	return id, wrapErr(err)
+}
+
+// GetWebhooks returns every webhook, oldest first.
+func (d *DB) GetWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// GetWebhook returns a single webhook, ErrWebhookNotFound if there is none.
+func (d *DB) GetWebhook(ctx context.Context, id int64) (Webhook, error) {
+	w, err := scanWebhook(d.db.QueryRowContext(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return w, ErrWebhookNotFound
+	}
+	return w, err
+}
+
+// RemoveWebhook deletes a webhook together with its deliveries.
+func (d *DB) RemoveWebhook(ctx context.Context, id int64) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer tx.Rollback()
+
+	// Not left to ON DELETE CASCADE, SQLite only honours it with foreign keys on
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = $1", id); err != nil {
+		return wrapErr(err)
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		return wrapErr(err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrWebhookNotFound
+	}
+	return wrapErr(tx.Commit())
+}
+
+const webhookDeliveryColumns = "id, webhook_id, event_type, payload, status, attempts, next_attempt, status_code, last_error, create_date"
+
+func scanWebhookDelivery(rows *sql.Rows) (WebhookDelivery, error) {
+	var w WebhookDelivery
+	err := rows.Scan(&w.ID, &w.WebhookID, &w.EventType, &w.Payload, &w.Status, &w.Attempts, &w.NextAttempt, &w.StatusCode, &w.LastError, &w.CreateDate)
+	return w, err
+}
+
+func (d *DB) queryWebhookDeliveries(ctx context.Context, query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		w, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, w)
+	}
+	return deliveries, rows.Err()
+}
+
+// AddWebhookDelivery queues a delivery and returns its id.
+func (d *DB) AddWebhookDelivery(ctx context.Context, w WebhookDelivery) (int64, error) {
+	var id int64
+	err := d.db.QueryRowContext(ctx,
+		`INSERT INTO webhook_deliveries(webhook_id, event_type, payload, status, attempts, next_attempt, status_code, last_error)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		w.WebhookID, w.EventType, w.Payload, w.Status, w.Attempts, w.NextAttempt.UTC(), w.StatusCode, w.LastError).Scan(&id)
+	return id, wrapErr(err)
+}
+
+// UpdateWebhookDelivery records the outcome of a delivery attempt.
+func (d *DB) UpdateWebhookDelivery(ctx context.Context, w WebhookDelivery) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE webhook_deliveries SET status = $1, attempts = $2, next_attempt = $3, status_code = $4, last_error = $5 WHERE id = $6",
+		w.Status, w.Attempts, w.NextAttempt.UTC(), w.StatusCode, w.LastError, w.ID)
+	return wrapErr(err)
+}
+
+// GetDueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is at or before now, oldest first.
+func (d *DB) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return d.queryWebhookDeliveries(ctx, "WHERE status = $1 AND next_attempt <= $2 ORDER BY next_attempt, id LIMIT $3",
+		WebhookPending, now.UTC(), limit)
+}
+
+// GetWebhookDeliveries returns the delivery log of a webhook, newest first.
+func (d *DB) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit, offset int) ([]WebhookDelivery, error) {
+	return d.queryWebhookDeliveries(ctx, "WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2 OFFSET $3",
+		webhookID, limit, offset)
+}
+
+// PruneWebhookDeliveries deletes finished deliveries created before t.
+func (d *DB) PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	res, err := d.db.ExecContext(ctx,
+		"DELETE FROM webhook_deliveries WHERE status <> $1 AND create_date < $2",
+		WebhookPending, before.UTC())
+	if err != nil {
+		return 0, wrapErr(err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
@@ -0,0 +1,35 @@
+package sptt
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite opens (creating if needed) the SQLite database at path.
+//
+// Queries are shared with Postgres, SQLite accepts the same $N
+// placeholders as long as they first appear in ascending order.
+func openSQLite(path string) (*DB, error) {
+	if path == "" {
+		return nil, DBError("sqlite database path is empty")
+	}
+
+	// Foreign keys are off by default in SQLite, busy_timeout makes concurrent
+	// writers wait instead of failing with SQLITE_BUSY.
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL", path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{db: db, driver: DriverSQLite, local: newLocalNotifier()}, nil
+}
+
+// isSQLiteUniqueViolation matches on the message since the driver's error
+// type is only available in cgo builds.
+func isSQLiteUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
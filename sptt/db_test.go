@@ -23,7 +23,7 @@ func TestSteamID(t *testing.T) {
 
 	ctx := context.Background()
 
-	db, err := newDBWithSQLFile(env["DB_USER"], env["DB_PASSWORD"], env["DB_NAME"], "../db.sql")
+	db, err := NewDB(env["DB_USER"], env["DB_PASSWORD"], env["DB_NAME"])
 	if err != nil {
 		t.Errorf("Expected nil, got %v", err)
 	}
@@ -62,7 +62,7 @@ func TestSessions(t *testing.T) {
 	if err != nil {
 		t.Errorf("Expected nil, got %v", err)
 	}
-	db, err := newDBWithSQLFile(env["DB_USER"], env["DB_PASSWORD"], env["DB_NAME"], "../db.sql")
+	db, err := NewDB(env["DB_USER"], env["DB_PASSWORD"], env["DB_NAME"])
 	ctx := context.Background()
 
 	tm := time.Date(2024, time.November, 28, 12, 0, 0, 0, time.Local)
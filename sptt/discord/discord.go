@@ -0,0 +1,138 @@
+// Package discord posts "X started playing Y" and "X played Y for 3h12m"
+// messages for session events to a Discord webhook.
+package discord
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+const (
+	colorStarted   = 0x57F287 // Discord green
+	colorConcluded = 0x5865F2 // Discord blurple
+)
+
+// QuietHours is a daily window in which no messages are posted. Start and
+// End are offsets from midnight in Location, a window with End before Start
+// spans midnight.
+type QuietHours struct {
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// ParseQuietHours parses a window like "23:00-07:30" in loc.
+func ParseQuietHours(s string, loc *time.Location) (*QuietHours, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("expected HH:MM-HH:MM, got %q", s)
+	}
+	start, err := parseClock(strings.TrimSpace(from))
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(strings.TrimSpace(to))
+	if err != nil {
+		return nil, err
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &QuietHours{Start: start, End: end, Location: loc}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls within the quiet hours.
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil || q.Start == q.End {
+		return false
+	}
+	t = t.In(q.Location)
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if q.Start < q.End {
+		return offset >= q.Start && offset < q.End
+	}
+	return offset >= q.Start || offset < q.End
+}
+
+// FormatDuration formats d as e.g. 3h12m or 45m, rounded down to minutes.
+func FormatDuration(d time.Duration) string {
+	minutes := int(d / time.Minute)
+	if minutes < 60 {
+		return strconv.Itoa(minutes) + "m"
+	}
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
+
+// message is the body of a Discord webhook request.
+type message struct {
+	Username string  `json:"username,omitempty"`
+	Embeds   []embed `json:"embeds"`
+}
+
+type embed struct {
+	Title       string     `json:"title,omitempty"`
+	URL         string     `json:"url,omitempty"`
+	Description string     `json:"description"`
+	Color       int        `json:"color"`
+	Thumbnail   *thumbnail `json:"thumbnail,omitempty"`
+	Timestamp   string     `json:"timestamp,omitempty"`
+}
+
+type thumbnail struct {
+	URL string `json:"url"`
+}
+
+// game is what a message shows of a game.
+type game struct {
+	AppID       sptt.AppID
+	Name        string
+	HeaderImage string
+}
+
+func (g game) embed(description string, color int, at time.Time) embed {
+	e := embed{
+		Title:       g.Name,
+		URL:         "https://store.steampowered.com/app/" + g.AppID.String(),
+		Description: description,
+		Color:       color,
+		Timestamp:   at.UTC().Format(time.RFC3339),
+	}
+	if g.HeaderImage != "" {
+		e.Thumbnail = &thumbnail{URL: g.HeaderImage}
+	}
+	return e
+}
+
+func startedEmbed(username string, g game, s sptt.ActiveSession) embed {
+	description := fmt.Sprintf("**%s** started playing **%s**", escapeMarkdown(username), escapeMarkdown(g.Name))
+	return g.embed(description, colorStarted, s.UTCStart)
+}
+
+func concludedEmbed(username string, g game, s sptt.Session) embed {
+	description := fmt.Sprintf("**%s** played **%s** for %s", escapeMarkdown(username), escapeMarkdown(g.Name), FormatDuration(s.UTCEnd.Sub(s.UTCStart)))
+	if s.Recovered {
+		description += " (estimated, the tracker was down)"
+	}
+	return g.embed(description, colorConcluded, s.UTCEnd)
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`, ">", `\>`,
+)
+
+// escapeMarkdown keeps names from being rendered as Discord markdown.
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
@@ -0,0 +1,220 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/sptttest"
+)
+
+const (
+	alice = sptttest.Alice // opted in
+	bob   = sptttest.Bob
+	gtfo  = sptttest.GTFO
+	hl2   = sptt.AppID(220)
+)
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0m"},
+		{45*time.Minute + 59*time.Second, "45m"},
+		{time.Hour, "1h00m"},
+		{3*time.Hour + 12*time.Minute, "3h12m"},
+		{26*time.Hour + 5*time.Minute, "26h05m"},
+	}
+	for _, tt := range tests {
+		if got := FormatDuration(tt.d); got != tt.want {
+			t.Errorf("Expected %s for %v, got %s", tt.want, tt.d, got)
+		}
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	at := func(h, m int) time.Time { return time.Date(2025, time.January, 1, h, m, 0, 0, tokyo) }
+
+	overnight, err := ParseQuietHours("23:00-07:30", tokyo)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	daytime, err := ParseQuietHours("09:00 - 17:00", tokyo)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	tests := []struct {
+		name string
+		q    *QuietHours
+		t    time.Time
+		want bool
+	}{
+		{"Before overnight", overnight, at(22, 59), false},
+		{"Start of overnight", overnight, at(23, 0), true},
+		{"After midnight", overnight, at(3, 0), true},
+		{"End of overnight", overnight, at(7, 30), false},
+		{"Other time zone", overnight, time.Date(2025, time.January, 1, 15, 0, 0, 0, time.UTC), true},
+		{"Daytime", daytime, at(12, 0), true},
+		{"After daytime", daytime, at(17, 0), false},
+		{"No quiet hours", nil, at(3, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.q.Contains(tt.t); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	for _, s := range []string{"", "23:00", "25:00-07:00", "23:00-7"} {
+		if _, err := ParseQuietHours(s, tokyo); err == nil {
+			t.Errorf("Expected an error for %q, got nil", s)
+		}
+	}
+}
+
+type fakeGames map[sptt.AppID]sptt.GameData
+
+func (f fakeGames) GetGameDetails(ctx context.Context, appid sptt.AppID) (sptt.GameData, error) {
+	if data, ok := f[appid]; ok {
+		return data, nil
+	}
+	return sptt.GameData{}, sptt.ErrNoGameData
+}
+
+// newTestNotifier returns a notifier posting to a local stand-in for
+// Discord, with alice opted in and bob not.
+func newTestNotifier(t *testing.T, cfg Config) (*Notifier, sptt.Store, chan message) {
+	t.Helper()
+	store := sptttest.NewSQLiteStore(t)
+
+	ctx := context.Background()
+	notify := true
+	store.AddUser(ctx, alice, "al_ice", true, true)
+	store.ModifyUser(ctx, alice, sptt.ModifyUserParams{DiscordNotify: &notify})
+	store.AddUser(ctx, bob, "bob", true, true)
+
+	messages := make(chan message, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("Expected JSON, got %v", err)
+		}
+		messages <- msg
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg.WebhookURL = srv.URL
+	games := fakeGames{gtfo: {AppID: gtfo, Name: "GTFO", HeaderImage: "https://example.com/gtfo.jpg"}}
+	n := NewNotifier(ctx, store, games, events.NewBus(), &sync.WaitGroup{}, cfg)
+	return n, store, messages
+}
+
+func expectMessage(t *testing.T, messages chan message, description string) {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		if len(msg.Embeds) != 1 || msg.Embeds[0].Description != description {
+			t.Errorf("Expected %q, got %+v", description, msg)
+		}
+	default:
+		t.Errorf("Expected %q, got no message", description)
+	}
+}
+
+func expectNoMessage(t *testing.T, messages chan message) {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		t.Errorf("Expected no message, got %+v", msg)
+	default:
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	start := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	quiet, _ := ParseQuietHours("23:00-07:00", time.UTC)
+	n, store, messages := newTestNotifier(t, Config{MinSession: 10 * time.Minute, QuietHours: quiet})
+	n.now = func() time.Time { return now }
+
+	started := func(id sptt.SteamID, appid sptt.AppID) {
+		n.handle(events.SessionStarted{Session: sptt.ActiveSession{SteamID: id, AppID: appid, UTCStart: start}})
+	}
+	concluded := func(id sptt.SteamID, appid sptt.AppID, d time.Duration) {
+		n.handle(events.SessionConcluded{Session: sptt.Session{SteamID: id, AppID: appid, UTCStart: start, UTCEnd: start.Add(d)}})
+	}
+
+	t.Run("Sessions are announced once they lasted MinSession", func(t *testing.T) {
+		started(alice, gtfo)
+		now = start.Add(5 * time.Minute)
+		n.announceDue()
+		expectNoMessage(t, messages)
+
+		now = start.Add(10 * time.Minute)
+		n.announceDue()
+		expectMessage(t, messages, `**al\_ice** started playing **GTFO**`)
+
+		now = start.Add(3*time.Hour + 12*time.Minute)
+		concluded(alice, gtfo, 3*time.Hour+12*time.Minute)
+		expectMessage(t, messages, `**al\_ice** played **GTFO** for 3h12m`)
+
+		if game, err := store.GetGameCache(context.Background(), gtfo); err != nil || game.Name != "GTFO" {
+			t.Errorf("Expected GTFO to be cached, got %+v (%v)", game, err)
+		}
+	})
+
+	t.Run("Short sessions are suppressed", func(t *testing.T) {
+		now = start.Add(9 * time.Minute)
+		started(alice, gtfo)
+		concluded(alice, gtfo, 9*time.Minute)
+		now = start.Add(time.Hour)
+		n.announceDue()
+		expectNoMessage(t, messages)
+	})
+
+	t.Run("Users that didn't opt in are not posted", func(t *testing.T) {
+		started(bob, gtfo)
+		n.announceDue()
+		concluded(bob, gtfo, time.Hour)
+		expectNoMessage(t, messages)
+	})
+
+	t.Run("Quiet hours", func(t *testing.T) {
+		now = time.Date(2025, time.January, 2, 1, 0, 0, 0, time.UTC)
+		concluded(alice, gtfo, time.Hour)
+		expectNoMessage(t, messages)
+	})
+
+	t.Run("Games missing from the store", func(t *testing.T) {
+		now = start.Add(time.Hour)
+		concluded(alice, hl2, time.Hour)
+		expectMessage(t, messages, `**al\_ice** played **App 220** for 1h00m`)
+	})
+}
+
+func TestNotifierWithoutMinSession(t *testing.T) {
+	n, _, messages := newTestNotifier(t, Config{})
+	n.handle(events.SessionStarted{Session: sptt.ActiveSession{SteamID: alice, AppID: gtfo, UTCStart: time.Now()}})
+
+	select {
+	case msg := <-messages:
+		e := msg.Embeds[0]
+		if e.Title != "GTFO" || e.Thumbnail == nil || !strings.HasSuffix(e.URL, "/app/493520") || e.Color != colorStarted {
+			t.Errorf("Expected a GTFO embed, got %+v", e)
+		}
+	default:
+		t.Errorf("Expected the start to be posted right away, got no message")
+	}
+}
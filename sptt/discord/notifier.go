@@ -0,0 +1,264 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+)
+
+const (
+	DefaultMinSession = 5 * time.Minute
+	DefaultTimeout    = 10 * time.Second
+
+	// How often started sessions are checked for having lasted MinSession
+	pendingCheckInterval = 30 * time.Second
+	// Longest Retry-After honoured when Discord rate limits a message
+	maxRetryAfter = 10 * time.Second
+)
+
+// Store is the part of sptt.Store the notifier needs.
+type Store interface {
+	GetUser(ctx context.Context, id sptt.SteamID) (sptt.User, error)
+	GetGameCache(ctx context.Context, appid sptt.AppID) (*sptt.GameCache, error)
+	AddGameCache(ctx context.Context, game sptt.GameCache) error
+}
+
+// GameDetailsClient fetches store details of games not in the catalog yet.
+type GameDetailsClient interface {
+	GetGameDetails(ctx context.Context, appid sptt.AppID) (sptt.GameData, error)
+}
+
+type Config struct {
+	WebhookURL string        // Discord webhook, the notifier doesn't run without one
+	MinSession time.Duration // sessions shorter than this are not posted at all
+	QuietHours *QuietHours   // nil to post around the clock
+	Username   string        // sender name, the webhook's own name if empty
+	Client     *http.Client
+}
+
+// Notifier posts session events of users with DiscordNotify set to a
+// Discord webhook. A session is only announced once it has lasted
+// MinSession, so short sessions post neither the start nor the end.
+// Messages due during quiet hours are dropped.
+type Notifier struct {
+	ctx   context.Context
+	store Store
+	steam GameDetailsClient
+	bus   *events.Bus
+	wg    *sync.WaitGroup
+	cfg   Config
+	now   func() time.Time
+
+	// Started sessions not announced yet, only touched by Run
+	pending map[sessionKey]sptt.ActiveSession
+}
+
+type sessionKey struct {
+	steamid sptt.SteamID
+	appid   sptt.AppID
+}
+
+// NewNotifier returns a notifier for cfg, steam may be nil to only use the
+// catalog for game names.
+func NewNotifier(ctx context.Context, store Store, steam GameDetailsClient, bus *events.Bus, wg *sync.WaitGroup, cfg Config) *Notifier {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	return &Notifier{
+		ctx:     ctx,
+		store:   store,
+		steam:   steam,
+		bus:     bus,
+		wg:      wg,
+		cfg:     cfg,
+		now:     time.Now,
+		pending: make(map[sessionKey]sptt.ActiveSession),
+	}
+}
+
+// Run posts session events until the context is cancelled.
+func (n *Notifier) Run() {
+	defer n.wg.Done()
+
+	sub := n.bus.Subscribe(events.TypeSessionStarted, events.TypeSessionConcluded)
+	defer sub.Close()
+
+	ticker := time.NewTicker(pendingCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			n.handle(e)
+		case <-ticker.C:
+			n.announceDue()
+		}
+	}
+}
+
+func (n *Notifier) handle(e events.Event) {
+	switch e := e.(type) {
+	case events.SessionStarted:
+		s := e.Session
+		user, ok := n.optedIn(s.SteamID)
+		if !ok {
+			return
+		}
+		if n.cfg.MinSession <= 0 {
+			n.announceStart(user, s)
+			return
+		}
+		n.pending[sessionKey{s.SteamID, s.AppID}] = s
+
+	case events.SessionConcluded:
+		s := e.Session
+		delete(n.pending, sessionKey{s.SteamID, s.AppID})
+		if d := s.UTCEnd.Sub(s.UTCStart); d < n.cfg.MinSession {
+			log.Debugf("Not posting %v session of user %v in game %v, shorter than %v", d, s.SteamID, s.AppID, n.cfg.MinSession)
+			return
+		}
+		user, ok := n.optedIn(s.SteamID)
+		if !ok {
+			return
+		}
+		g := n.game(s.AppID)
+		n.post(concludedEmbed(user.Username, g, s))
+	}
+}
+
+// announceDue announces the pending sessions that have lasted MinSession.
+func (n *Notifier) announceDue() {
+	now := n.now()
+	for key, s := range n.pending {
+		if now.Sub(s.UTCStart) < n.cfg.MinSession {
+			continue
+		}
+		delete(n.pending, key)
+		// Checked again, the user may have opted out in the meantime
+		if user, ok := n.optedIn(s.SteamID); ok {
+			n.announceStart(user, s)
+		}
+	}
+}
+
+func (n *Notifier) announceStart(user sptt.User, s sptt.ActiveSession) {
+	g := n.game(s.AppID)
+	n.post(startedEmbed(user.Username, g, s))
+}
+
+// optedIn returns the user if they want their sessions posted.
+func (n *Notifier) optedIn(id sptt.SteamID) (sptt.User, bool) {
+	user, err := n.store.GetUser(n.ctx, id)
+	if err != nil {
+		if !errors.Is(err, sptt.ErrUserNotFound) {
+			log.Errorf("Error while trying to get user %v: %v", id, err)
+		}
+		return user, false
+	}
+	return user, user.DiscordNotify
+}
+
+// game looks up the name and image of appid in the catalog, fetching it
+// from the store if the catalog hasn't got to it yet.
+func (n *Notifier) game(appid sptt.AppID) game {
+	g := game{AppID: appid, Name: "App " + appid.String()}
+
+	cached, err := n.store.GetGameCache(n.ctx, appid)
+	if errors.Is(err, sptt.ErrGameNotFound) {
+		cached, err = n.fetchGame(appid)
+	}
+	if err != nil {
+		log.Warnf("No name for game %v: %v", appid, err)
+		return g
+	}
+	if cached.Available && cached.Name != "" {
+		g.Name = cached.Name
+		g.HeaderImage = cached.HeaderImage
+	}
+	return g
+}
+
+// fetchGame fetches store details of appid and adds them to the catalog.
+func (n *Notifier) fetchGame(appid sptt.AppID) (*sptt.GameCache, error) {
+	if n.steam == nil {
+		return nil, sptt.ErrGameNotFound
+	}
+	data, err := n.steam.GetGameDetails(n.ctx, appid)
+	if err != nil {
+		return nil, err
+	}
+	cached := sptt.GameCacheFromData(data)
+	// The store may answer with the parent app, keep the appid we asked for
+	cached.AppID = appid
+	if err := n.store.AddGameCache(n.ctx, cached); err != nil {
+		log.Errorf("Error while trying to cache game %v: %v", appid, err)
+	}
+	return &cached, nil
+}
+
+// post sends e to the webhook unless it's quiet hours.
+func (n *Notifier) post(e embed) {
+	if n.cfg.QuietHours.Contains(n.now()) {
+		log.Debugf("Quiet hours, not posting %q", e.Description)
+		return
+	}
+	if err := n.send(message{Username: n.cfg.Username, Embeds: []embed{e}}); err != nil {
+		log.Warnf("Error while posting to Discord: %v", err)
+	}
+}
+
+// send posts msg, retrying once if Discord asks to wait briefly.
+func (n *Notifier) send(msg message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.cfg.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := n.cfg.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return nil
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt > 0 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		wait, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+		if err != nil || time.Duration(wait*float64(time.Second)) > maxRetryAfter {
+			return fmt.Errorf("rate limited")
+		}
+		select {
+		case <-n.ctx.Done():
+			return n.ctx.Err()
+		case <-time.After(time.Duration(wait * float64(time.Second))):
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package events
+
+import (
+	"sync"
+)
+
+// Bus delivers published events to every subscription interested in their
+// type. Publish never blocks, events queue up per subscription until its
+// subscriber receives them. Only subscriptions with a limit lose events, they
+// are closed once their queue is full. A nil *Bus discards events, so
+// publishers don't need to check whether events are enabled.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives events on C until Close is called.
+type Subscription struct {
+	C <-chan Event
+
+	bus        *Bus
+	types      map[Type]bool // nil for every type
+	limit      int           // 0 for an unbounded queue
+	local      bool          // skips events relayed from other instances
+	out        chan Event
+	mu         sync.Mutex
+	queue      []Event
+	overflowed bool
+	notify     chan struct{}
+	done       chan struct{}
+	once       sync.Once
+}
+
+// Subscribe returns a subscription to the given types, or to every type if
+// none are given. Its queue is unbounded, for subscribers in this process
+// that keep up with the events.
+func (b *Bus) Subscribe(types ...Type) *Subscription {
+	return b.SubscribeWithLimit(0, types...)
+}
+
+// SubscribeWithLimit is Subscribe for subscribers that may stall, such as
+// network clients. Once limit events wait in its queue the subscription is
+// closed, see Overflowed.
+func (b *Bus) SubscribeWithLimit(limit int, types ...Type) *Subscription {
+	return b.subscribe(limit, false, types)
+}
+
+func (b *Bus) subscribe(limit int, local bool, types []Type) *Subscription {
+	s := &Subscription{
+		bus:    b,
+		limit:  limit,
+		local:  local,
+		out:    make(chan Event),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	s.C = s.out
+	if len(types) > 0 {
+		s.types = make(map[Type]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+
+	go s.pump()
+	return s
+}
+
+// Publish queues e for every subscription interested in its type.
+func (b *Bus) Publish(e Event) {
+	b.publish(e, false)
+}
+
+// publishRemote is Publish for events relayed from another instance, which
+// must not be relayed again.
+func (b *Bus) publishRemote(e Event) {
+	b.publish(e, true)
+}
+
+func (b *Bus) publish(e Event, remote bool) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subs {
+		if remote && s.local {
+			continue
+		}
+		if s.types == nil || s.types[e.Type()] {
+			s.enqueue(e)
+		}
+	}
+}
+
+// Subscribers returns the number of open subscriptions.
+func (b *Bus) Subscribers() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
+
+// Close stops delivery and closes C. Events still queued are discarded.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.done)
+	})
+}
+
+// Overflowed reports whether the subscription was closed because its
+// subscriber fell behind by more than its limit.
+func (s *Subscription) Overflowed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.overflowed
+}
+
+func (s *Subscription) enqueue(e Event) {
+	s.mu.Lock()
+	if s.overflowed {
+		s.mu.Unlock()
+		return
+	}
+	if s.limit > 0 && len(s.queue) >= s.limit {
+		s.overflowed = true
+		s.queue = nil
+		s.mu.Unlock()
+		// Publish holds the bus lock Close needs
+		go s.Close()
+		return
+	}
+	s.queue = append(s.queue, e)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pump moves queued events to C in order, waiting for the subscriber.
+func (s *Subscription) pump() {
+	defer close(s.out)
+
+	for {
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, e := range queue {
+			select {
+			case s.out <- e:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+func receive(t *testing.T, s *Subscription) Event {
+	t.Helper()
+	select {
+	case e := <-s.C:
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("Expected an event, got none")
+		return nil
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe()
+	defer all.Close()
+	sessions := bus.Subscribe(TypeSessionStarted, TypeSessionConcluded)
+	defer sessions.Close()
+
+	alice := sptt.SteamID(76561198000000001)
+	bus.Publish(UserAdded{SteamID: alice, Username: "alice"})
+	bus.Publish(SessionStarted{Session: sptt.ActiveSession{SteamID: alice}})
+
+	if e, ok := receive(t, all).(UserAdded); !ok || e.SteamID != alice {
+		t.Errorf("Expected UserAdded for %v, got %+v", alice, e)
+	}
+	if e := receive(t, all); e.Type() != TypeSessionStarted {
+		t.Errorf("Expected %s, got %s", TypeSessionStarted, e.Type())
+	}
+	if e := receive(t, sessions); e.Type() != TypeSessionStarted {
+		t.Errorf("Expected only session events, got %s", e.Type())
+	}
+
+	t.Run("Slow subscribers don't block or lose events", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 1000; i++ {
+				bus.Publish(ForcePoll{})
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected Publish not to block")
+		}
+
+		for i := 0; i < 1000; i++ {
+			if e := receive(t, all); e.Type() != TypeForcePoll {
+				t.Fatalf("Expected %s, got %s", TypeForcePoll, e.Type())
+			}
+		}
+	})
+
+	t.Run("Stalled subscribers are cut off", func(t *testing.T) {
+		limited := bus.SubscribeWithLimit(10, TypeForcePoll)
+		for i := 0; i < 100; i++ {
+			bus.Publish(ForcePoll{})
+		}
+
+		received := 0
+		timeout := time.After(time.Second)
+		for open := true; open; {
+			select {
+			case _, open = <-limited.C:
+				if open {
+					received++
+				}
+			case <-timeout:
+				t.Fatalf("Expected C to be closed")
+			}
+		}
+		if !limited.Overflowed() || received > 20 {
+			t.Errorf("Expected the subscription to overflow, got %d events", received)
+		}
+		for i := 0; bus.Subscribers() != 2 && i < 100; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if got := bus.Subscribers(); got != 2 {
+			t.Errorf("Expected 2 subscribers left, got %d", got)
+		}
+		if all.Overflowed() {
+			t.Errorf("Expected unbounded subscriptions not to overflow")
+		}
+		for i := 0; i < 100; i++ {
+			receive(t, all)
+		}
+	})
+
+	t.Run("Closed subscriptions stop receiving", func(t *testing.T) {
+		sessions.Close()
+		bus.Publish(SessionConcluded{})
+		if _, ok := <-sessions.C; ok {
+			t.Errorf("Expected C to be closed")
+		}
+		sessions.Close()
+	})
+
+	t.Run("Nil bus discards", func(t *testing.T) {
+		var nilBus *Bus
+		nilBus.Publish(UserRemoved{SteamID: alice})
+	})
+}
@@ -0,0 +1,84 @@
+// Package events is the in-process event bus connecting the monitor, the
+// API and anything else that reacts to users and sessions.
+package events
+
+import (
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+// Type names an event, it is stable and used on the wire.
+type Type string
+
+const (
+	TypeUserAdded          Type = "user_added"
+	TypeUserRemoved        Type = "user_removed"
+	TypeUserPaused         Type = "user_paused"
+	TypeUserResumed        Type = "user_resumed"
+	TypeUserListReloaded   Type = "user_list_reloaded"
+	TypeForcePoll          Type = "force_poll"
+	TypeSessionStarted     Type = "session_started"
+	TypeSessionConcluded   Type = "session_concluded"
+	TypeProfileWentPrivate Type = "profile_went_private"
+)
+
+// Event is one of the event structs of this package.
+type Event interface {
+	Type() Type
+}
+
+// UserAdded is published when a user is added through the admin API.
+type UserAdded struct {
+	SteamID  sptt.SteamID
+	Username string
+	Active   bool
+}
+
+// UserRemoved is published when a user is deleted.
+type UserRemoved struct {
+	SteamID sptt.SteamID
+}
+
+// UserPaused is published when a user is set inactive and no longer polled.
+type UserPaused struct {
+	SteamID sptt.SteamID
+}
+
+// UserResumed is published when an inactive user is set active again.
+type UserResumed struct {
+	SteamID sptt.SteamID
+}
+
+// UserListReloaded asks the monitor to read the user list from the database.
+type UserListReloaded struct{}
+
+// ForcePoll asks the monitor to poll users now instead of when they are due,
+// all users if SteamIDs is empty.
+type ForcePoll struct {
+	SteamIDs []sptt.SteamID
+}
+
+// SessionStarted is published when the monitor sees a user start a game.
+type SessionStarted struct {
+	Session sptt.ActiveSession
+}
+
+// SessionConcluded is published when a session is moved to the history.
+type SessionConcluded struct {
+	Session sptt.Session
+}
+
+// ProfileWentPrivate is published when a user is deactivated because their
+// profile is no longer public.
+type ProfileWentPrivate struct {
+	SteamID sptt.SteamID
+}
+
+func (UserAdded) Type() Type          { return TypeUserAdded }
+func (UserRemoved) Type() Type        { return TypeUserRemoved }
+func (UserPaused) Type() Type         { return TypeUserPaused }
+func (UserResumed) Type() Type        { return TypeUserResumed }
+func (UserListReloaded) Type() Type   { return TypeUserListReloaded }
+func (ForcePoll) Type() Type          { return TypeForcePoll }
+func (SessionStarted) Type() Type     { return TypeSessionStarted }
+func (SessionConcluded) Type() Type   { return TypeSessionConcluded }
+func (ProfileWentPrivate) Type() Type { return TypeProfileWentPrivate }
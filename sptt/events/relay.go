@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+)
+
+// RelayChannel is the notification channel events are relayed on.
+const RelayChannel = "sptt_events"
+
+// SessionTypes are published by the leader's monitor only, the other
+// instances need them relayed to serve the event streams.
+var SessionTypes = []Type{TypeSessionStarted, TypeSessionConcluded, TypeProfileWentPrivate}
+
+// Notifier sends notifications to every instance sharing the database, it
+// is implemented by sptt.DB.
+type Notifier interface {
+	Notify(ctx context.Context, channel, payload string) error
+	Listen(ctx context.Context, channel string) (<-chan string, error)
+}
+
+// Relay shares events between the buses of instances sharing a database.
+// Events of the relayed types published on this instance's bus are sent to
+// the other instances, theirs are published on this bus without being sent
+// on again.
+type Relay struct {
+	ctx      context.Context
+	bus      *Bus
+	notifier Notifier
+	instance string
+	types    []Type
+	wg       *sync.WaitGroup
+}
+
+func NewRelay(ctx context.Context, bus *Bus, notifier Notifier, instance string, wg *sync.WaitGroup, types ...Type) *Relay {
+	return &Relay{
+		ctx:      ctx,
+		bus:      bus,
+		notifier: notifier,
+		instance: instance,
+		types:    types,
+		wg:       wg,
+	}
+}
+
+// relayed is the notification payload of an event.
+type relayed struct {
+	Instance string          `json:"instance"`
+	Type     Type            `json:"type"`
+	Event    json.RawMessage `json:"event"`
+}
+
+func decode[T Event](data []byte) (Event, error) {
+	var e T
+	err := json.Unmarshal(data, &e)
+	return e, err
+}
+
+var decoders = map[Type]func([]byte) (Event, error){
+	TypeUserAdded:          decode[UserAdded],
+	TypeUserRemoved:        decode[UserRemoved],
+	TypeUserPaused:         decode[UserPaused],
+	TypeUserResumed:        decode[UserResumed],
+	TypeUserListReloaded:   decode[UserListReloaded],
+	TypeForcePoll:          decode[ForcePoll],
+	TypeSessionStarted:     decode[SessionStarted],
+	TypeSessionConcluded:   decode[SessionConcluded],
+	TypeProfileWentPrivate: decode[ProfileWentPrivate],
+}
+
+// Run relays events until the context is cancelled.
+func (r *Relay) Run() {
+	defer r.wg.Done()
+
+	notifications, err := r.notifier.Listen(r.ctx, RelayChannel)
+	if err != nil {
+		log.Error("Error while listening for relayed events, events of other instances won't be streamed: ", err)
+		return
+	}
+	sub := r.bus.subscribe(0, true, r.types)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case payload, ok := <-notifications:
+			if !ok {
+				return
+			}
+			r.receive(payload)
+		case e := <-sub.C:
+			r.send(e)
+		}
+	}
+}
+
+func (r *Relay) send(e Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Error while encoding %s event: %v", e.Type(), err)
+		return
+	}
+	payload, err := json.Marshal(relayed{Instance: r.instance, Type: e.Type(), Event: data})
+	if err != nil {
+		log.Errorf("Error while encoding %s event: %v", e.Type(), err)
+		return
+	}
+	if err := r.notifier.Notify(r.ctx, RelayChannel, string(payload)); err != nil {
+		log.Errorf("Error while relaying %s event: %v", e.Type(), err)
+	}
+}
+
+func (r *Relay) receive(payload string) {
+	e, instance, err := decodeRelayed(payload)
+	if err != nil {
+		log.Error("Error while decoding relayed event: ", err)
+		return
+	}
+	// Listeners get their own instance's notifications too
+	if instance == r.instance {
+		return
+	}
+	r.bus.publishRemote(e)
+}
+
+func decodeRelayed(payload string) (Event, string, error) {
+	var msg relayed
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		return nil, "", err
+	}
+	decoder, ok := decoders[msg.Type]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown event type %q", msg.Type)
+	}
+	e, err := decoder(msg.Event)
+	return e, msg.Instance, err
+}
@@ -0,0 +1,52 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+func TestRelayedEvents(t *testing.T) {
+	alice := sptt.SteamID(76561198000000001)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []Event{
+		UserAdded{SteamID: alice, Username: "alice", Active: true},
+		UserRemoved{SteamID: alice},
+		UserPaused{SteamID: alice},
+		UserResumed{SteamID: alice},
+		UserListReloaded{},
+		ForcePoll{SteamIDs: []sptt.SteamID{alice}},
+		SessionStarted{Session: sptt.ActiveSession{SteamID: alice, AppID: 570, UTCStart: start, LastSeen: start}},
+		SessionConcluded{Session: sptt.Session{SteamID: alice, AppID: 570, UTCStart: start, UTCEnd: start.Add(time.Hour)}},
+		ProfileWentPrivate{SteamID: alice},
+	}
+
+	for _, want := range tests {
+		t.Run(string(want.Type()), func(t *testing.T) {
+			data, err := json.Marshal(want)
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			payload, err := json.Marshal(relayed{Instance: "a", Type: want.Type(), Event: data})
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+
+			got, instance, err := decodeRelayed(string(payload))
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			if instance != "a" || !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected %+v from a, got %+v from %s", want, got, instance)
+			}
+		})
+	}
+
+	if _, _, err := decodeRelayed(`{"instance":"a","type":"unknown","event":{}}`); err == nil {
+		t.Errorf("Expected an error for an unknown type, got nil")
+	}
+}
@@ -0,0 +1,81 @@
+package sptt
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+)
+
+// Key of the advisory lock held by the instance running the monitor.
+const leaderLockKey = 0x5350_5454_0002
+
+// LeaderLock is the lease of the instance allowed to poll Steam and write
+// sessions. On Postgres it is a session advisory lock held on a dedicated
+// connection, Postgres releases it when that connection goes away, so a
+// leader that dies hands over without cleanup.
+type LeaderLock struct {
+	conn *sql.Conn // nil if the driver has no advisory locks
+}
+
+// TryLeaderLock takes the leader lock without waiting, returns nil if
+// another instance holds it. SQLite databases can't be shared between
+// instances, the lock is always granted there.
+func (d *DB) TryLeaderLock(ctx context.Context) (*LeaderLock, error) {
+	if d.driver != DriverPostgres {
+		return &LeaderLock{}, nil
+	}
+
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", leaderLockKey).Scan(&acquired)
+	if err != nil {
+		conn.Close()
+		return nil, wrapErr(err)
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+	return &LeaderLock{conn}, nil
+}
+
+// Check returns an error if the connection holding the lock broke, in which
+// case the lock may already belong to another instance.
+func (l *LeaderLock) Check(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	var one int
+	return wrapErr(l.conn.QueryRowContext(ctx, "SELECT 1").Scan(&one))
+}
+
+// Release gives up the lock. If it can't be unlocked the connection is
+// discarded instead of returned to the pool, which releases it as well.
+func (l *LeaderLock) Release() {
+	if l.conn == nil {
+		return
+	}
+	// Use a fresh context, the lock must be released even if ctx is cancelled
+	_, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", leaderLockKey)
+	if err != nil {
+		log.Warn("Error while releasing leader lock, dropping its connection: ", err)
+		l.conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	l.conn.Close()
+}
+
+// ClearLeader empties MetaKeyLeader and MetaKeyLeaderSince if instance is
+// still the recorded leader. It is a single statement, so the record of a
+// leader that took over in the meantime is left alone.
+func (d *DB) ClearLeader(ctx context.Context, instance string) error {
+	_, err := d.db.ExecContext(ctx, `UPDATE metadata SET data = ''
+		WHERE key IN ($1, $2) AND EXISTS (SELECT 1 FROM metadata WHERE key = $1 AND data = $3)`,
+		MetaKeyLeader, MetaKeyLeaderSince, instance)
+	return wrapErr(err)
+}
@@ -0,0 +1,96 @@
+package sptt
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// LeaderboardQuery selects a page of the leaderboard of public users.
+type LeaderboardQuery struct {
+	AppID    *AppID     // nil for the playtime across all games
+	From     *time.Time // sessions straddling the range count with the part inside it
+	To       *time.Time
+	Page     int32
+	PageSize int32
+}
+
+// LeaderboardEntry is the tracked playtime of one user on the leaderboard.
+type LeaderboardEntry struct {
+	Rank     int64 // users with the same playtime share a rank
+	SteamID  SteamID
+	Username string
+	Sessions int64
+	Playtime time.Duration
+}
+
+// leaderboardCTE builds "ranked", the playtime of every public user with
+// sessions matching q.
+func (d *DB) leaderboardCTE(q LeaderboardQuery) (string, []interface{}) {
+	lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+	if q.From != nil {
+		lo = q.From.Unix()
+	}
+	if q.To != nil {
+		hi = q.To.Unix()
+	}
+	args := []interface{}{lo, hi}
+
+	clause := ""
+	if q.AppID != nil {
+		clause = " AND s.appid = $3"
+		args = append(args, *q.AppID)
+	}
+
+	cte := fmt.Sprintf(`WITH bounds(lo, hi) AS (
+			SELECT CAST($1 AS bigint), CAST($2 AS bigint)
+		), clipped AS (
+			SELECT s.steamid, %s(%s, b.lo) AS st, %s(%s, b.hi) AS en
+			FROM sessions s JOIN users u ON u.steamid = s.steamid, bounds b
+			WHERE u.public = true%s
+		), ranked AS (
+			SELECT steamid, COUNT(*) AS sessions, SUM(en - st) AS playtime
+			FROM clipped WHERE en > st GROUP BY steamid
+		) `, d.greatestSQL(), d.epochSQL("s.utcstart"), d.leastSQL(), d.epochSQL("s.utcend"), clause)
+	return cte, args
+}
+
+// GetLeaderboard ranks public users by their tracked playtime, most first,
+// and returns a page of it with the number of users on it.
+func (d *DB) GetLeaderboard(ctx context.Context, q LeaderboardQuery) ([]LeaderboardEntry, int64, error) {
+	cte, args := d.leaderboardCTE(q)
+
+	var total int64
+	if err := d.db.QueryRowContext(ctx, cte+"SELECT COUNT(*) FROM ranked", args...).Scan(&total); err != nil {
+		return nil, 0, wrapErr(err)
+	}
+
+	n := len(args) + 1
+	query := cte + fmt.Sprintf(`SELECT RANK() OVER (ORDER BY r.playtime DESC), r.steamid, u.username, r.sessions, r.playtime
+		FROM ranked r JOIN users u ON u.steamid = r.steamid
+		ORDER BY r.playtime DESC, r.sessions DESC, r.steamid
+		LIMIT $%d OFFSET $%d`, n, n+1)
+	args = append(args, q.PageSize, q.PageSize*q.Page)
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, wrapErr(err)
+	}
+	defer rows.Close()
+
+	entries := []LeaderboardEntry{}
+	for rows.Next() {
+		var e LeaderboardEntry
+		var secs int64
+		if err := rows.Scan(&e.Rank, &e.SteamID, &e.Username, &e.Sessions, &secs); err != nil {
+			return nil, 0, wrapErr(err)
+		}
+		e.Playtime = time.Duration(secs) * time.Second
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, wrapErr(err)
+	}
+	return entries, total, nil
+}
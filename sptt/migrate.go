@@ -0,0 +1,288 @@
+package sptt
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+)
+
+const (
+	ErrSchemaTooNew      = DBError("database schema is newer than this binary, upgrade the binary or run migrate down with a newer one")
+	ErrInvalidMigrations = DBError("embedded migrations are invalid")
+)
+
+// Key of the advisory lock held while migrating, so that concurrently
+// starting instances don't apply the same migration twice.
+const migrationLockKey = 0x5350_5454_0001
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFS embed.FS
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change.
+// Versions start at 1 and have no gaps.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus describes whether a migration is applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// loadMigrations reads and validates the migrations embedded in the binary
+// for a driver. Every driver has its own directory with the same versions.
+func loadMigrations(driver Driver) ([]Migration, error) {
+	dir := path.Join("migrations", string(driver))
+
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		m := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("%w: unexpected file %s", ErrInvalidMigrations, entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+
+		content, err := fs.ReadFile(migrationFS, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("%w: version %d has names %s and %s", ErrInvalidMigrations, version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(content)
+		} else {
+			mig.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			return nil, fmt.Errorf("%w: expected version %d, got %d", ErrInvalidMigrations, i+1, mig.Version)
+		}
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("%w: version %d is missing its up or down file", ErrInvalidMigrations, mig.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// LatestSchemaVersion returns the schema version this binary expects.
+func (d *DB) LatestSchemaVersion() int {
+	migrations, err := loadMigrations(d.driver)
+	if err != nil {
+		return 0
+	}
+	return len(migrations)
+}
+
+// ensureSchemaVersionTable creates the table recording applied migrations.
+func ensureSchemaVersionTable(ctx context.Context, q interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}) error {
+	_, err := q.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version    integer PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	return wrapErr(err)
+}
+
+// SchemaVersion returns the highest applied migration, 0 for an empty database.
+func (d *DB) SchemaVersion(ctx context.Context) (int, error) {
+	if err := ensureSchemaVersionTable(ctx, d.db); err != nil {
+		return 0, err
+	}
+
+	var version int
+	err := d.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, wrapErr(err)
+}
+
+// MigrationStatus lists every embedded migration and whether it is applied.
+// Applied versions unknown to this binary are listed too, with an empty name.
+func (d *DB) MigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(d.driver)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureSchemaVersionTable(ctx, d.db); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_version ORDER BY version")
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, wrapErr(err)
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, mig := range migrations {
+		at, ok := applied[mig.Version]
+		status = append(status, MigrationStatus{Version: mig.Version, Name: mig.Name, Applied: ok, AppliedAt: at})
+		delete(applied, mig.Version)
+	}
+	for version, at := range applied {
+		status = append(status, MigrationStatus{Version: version, Applied: true, AppliedAt: at})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+
+	return status, nil
+}
+
+// MigrateUp applies all pending migrations in order and returns the number
+// applied. Fails with ErrSchemaTooNew if the database is ahead of the binary.
+func (d *DB) MigrateUp(ctx context.Context) (int, error) {
+	migrations, err := loadMigrations(d.driver)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	err = d.withMigrationLock(ctx, func(conn *sql.Conn, current int) error {
+		if current > len(migrations) {
+			return ErrSchemaTooNew
+		}
+		for _, mig := range migrations[current:] {
+			log.Infof("Applying migration %04d_%s", mig.Version, mig.Name)
+			if err := applyMigration(ctx, conn, mig.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "INSERT INTO schema_version(version, name) VALUES($1, $2)", mig.Version, mig.Name)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown rolls back the last `steps` applied migrations.
+func (d *DB) MigrateDown(ctx context.Context, steps int) (int, error) {
+	migrations, err := loadMigrations(d.driver)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	err = d.withMigrationLock(ctx, func(conn *sql.Conn, current int) error {
+		if current > len(migrations) {
+			return ErrSchemaTooNew
+		}
+		for ; reverted < steps && current > 0; current-- {
+			mig := migrations[current-1]
+			log.Infof("Reverting migration %04d_%s", mig.Version, mig.Name)
+			if err := applyMigration(ctx, conn, mig.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_version WHERE version = $1", mig.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("reverting migration %04d_%s failed: %w", mig.Version, mig.Name, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// withMigrationLock runs fn on a dedicated connection holding the migration
+// advisory lock, passing the schema version read under the lock.
+// SQLite has no advisory locks, its single writer already serializes the
+// migration transactions.
+func (d *DB) withMigrationLock(ctx context.Context, fn func(conn *sql.Conn, current int) error) error {
+	conn, err := d.db.Conn(ctx)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer conn.Close()
+
+	if d.driver == DriverPostgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+			return wrapErr(err)
+		}
+		defer func() {
+			// Use a fresh context, the lock must be released even if ctx is cancelled
+			if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+				log.Error("Error while releasing migration lock: ", err)
+			}
+		}()
+	}
+
+	if err := ensureSchemaVersionTable(ctx, conn); err != nil {
+		return err
+	}
+
+	var current int
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current); err != nil {
+		return wrapErr(err)
+	}
+
+	return fn(conn, current)
+}
+
+// applyMigration runs a migration script and its schema_version update in
+// one transaction.
+func applyMigration(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
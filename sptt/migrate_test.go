@@ -0,0 +1,39 @@
+package sptt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	pg, err := loadMigrations(DriverPostgres)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if len(pg) == 0 {
+		t.Fatal("Expected migrations, got none")
+	}
+
+	for i, mig := range pg {
+		if mig.Version != i+1 {
+			t.Errorf("Expected version %d, got %d", i+1, mig.Version)
+		}
+		if strings.TrimSpace(mig.Up) == "" || strings.TrimSpace(mig.Down) == "" {
+			t.Errorf("Expected up and down for %04d_%s", mig.Version, mig.Name)
+		}
+	}
+
+	// Drivers must stay in lockstep so schema versions mean the same thing
+	lite, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if len(lite) != len(pg) {
+		t.Fatalf("Expected %d sqlite migrations, got %d", len(pg), len(lite))
+	}
+	for i := range pg {
+		if lite[i].Name != pg[i].Name {
+			t.Errorf("Expected %s, got %s", pg[i].Name, lite[i].Name)
+		}
+	}
+}
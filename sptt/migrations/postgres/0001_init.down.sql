@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS metadata;
+DROP TABLE IF EXISTS auth_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS active_sessions;
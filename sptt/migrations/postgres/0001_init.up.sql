@@ -1,11 +1,3 @@
--- DROP TABLE FOR DEV ENVIRONMENT
--- DROP TABLE IF EXISTS active_sessions;
--- DROP TABLE IF EXISTS sessions;
--- DROP TABLE IF EXISTS games;
--- DROP TABLE IF EXISTS users;
-
-
-
 -- Active Sessions (Temporary Data)
 CREATE TABLE IF NOT EXISTS active_sessions (
     steamid bigint,
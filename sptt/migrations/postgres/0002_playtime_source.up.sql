@@ -0,0 +1,2 @@
+-- Steam endpoint that supplied playtime_forever: owned_games, recently_played or none
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS playtime_source text;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS downtime;
+ALTER TABLE sessions DROP COLUMN IF EXISTS recovered;
+ALTER TABLE active_sessions DROP COLUMN IF EXISTS last_seen;
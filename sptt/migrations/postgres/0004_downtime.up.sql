@@ -0,0 +1,12 @@
+-- Last poll that saw the user in the game, sessions orphaned by downtime end here
+ALTER TABLE active_sessions ADD COLUMN IF NOT EXISTS last_seen timestamp;
+
+-- Sessions concluded by startup recovery instead of a regular poll
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS recovered boolean NOT NULL DEFAULT false;
+
+-- Downtime (windows without tracking, from the last heartbeat to the next start)
+CREATE TABLE IF NOT EXISTS downtime (
+    utcstart timestamp,
+    utcend timestamp NOT NULL,
+    PRIMARY KEY (utcstart)
+);
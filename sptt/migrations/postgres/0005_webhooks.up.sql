@@ -0,0 +1,26 @@
+-- Webhook subscriptions
+CREATE TABLE IF NOT EXISTS webhooks (
+    id          SERIAL    PRIMARY KEY,
+    url         text      NOT NULL,
+    secret      text      NOT NULL, -- HMAC-SHA256 key of the X-Sptt-Signature header
+    event_types text      NOT NULL, -- Comma separated, empty for every type
+    steamids    text      NOT NULL, -- Comma separated, empty for every user
+    create_date timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Webhook deliveries (retry queue and delivery log)
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id           BIGSERIAL PRIMARY KEY,
+    webhook_id   integer   NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_type   text      NOT NULL,
+    payload      text      NOT NULL,
+    status       text      NOT NULL, -- pending, delivered or failed
+    attempts     integer   NOT NULL DEFAULT 0,
+    next_attempt timestamp NOT NULL,
+    status_code  integer   NOT NULL DEFAULT 0, -- Of the last attempt, 0 without a response
+    last_error   text      NOT NULL DEFAULT '',
+    create_date  timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id);
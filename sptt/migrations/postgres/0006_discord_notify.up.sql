@@ -0,0 +1,2 @@
+-- Users opted in to Discord notifications of their sessions
+ALTER TABLE users ADD COLUMN IF NOT EXISTS discord_notify boolean NOT NULL DEFAULT false;
@@ -0,0 +1,7 @@
+-- Read tokens (let a user view their own data while not public)
+CREATE TABLE IF NOT EXISTS read_tokens (
+    steamid     bigint    PRIMARY KEY REFERENCES users(steamid) ON DELETE CASCADE,
+    salt        text      NOT NULL,
+    secret      text      NOT NULL,
+    create_date timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
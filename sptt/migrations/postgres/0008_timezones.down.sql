@@ -0,0 +1,18 @@
+ALTER TABLE read_tokens
+    ALTER COLUMN create_date TYPE timestamp USING create_date::timestamp;
+ALTER TABLE webhook_deliveries
+    ALTER COLUMN next_attempt TYPE timestamp USING next_attempt AT TIME ZONE 'UTC',
+    ALTER COLUMN create_date TYPE timestamp USING create_date::timestamp;
+ALTER TABLE webhooks
+    ALTER COLUMN create_date TYPE timestamp USING create_date::timestamp;
+ALTER TABLE downtime
+    ALTER COLUMN utcstart TYPE timestamp USING utcstart AT TIME ZONE 'UTC',
+    ALTER COLUMN utcend TYPE timestamp USING utcend AT TIME ZONE 'UTC';
+ALTER TABLE active_sessions
+    ALTER COLUMN utcstart TYPE timestamp USING utcstart AT TIME ZONE 'UTC',
+    ALTER COLUMN last_seen TYPE timestamp USING last_seen AT TIME ZONE 'UTC';
+ALTER TABLE sessions
+    ALTER COLUMN utcstart TYPE timestamp USING utcstart AT TIME ZONE 'UTC',
+    ALTER COLUMN utcend TYPE timestamp USING utcend AT TIME ZONE 'UTC';
+
+ALTER TABLE users DROP COLUMN timezone;
@@ -0,0 +1,21 @@
+-- Home timezone of a user (IANA name), used for day boundaries in stats
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone text NOT NULL DEFAULT 'UTC';
+
+-- Timestamps written by the tracker are UTC, columns filled by
+-- CURRENT_TIMESTAMP are in the session's TimeZone
+ALTER TABLE sessions
+    ALTER COLUMN utcstart TYPE timestamptz USING utcstart AT TIME ZONE 'UTC',
+    ALTER COLUMN utcend TYPE timestamptz USING utcend AT TIME ZONE 'UTC';
+ALTER TABLE active_sessions
+    ALTER COLUMN utcstart TYPE timestamptz USING utcstart AT TIME ZONE 'UTC',
+    ALTER COLUMN last_seen TYPE timestamptz USING last_seen AT TIME ZONE 'UTC';
+ALTER TABLE downtime
+    ALTER COLUMN utcstart TYPE timestamptz USING utcstart AT TIME ZONE 'UTC',
+    ALTER COLUMN utcend TYPE timestamptz USING utcend AT TIME ZONE 'UTC';
+ALTER TABLE webhooks
+    ALTER COLUMN create_date TYPE timestamptz USING create_date::timestamptz;
+ALTER TABLE webhook_deliveries
+    ALTER COLUMN next_attempt TYPE timestamptz USING next_attempt AT TIME ZONE 'UTC',
+    ALTER COLUMN create_date TYPE timestamptz USING create_date::timestamptz;
+ALTER TABLE read_tokens
+    ALTER COLUMN create_date TYPE timestamptz USING create_date::timestamptz;
@@ -0,0 +1,3 @@
+-- Set on catalog entries whose fetch failed, the appid is fetched again
+-- once it has passed
+ALTER TABLE games ADD COLUMN IF NOT EXISTS retry_after timestamptz;
@@ -0,0 +1,46 @@
+-- Active Sessions (Temporary Data)
+CREATE TABLE IF NOT EXISTS active_sessions (
+    steamid bigint,
+    appid integer,
+    utcstart timestamp,
+    playtime_forever integer, -- Total playtime in minutes according to Steam API
+    PRIMARY KEY (steamid, appid)
+);
+
+-- Sessions (History/Permanent Data)
+CREATE TABLE IF NOT EXISTS sessions (
+    steamid bigint,
+    utcstart timestamp,
+    utcend timestamp,
+    playtime_forever integer, -- Total playtime in minutes according to Steam API
+    appid integer,
+    PRIMARY KEY (steamid, utcstart)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_steamid ON sessions(steamid);
+
+-- Auth Tokens
+CREATE TABLE IF NOT EXISTS auth_tokens (
+    id          integer   PRIMARY KEY AUTOINCREMENT,
+    name        text      NOT NULL UNIQUE,
+    salt        text      NOT NULL,
+    secret      text      NOT NULL,
+    clearance   integer   NOT NULL,
+    create_date timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Metadata (key-value store for server state)
+CREATE TABLE IF NOT EXISTS metadata (
+    id   integer PRIMARY KEY AUTOINCREMENT,
+    key  text NOT NULL UNIQUE,
+    data text NOT NULL
+);
+
+-- Registered Users
+CREATE TABLE IF NOT EXISTS users (
+    steamid bigint,
+    username text UNIQUE NOT NULL, -- Internal username
+    active boolean NOT NULL,
+    public boolean NOT NULL,
+    PRIMARY KEY (steamid)
+);
@@ -0,0 +1,2 @@
+-- Steam endpoint that supplied playtime_forever: owned_games, recently_played or none
+ALTER TABLE sessions ADD COLUMN playtime_source text;
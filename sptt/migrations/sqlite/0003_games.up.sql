@@ -0,0 +1,11 @@
+-- Game Catalog (metadata from the store appdetails endpoint)
+CREATE TABLE IF NOT EXISTS games (
+    appid integer PRIMARY KEY,
+    name text NOT NULL,
+    publisher text NOT NULL,
+    developer text NOT NULL,
+    header_image text NOT NULL,
+    recommendations integer NOT NULL,
+    available boolean NOT NULL, -- false if the store has no data for the app
+    fetched_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,12 @@
+-- Last poll that saw the user in the game, sessions orphaned by downtime end here
+ALTER TABLE active_sessions ADD COLUMN last_seen timestamp;
+
+-- Sessions concluded by startup recovery instead of a regular poll
+ALTER TABLE sessions ADD COLUMN recovered boolean NOT NULL DEFAULT false;
+
+-- Downtime (windows without tracking, from the last heartbeat to the next start)
+CREATE TABLE IF NOT EXISTS downtime (
+    utcstart timestamp,
+    utcend timestamp NOT NULL,
+    PRIMARY KEY (utcstart)
+);
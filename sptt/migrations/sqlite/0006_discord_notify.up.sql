@@ -0,0 +1,2 @@
+-- Users opted in to Discord notifications of their sessions
+ALTER TABLE users ADD COLUMN discord_notify boolean NOT NULL DEFAULT false;
@@ -0,0 +1,5 @@
+-- Home timezone of a user (IANA name), used for day boundaries in stats
+ALTER TABLE users ADD COLUMN timezone text NOT NULL DEFAULT 'UTC';
+
+-- Timestamps keep their timestamp type, the driver only parses columns
+-- declared as date, datetime or timestamp and already stores the offset.
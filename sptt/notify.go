@@ -0,0 +1,110 @@
+package sptt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sebun1/steamPlaytimeTracker/log"
+)
+
+// Notifications buffered per listener, a SQLite listener that falls further
+// behind loses them.
+const listenBuffer = 64
+
+// Notify sends payload to every listener of channel, including those of the
+// sending instance. Postgres limits payloads to about 8000 bytes.
+func (d *DB) Notify(ctx context.Context, channel, payload string) error {
+	if d.driver != DriverPostgres {
+		d.local.notify(channel, payload)
+		return nil
+	}
+	_, err := d.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return wrapErr(err)
+}
+
+// Listen returns the payloads sent to channel until ctx is cancelled, the
+// returned channel is closed then. On Postgres it LISTENs on a connection of
+// its own, notifications sent while that connection is reconnecting are
+// lost. SQLite databases can't be shared between instances, notifications
+// are delivered within the process there.
+func (d *DB) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	if d.driver != DriverPostgres {
+		return d.local.listen(ctx, channel), nil
+	}
+
+	listener := pq.NewListener(d.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("Notification listener error: ", err)
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return nil, wrapErr(err)
+	}
+
+	out := make(chan string, listenBuffer)
+	go func() {
+		defer close(out)
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				if n == nil { // reconnected
+					continue
+				}
+				select {
+				case out <- n.Extra:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+// localNotifier delivers notifications to the listeners of one process.
+type localNotifier struct {
+	mu        sync.Mutex
+	listeners map[string]map[chan string]struct{}
+}
+
+func newLocalNotifier() *localNotifier {
+	return &localNotifier{listeners: make(map[string]map[chan string]struct{})}
+}
+
+func (n *localNotifier) notify(channel, payload string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for ch := range n.listeners[channel] {
+		select {
+		case ch <- payload:
+		default:
+			log.Warnf("Notification listener of %s is full, dropping a notification", channel)
+		}
+	}
+}
+
+func (n *localNotifier) listen(ctx context.Context, channel string) <-chan string {
+	ch := make(chan string, listenBuffer)
+
+	n.mu.Lock()
+	if n.listeners[channel] == nil {
+		n.listeners[channel] = make(map[chan string]struct{})
+	}
+	n.listeners[channel][ch] = struct{}{}
+	n.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		n.mu.Lock()
+		delete(n.listeners[channel], ch)
+		n.mu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
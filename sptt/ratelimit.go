@@ -0,0 +1,83 @@
+package sptt
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by all requests of an endpoint family.
+// Tokens refill continuously at `rate` per second up to `burst`.
+// When upstream asks us to back off (429 Retry-After), the whole family is
+// paused until the given time.
+type rateLimiter struct {
+	mu         sync.Mutex
+	rate       float64 // tokens per second
+	burst      float64
+	tokens     float64
+	last       time.Time
+	pauseUntil time.Time
+}
+
+// newRateLimiter creates a limiter allowing n requests per period,
+// with at most burst requests sent back to back.
+func newRateLimiter(n int, period time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(n) / period.Seconds(),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	for {
+		delay := r.reserve()
+		if delay <= 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve takes a token if one is available and returns 0,
+// otherwise returns how long to wait before trying again.
+func (r *rateLimiter) reserve() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(r.pauseUntil) {
+		return r.pauseUntil.Sub(now)
+	}
+
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if r.tokens >= 1 {
+		r.tokens--
+		return 0
+	}
+	return time.Duration((1 - r.tokens) / r.rate * float64(time.Second))
+}
+
+// Pause holds back every request of this family until t.
+func (r *rateLimiter) Pause(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.After(r.pauseUntil) {
+		r.pauseUntil = t
+	}
+}
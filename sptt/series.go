@@ -0,0 +1,147 @@
+package sptt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Bucket is the width of the intervals a playtime series is summed over.
+type Bucket string
+
+const (
+	BucketDay   Bucket = "day"
+	BucketWeek  Bucket = "week" // starting on Monday
+	BucketMonth Bucket = "month"
+)
+
+// MaxSeriesBuckets limits how many intervals a series may have.
+const MaxSeriesBuckets = 1000
+
+var ErrTooManyBuckets = errors.New("too many buckets in series")
+
+// Start returns the start of the bucket t falls in, in t's location.
+func (b Bucket) Start(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch b {
+	case BucketWeek:
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case BucketMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// Next returns the start of the bucket after the one starting at t. Days
+// follow the calendar, so they aren't 24h across a DST change.
+func (b Bucket) Next(t time.Time) time.Time {
+	switch b {
+	case BucketWeek:
+		return t.AddDate(0, 0, 7)
+	case BucketMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// SeriesQuery selects the playtime series of a user.
+type SeriesQuery struct {
+	Bucket   Bucket
+	From     time.Time // rounded down to the start of its bucket
+	To       time.Time // exclusive, rounded up to the end of its bucket
+	Location *time.Location
+	AppID    *AppID
+	ByApp    bool // break every bucket down per game
+}
+
+// SeriesPoint is the playtime within one bucket of a series.
+type SeriesPoint struct {
+	Start    time.Time
+	End      time.Time
+	Playtime time.Duration
+	Apps     []AppPlaytime // only with ByApp, most played first
+}
+
+type AppPlaytime struct {
+	AppID    AppID
+	Playtime time.Duration
+}
+
+// SeriesBuckets returns the buckets of q from the bucket containing From
+// up to To, as the start of each and the end of the last.
+func SeriesBuckets(q SeriesQuery) ([]time.Time, error) {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	bounds := []time.Time{q.Bucket.Start(q.From.In(loc))}
+	for bounds[len(bounds)-1].Before(q.To) {
+		if len(bounds) > MaxSeriesBuckets {
+			return nil, ErrTooManyBuckets
+		}
+		bounds = append(bounds, q.Bucket.Next(bounds[len(bounds)-1]))
+	}
+	return bounds, nil
+}
+
+// GetPlaytimeSeries sums the playtime of a user per bucket of q. Every
+// bucket is returned, also those without play. A session spanning several
+// buckets counts in each with the part inside it.
+func (d *DB) GetPlaytimeSeries(ctx context.Context, id SteamID, q SeriesQuery) ([]SeriesPoint, error) {
+	bounds, err := SeriesBuckets(q)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]SeriesPoint, len(bounds)-1)
+	index := make(map[int64]int, len(points))
+	for i := range points {
+		points[i] = SeriesPoint{Start: bounds[i], End: bounds[i+1]}
+		index[bounds[i].Unix()] = i
+	}
+	if len(points) == 0 {
+		return points, nil
+	}
+
+	first, last := bounds[0], bounds[len(bounds)-1]
+	cte, args := d.statsCTE(id, SessionFilter{AppID: q.AppID, UTCStartFrom: &first, UTCEndTo: &last})
+
+	values := make([]string, len(points))
+	for i, p := range points {
+		n := len(args) + 1
+		values[i] = fmt.Sprintf("(CAST($%d AS bigint), CAST($%d AS bigint))", n, n+1)
+		args = append(args, p.Start.Unix(), p.End.Unix())
+	}
+	query := cte + fmt.Sprintf(`, buckets(lo, hi) AS (VALUES %s)
+		SELECT b.lo, c.appid, SUM(%s(c.e, b.hi) - %s(c.s, b.lo)) AS playtime
+		FROM clipped c JOIN buckets b ON c.s < b.hi AND c.e > b.lo
+		GROUP BY b.lo, c.appid
+		ORDER BY b.lo, playtime DESC, c.appid`, strings.Join(values, ", "), d.leastSQL(), d.greatestSQL())
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lo, secs int64
+		var appid AppID
+		if err := rows.Scan(&lo, &appid, &secs); err != nil {
+			return nil, wrapErr(err)
+		}
+		p := &points[index[lo]]
+		playtime := time.Duration(secs) * time.Second
+		p.Playtime += playtime
+		if q.ByApp {
+			p.Apps = append(p.Apps, AppPlaytime{AppID: appid, Playtime: playtime})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	return points, nil
+}
@@ -0,0 +1,30 @@
+// Package sptttest holds the fixtures shared by the tests of the sptt
+// packages.
+package sptttest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+// Users and games the tests register, what they stand for is up to each
+// test.
+const (
+	Alice = sptt.SteamID(76561198000000001)
+	Bob   = sptt.SteamID(76561198000000002)
+	GTFO  = sptt.AppID(493520)
+)
+
+// NewSQLiteStore returns a migrated SQLite store in a temporary directory,
+// closed when the test ends.
+func NewSQLiteStore(t testing.TB) sptt.Store {
+	t.Helper()
+	s, err := sptt.NewStore(sptt.DBConfig{Driver: sptt.DriverSQLite, Name: filepath.Join(t.TempDir(), "sptt.db")})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
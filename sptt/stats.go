@@ -0,0 +1,289 @@
+package sptt
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// UserStats summarises the concluded sessions of a user within a time
+// range. Sessions straddling the range only count with the part inside it.
+type UserStats struct {
+	Sessions  int64
+	Total     time.Duration
+	Average   time.Duration
+	Longest   *Session    // nil without sessions, times clipped to the range
+	Games     []GameStats // ranked by playtime
+	Weekdays  [7]time.Duration
+	Hours     [24]time.Duration
+	FirstSeen time.Time // zero without sessions
+	LastSeen  time.Time
+
+	// Consecutive days with play. The current streak is 0 unless the user
+	// played on the last day of the range or the day before.
+	CurrentStreak int
+	LongestStreak int
+}
+
+// GameStats is the playtime of one game in UserStats.
+type GameStats struct {
+	AppID    AppID
+	Name     string // empty if the game isn't in the catalog
+	Sessions int64
+	Playtime time.Duration
+}
+
+// StatsRange returns the window the time bounds of f describe, the later of
+// the two lower bounds and the earlier of the two upper bounds. Either is
+// nil if unbounded.
+func StatsRange(f SessionFilter) (from, to *time.Time) {
+	for _, t := range []*time.Time{f.UTCStartFrom, f.UTCEndFrom} {
+		if t != nil && (from == nil || t.After(*from)) {
+			from = t
+		}
+	}
+	for _, t := range []*time.Time{f.UTCStartTo, f.UTCEndTo} {
+		if t != nil && (to == nil || t.Before(*to)) {
+			to = t
+		}
+	}
+	return from, to
+}
+
+// epochSQL returns the Unix time of a timestamp column in whole seconds.
+func (d *DB) epochSQL(col string) string {
+	if d.driver == DriverSQLite {
+		return fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER)", col)
+	}
+	return fmt.Sprintf("CAST(EXTRACT(EPOCH FROM %s) AS bigint)", col)
+}
+
+// greatestSQL and leastSQL are the multi-argument MAX and MIN.
+func (d *DB) greatestSQL() string {
+	if d.driver == DriverSQLite {
+		return "MAX"
+	}
+	return "GREATEST"
+}
+
+func (d *DB) leastSQL() string {
+	if d.driver == DriverSQLite {
+		return "MIN"
+	}
+	return "LEAST"
+}
+
+// statsCTE builds the common table expressions the stats queries select
+// from: "clipped" holds the sessions matching f as s and e, their start and
+// end in Unix seconds clipped to the range. Sessions outside the range end
+// up with e <= s.
+//
+// The bounds come first, SQLite numbers the $N placeholders in the order
+// they appear.
+func (d *DB) statsCTE(id SteamID, f SessionFilter) (string, []interface{}) {
+	lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+	from, to := StatsRange(f)
+	if from != nil {
+		lo = from.Unix()
+	}
+	if to != nil {
+		hi = to.Unix()
+	}
+	f.UTCStartFrom, f.UTCStartTo, f.UTCEndFrom, f.UTCEndTo = nil, nil, nil, nil
+
+	clause, args, _ := sessionWhereArgs(f, 4)
+	args = append([]interface{}{lo, hi, id}, args...)
+
+	cte := fmt.Sprintf(`WITH RECURSIVE bounds(lo, hi) AS (
+			SELECT CAST($1 AS bigint), CAST($2 AS bigint)
+		), clipped AS (
+			SELECT appid, playtime_forever, COALESCE(playtime_source, '') AS playtime_source, recovered,
+				%s(%s, b.lo) AS s, %s(%s, b.hi) AS e
+			FROM sessions, bounds b WHERE steamid = $3%s
+		) `, d.greatestSQL(), d.epochSQL("utcstart"), d.leastSQL(), d.epochSQL("utcend"), clause)
+	return cte, args
+}
+
+// zonePeriod is a span of Unix time in which a location has one UTC offset.
+type zonePeriod struct {
+	lo, hi, offset int64
+}
+
+// zonePeriods returns the periods of loc covering from to to, the first
+// and last are unbounded.
+func zonePeriods(loc *time.Location, from, to time.Time) []zonePeriod {
+	var periods []zonePeriod
+	lo := int64(math.MinInt64)
+	for t := from.In(loc); ; {
+		_, offset := t.Zone()
+		_, end := t.ZoneBounds()
+		if end.IsZero() || !end.Before(to) {
+			return append(periods, zonePeriod{lo, math.MaxInt64, int64(offset)})
+		}
+		periods = append(periods, zonePeriod{lo, end.Unix(), int64(offset)})
+		lo, t = end.Unix(), end
+	}
+}
+
+// piecesCTE extends the expressions of statsCTE, whose args are argc, with
+// "pieces": the clipped sessions in wall clock time of loc, as Unix seconds
+// shifted by the UTC offset, and split at every full hour so they can be
+// grouped by hour and day.
+func (d *DB) piecesCTE(argc int, loc *time.Location, from, to time.Time) (string, []interface{}) {
+	periods := zonePeriods(loc, from, to)
+	values := make([]string, len(periods))
+	args := make([]interface{}, 0, 3*len(periods))
+	for i, p := range periods {
+		n := argc + len(args) + 1
+		values[i] = fmt.Sprintf("(CAST($%d AS bigint), CAST($%d AS bigint), CAST($%d AS bigint))", n, n+1, n+2)
+		args = append(args, p.lo, p.hi, p.offset)
+	}
+
+	greatest, least := d.greatestSQL(), d.leastSQL()
+	cte := fmt.Sprintf(`, zones(lo, hi, off) AS (VALUES %s
+		), zoned AS (
+			SELECT %s(c.s, z.lo) + z.off AS s, %s(c.e, z.hi) + z.off AS e
+			FROM clipped c JOIN zones z ON c.s < z.hi AND c.e > z.lo
+		), pieces(s, e, until) AS (
+			SELECT s, %s(e, s - s %% 3600 + 3600), e FROM zoned WHERE e > s
+			UNION ALL
+			SELECT e, %s(until, e + 3600), until FROM pieces WHERE e < until
+		) `, strings.Join(values, ", "), greatest, least, least, least)
+	return cte, args
+}
+
+// GetUserStats computes UserStats for the sessions of a user matching f.
+// The time bounds of f are taken as one range, see StatsRange. Hours,
+// weekdays and the days of streaks are those of loc. now is the last day a
+// current streak may end on if f has no upper bound.
+func (d *DB) GetUserStats(ctx context.Context, id SteamID, f SessionFilter, loc *time.Location, now time.Time) (UserStats, error) {
+	var stats UserStats
+	cte, args := d.statsCTE(id, f)
+
+	var total int64
+	var first, last sql.NullInt64
+	err := d.db.QueryRowContext(ctx, cte+"SELECT COUNT(*), COALESCE(SUM(e - s), 0), MIN(s), MAX(e) FROM clipped WHERE e > s", args...).
+		Scan(&stats.Sessions, &total, &first, &last)
+	if err != nil {
+		return stats, wrapErr(err)
+	}
+	if stats.Sessions == 0 {
+		return stats, nil
+	}
+	stats.Total = time.Duration(total) * time.Second
+	stats.Average = stats.Total / time.Duration(stats.Sessions)
+	stats.FirstSeen = time.Unix(first.Int64, 0).UTC()
+	stats.LastSeen = time.Unix(last.Int64, 0).UTC()
+
+	var longest Session
+	var s, e int64
+	err = d.db.QueryRowContext(ctx, cte+"SELECT appid, playtime_forever, playtime_source, recovered, s, e FROM clipped WHERE e > s ORDER BY e - s DESC, s LIMIT 1", args...).
+		Scan(&longest.AppID, &longest.PlaytimeForever, &longest.PlaytimeSource, &longest.Recovered, &s, &e)
+	if err != nil {
+		return stats, wrapErr(err)
+	}
+	longest.SteamID = id
+	longest.UTCStart = time.Unix(s, 0).UTC()
+	longest.UTCEnd = time.Unix(e, 0).UTC()
+	stats.Longest = &longest
+
+	if stats.Games, err = d.gameStats(ctx, cte, args); err != nil {
+		return stats, err
+	}
+
+	if loc == nil {
+		loc = time.UTC
+	}
+	pieces, piecesArgs := d.piecesCTE(len(args), loc, stats.FirstSeen, stats.LastSeen)
+	cte, args = cte+pieces, append(args, piecesArgs...)
+
+	// 1970-01-01 was a Thursday
+	rows, err := d.db.QueryContext(ctx, cte+"SELECT (s / 86400 + 4) % 7, (s % 86400) / 3600, SUM(e - s) FROM pieces GROUP BY 1, 2", args...)
+	if err != nil {
+		return stats, wrapErr(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var weekday, hour, secs int64
+		if err := rows.Scan(&weekday, &hour, &secs); err != nil {
+			return stats, wrapErr(err)
+		}
+		stats.Weekdays[weekday] += time.Duration(secs) * time.Second
+		stats.Hours[hour] += time.Duration(secs) * time.Second
+	}
+	if err := rows.Err(); err != nil {
+		return stats, wrapErr(err)
+	}
+
+	_, to := StatsRange(f)
+	if to != nil && to.Before(now) {
+		now = *to
+	}
+	stats.CurrentStreak, stats.LongestStreak, err = d.streaks(ctx, cte, args, now.In(loc))
+	return stats, err
+}
+
+func (d *DB) gameStats(ctx context.Context, cte string, args []interface{}) ([]GameStats, error) {
+	rows, err := d.db.QueryContext(ctx, cte+`SELECT c.appid, COALESCE(g.name, ''), COUNT(*), SUM(c.e - c.s) AS playtime
+		FROM clipped c LEFT JOIN games g ON g.appid = c.appid
+		WHERE c.e > c.s
+		GROUP BY c.appid, g.name
+		ORDER BY playtime DESC, c.appid`, args...)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer rows.Close()
+
+	games := []GameStats{}
+	for rows.Next() {
+		var g GameStats
+		var secs int64
+		if err := rows.Scan(&g.AppID, &g.Name, &g.Sessions, &secs); err != nil {
+			return nil, wrapErr(err)
+		}
+		g.Playtime = time.Duration(secs) * time.Second
+		games = append(games, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+	return games, nil
+}
+
+// streaks finds the runs of consecutive days with play in the location of
+// now. Days minus their rank are equal within a run.
+func (d *DB) streaks(ctx context.Context, cte string, args []interface{}, now time.Time) (current, longest int, err error) {
+	rows, err := d.db.QueryContext(ctx, cte+`, days AS (
+			SELECT DISTINCT s / 86400 AS day FROM pieces
+		), runs AS (
+			SELECT day, day - ROW_NUMBER() OVER (ORDER BY day) AS run FROM days
+		)
+		SELECT MAX(day), COUNT(*) FROM runs GROUP BY run ORDER BY MAX(day)`, args...)
+	if err != nil {
+		return 0, 0, wrapErr(err)
+	}
+	defer rows.Close()
+
+	_, offset := now.Zone()
+	today := (now.Unix() + int64(offset)) / 86400
+	for rows.Next() {
+		var last int64
+		var length int
+		if err := rows.Scan(&last, &length); err != nil {
+			return 0, 0, wrapErr(err)
+		}
+		longest = max(longest, length)
+		// Runs come in order, only the last one can be current
+		current = 0
+		if last >= today-1 && last <= today {
+			current = length
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, wrapErr(err)
+	}
+	return current, longest, nil
+}
@@ -0,0 +1,36 @@
+package sptt
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestZonePeriods(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("No time zone database: %v", err)
+	}
+	from := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	periods := zonePeriods(berlin, from, from.AddDate(1, 0, 0))
+	summer := time.Date(2025, time.March, 30, 1, 0, 0, 0, time.UTC).Unix()
+	winter := time.Date(2025, time.October, 26, 1, 0, 0, 0, time.UTC).Unix()
+	want := []zonePeriod{
+		{math.MinInt64, summer, 3600},
+		{summer, winter, 7200},
+		{winter, math.MaxInt64, 3600},
+	}
+	if len(periods) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, periods)
+	}
+	for i := range want {
+		if periods[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want[i], periods[i])
+		}
+	}
+
+	if periods := zonePeriods(time.UTC, from, from.AddDate(1, 0, 0)); len(periods) != 1 || periods[0].offset != 0 {
+		t.Errorf("Expected one period without offset, got %v", periods)
+	}
+}
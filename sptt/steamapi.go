@@ -6,18 +6,41 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sebun1/steamPlaytimeTracker/log"
 )
 
+// SteamClient is the part of the Steam API the tracker depends on.
+// It is implemented by *SteamAPI and can be replaced by fakes in tests.
+type SteamClient interface {
+	GetPlayerSummaries(ctx context.Context, steamids []SteamID) (map[SteamID]PlayerSummary, error)
+	GetOwnedGames(ctx context.Context, steamid SteamID, appids []AppID) (map[AppID]GameInfo, error)
+	GetRecentlyPlayedGames(ctx context.Context, steamid SteamID) (map[AppID]RecentGame, error)
+	GetGameDetails(ctx context.Context, appid AppID) (GameData, error)
+}
+
+var (
+	_ SteamClient    = (*SteamAPI)(nil)
+	_ VanityResolver = (*SteamAPI)(nil)
+)
+
 type SteamAPI struct {
-	apiKey string
-	client *http.Client
+	apiKey       string
+	client       *http.Client
+	retry        retryPolicy
+	webBaseURL   string
+	storeBaseURL string
+
+	// Shared limiters per endpoint family
+	webLimiter   *rateLimiter
+	storeLimiter *rateLimiter
 }
 
 // Errors associated with Steam API operations
@@ -25,7 +48,30 @@ const (
 	ErrForbidden     = APIError("API responded with 403 Forbidden")
 	ErrEmptyResponse = APIError("API returned empty response")
 	ErrEmptyGames    = APIError("API returned 0 games")
-	RequestTimeout   = 13
+	ErrNoGameData    = APIError("API returned no data for app")
+	// Returned after retries are exhausted on 429 Too Many Requests
+	ErrRateLimited = APIError("API responded with 429 Too Many Requests")
+	// Returned after retries are exhausted on 5xx responses
+	ErrUpstreamUnavailable = APIError("API is unavailable (5xx)")
+	RequestTimeout         = 13
+)
+
+const (
+	// Web API keys are limited to 100k calls per day
+	WebAPIRequestsPerDay = 100000
+	WebAPIBurst          = 20
+	// The store appdetails endpoint allows 200 requests per 5 minutes
+	StoreRequestsPer5Min = 200
+	StoreBurst           = 10
+	// Retries after the first attempt on 429/5xx
+	MaxRequestRetries = 3
+)
+
+const (
+	// Steam rejects GetPlayerSummaries requests with more than 100 steamids
+	PlayerSummariesBatchSize = 100
+	// Maximum number of GetPlayerSummaries batches requested concurrently
+	PlayerSummariesConcurrency = 4
 )
 
 type APIError string
@@ -34,6 +80,36 @@ func (e APIError) Error() string {
 	return string(e)
 }
 
+// BatchFailure is a single failed batch of a chunked request
+type BatchFailure struct {
+	SteamIDs []SteamID
+	Err      error
+}
+
+// BatchError is returned by chunked requests when one or more batches
+// failed. Results of the successful batches are returned alongside it.
+type BatchError struct {
+	Failed []BatchFailure
+}
+
+func (e *BatchError) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d batch(es) failed", len(e.Failed))
+	for _, f := range e.Failed {
+		fmt.Fprintf(&sb, "; %d steamids starting at %v: %v", len(f.SteamIDs), uint64(f.SteamIDs[0]), f.Err)
+	}
+	return sb.String()
+}
+
+// Unwrap exposes the batch errors so errors.Is/As can match them.
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
 // Data Types for certain steam attributes
 type SteamID uint64
 
@@ -85,7 +161,7 @@ func (a *AppID) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-// GameData schema. Currently unused
+// GameData schema of the store appdetails endpoint
 type GameData struct {
 	Name             string   `json:"name"`
 	AppID            AppID    `json:"steam_appid"`
@@ -102,24 +178,63 @@ type GameData struct {
 		ComingSoon bool   `json:"coming_soon"`
 		Date       string `json:"date"`
 	}
+	Recommendations struct {
+		Total uint32 `json:"total"`
+	} `json:"recommendations"`
 	Background string `json:"background_raw"`
 }
 
-type GameDataResponse struct {
-	Games map[string]struct {
-		Success bool     `json:"success"`
-		Data    GameData `json:"data"`
-	}
+// GameDataResponse is keyed by the requested appid
+type GameDataResponse map[string]struct {
+	Success bool     `json:"success"`
+	Data    GameData `json:"data"`
+}
+
+const (
+	DefaultWebAPIBaseURL = "https://api.steampowered.com"
+	DefaultStoreBaseURL  = "https://store.steampowered.com"
+)
+
+// SteamAPIConfig holds optional overrides for NewSteamAPIWithConfig.
+// Zero values fall back to the real Steam endpoints and a default client.
+type SteamAPIConfig struct {
+	WebAPIBaseURL string
+	StoreBaseURL  string
+	HTTPClient    *http.Client
 }
 
 // NewSteamAPI creates a new SteamAPI object
 // SteamAPI itself should never be declared directly
 func NewSteamAPI(apiKey string) *SteamAPI {
-	return &SteamAPI{
-		apiKey: apiKey,
-		client: &http.Client{},
+	return NewSteamAPIWithConfig(apiKey, SteamAPIConfig{})
+}
+
+// NewSteamAPIWithConfig creates a new SteamAPI object talking to the
+// endpoints and using the http client given in cfg.
+func NewSteamAPIWithConfig(apiKey string, cfg SteamAPIConfig) *SteamAPI {
+	if cfg.WebAPIBaseURL == "" {
+		cfg.WebAPIBaseURL = DefaultWebAPIBaseURL
+	}
+	if cfg.StoreBaseURL == "" {
+		cfg.StoreBaseURL = DefaultStoreBaseURL
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{}
 	}
 
+	return &SteamAPI{
+		apiKey:       apiKey,
+		client:       cfg.HTTPClient,
+		webBaseURL:   strings.TrimRight(cfg.WebAPIBaseURL, "/"),
+		storeBaseURL: strings.TrimRight(cfg.StoreBaseURL, "/"),
+		retry: retryPolicy{
+			maxRetries: MaxRequestRetries,
+			baseDelay:  1 * time.Second,
+			maxDelay:   30 * time.Second,
+		},
+		webLimiter:   newRateLimiter(WebAPIRequestsPerDay, 24*time.Hour, WebAPIBurst),
+		storeLimiter: newRateLimiter(StoreRequestsPer5Min, 5*time.Minute, StoreBurst),
+	}
 }
 
 func (s *SteamAPI) TestAPIKey(ctx context.Context) (err error) {
@@ -131,6 +246,8 @@ type PlayerSummary struct {
 	SteamID       SteamID `json:"steamid"`
 	Visibility    int     `json:"communityvisibilitystate"` // 1: private, 3: public
 	Profilestate  int     `json:"profilestate"`
+	PersonaState  int     `json:"personastate"` // 0: offline, anything else is online
+	LastLogoff    int64   `json:"lastlogoff"`   // Unix timestamp, 0 if hidden
 	Personaname   string  `json:"personaname"`
 	Profileurl    string  `json:"profileurl"`
 	Avatar        string  `json:"avatarfull"`
@@ -158,19 +275,78 @@ func (s *SteamAPI) GetPlayerSummary(ctx context.Context, steamid SteamID) (summa
 	return
 }
 
-// TODO: Steam only allows 100 steamids per request, need to handle this if more than 100
+// GetPlayerSummaries fetches summaries for all given steamids.
+//
+// Steam only accepts PlayerSummariesBatchSize steamids per request, so the ids
+// are split into batches which are requested with at most
+// PlayerSummariesConcurrency requests in flight. If some batches fail, the
+// summaries of the successful batches are still returned together with a
+// *BatchError describing the failed ones.
 func (s *SteamAPI) GetPlayerSummaries(ctx context.Context, steamids []SteamID) (summaries map[SteamID]PlayerSummary, err error) {
 	if len(steamids) == 0 {
 		return nil, fmt.Errorf("steamids cannot be empty")
 	}
 
+	batches := chunkSteamIDs(steamids, PlayerSummariesBatchSize)
+	if len(batches) == 1 {
+		return s.getPlayerSummariesBatch(ctx, batches[0])
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		batchErr BatchError
+	)
+	sem := make(chan struct{}, PlayerSummariesConcurrency)
+	summaries = make(map[SteamID]PlayerSummary, len(steamids))
+
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch []SteamID) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				mu.Lock()
+				batchErr.Failed = append(batchErr.Failed, BatchFailure{SteamIDs: batch, Err: ctx.Err()})
+				mu.Unlock()
+				return
+			}
+			defer func() { <-sem }()
+
+			result, err := s.getPlayerSummariesBatch(ctx, batch)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				batchErr.Failed = append(batchErr.Failed, BatchFailure{SteamIDs: batch, Err: err})
+				return
+			}
+			for id, summary := range result {
+				summaries[id] = summary
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	if len(batchErr.Failed) > 0 {
+		log.Warnf("GetPlayerSummaries: %d of %d batches failed, continuing with %d summaries", len(batchErr.Failed), len(batches), len(summaries))
+		return summaries, &batchErr
+	}
+	return summaries, nil
+}
+
+// getPlayerSummariesBatch requests a single batch of at most
+// PlayerSummariesBatchSize steamids.
+func (s *SteamAPI) getPlayerSummariesBatch(ctx context.Context, steamids []SteamID) (summaries map[SteamID]PlayerSummary, err error) {
 	var steamidsStr string = steamids[0].String()
 	for i := 1; i < len(steamids); i++ {
 		steamidsStr += "," + steamids[i].String()
 	}
-	url := "https://api.steampowered.com/ISteamUser/GetPlayerSummaries/v2/?key=" + s.apiKey + "&steamids=" + steamidsStr
+	url := s.webBaseURL + "/ISteamUser/GetPlayerSummaries/v2/?key=" + s.apiKey + "&steamids=" + steamidsStr
 
-	body, err := s.getRespBody(ctx, url)
+	body, err := s.getRespBody(ctx, s.webLimiter, url)
 	if err != nil {
 		return nil, err
 	}
@@ -198,12 +374,41 @@ func (s *SteamAPI) GetPlayerSummaries(ctx context.Context, steamids []SteamID) (
 	return
 }
 
-// TODO: This API is rate limited, only 200 requests per 5 minutes
-// if we want to update more than 200 games, we need to wait..
-func (s *SteamAPI) GetGameDetails(ctx context.Context, appid AppID) (resp interface{}, err error) {
-	url := "https://store.steampowered.com/api/appdetails?appids=" + appid.String()
-	resp, err = s.getRespBody(ctx, url)
-	return
+// chunkSteamIDs splits ids into consecutive slices of at most size elements.
+func chunkSteamIDs(ids []SteamID, size int) [][]SteamID {
+	chunks := make([][]SteamID, 0, (len(ids)+size-1)/size)
+	for size < len(ids) {
+		ids, chunks = ids[size:], append(chunks, ids[:size:size])
+	}
+	return append(chunks, ids)
+}
+
+// GetGameDetails fetches store details of a game.
+// The store API only allows 200 requests per 5 minutes, requests are held
+// back by the store limiter accordingly.
+// Returns ErrNoGameData if the store has no page for the app.
+func (s *SteamAPI) GetGameDetails(ctx context.Context, appid AppID) (GameData, error) {
+	url := s.storeBaseURL + "/api/appdetails?appids=" + appid.String()
+
+	body, err := s.getRespBody(ctx, s.storeLimiter, url)
+	if err != nil {
+		return GameData{}, err
+	}
+
+	var resp GameDataResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return GameData{}, wrapErr(err)
+	}
+
+	if resp == nil {
+		return GameData{}, ErrEmptyResponse
+	}
+
+	game, ok := resp[appid.String()]
+	if !ok || !game.Success {
+		return GameData{}, ErrNoGameData
+	}
+	return game.Data, nil
 }
 
 type GameInfo struct {
@@ -223,8 +428,13 @@ type OwnedGamesResponse struct {
 }
 
 func (s *SteamAPI) GetOwnedGame(ctx context.Context, steamid SteamID, appid AppID) (GameInfo, error) {
+	return GetOwnedGame(ctx, s, steamid, appid)
+}
+
+// GetOwnedGame fetches a single owned game through any SteamClient
+func GetOwnedGame(ctx context.Context, c SteamClient, steamid SteamID, appid AppID) (GameInfo, error) {
 	game := GameInfo{}
-	games, err := s.GetOwnedGames(ctx, steamid, []AppID{appid})
+	games, err := c.GetOwnedGames(ctx, steamid, []AppID{appid})
 	if err != nil {
 		return game, err
 	}
@@ -265,9 +475,9 @@ func (s *SteamAPI) GetOwnedGames(ctx context.Context, steamid SteamID, appids []
 		return nil, wrapErr(err)
 	}
 
-	url := "https://api.steampowered.com/IPlayerService/GetOwnedGames/v1/?key=" + s.apiKey + "&format=json&input_json=" + url.QueryEscape(newBuf.String())
+	url := s.webBaseURL + "/IPlayerService/GetOwnedGames/v1/?key=" + s.apiKey + "&format=json&input_json=" + url.QueryEscape(newBuf.String())
 
-	body, err := s.getRespBody(ctx, url)
+	body, err := s.getRespBody(ctx, s.webLimiter, url)
 	if err != nil {
 		return nil, err
 	}
@@ -294,6 +504,39 @@ func (s *SteamAPI) GetOwnedGames(ctx context.Context, steamid SteamID, appids []
 	return
 }
 
+type ResolveVanityURLResponse struct {
+	Response *struct {
+		SteamID *SteamID `json:"steamid"`
+		Success int      `json:"success"` // 1: match, 42: no match
+		Message string   `json:"message"`
+	} `json:"response"`
+}
+
+// ResolveVanityURL resolves the custom part of a steamcommunity.com/id/<vanity>
+// link to a SteamID. Returns ErrVanityNotFound if there is no such profile.
+func (s *SteamAPI) ResolveVanityURL(ctx context.Context, vanity string) (SteamID, error) {
+	url := s.webBaseURL + "/ISteamUser/ResolveVanityURL/v1/?key=" + s.apiKey + "&vanityurl=" + url.QueryEscape(vanity)
+
+	body, err := s.getRespBody(ctx, s.webLimiter, url)
+	if err != nil {
+		return 0, err
+	}
+
+	var resp ResolveVanityURLResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, wrapErr(err)
+	}
+
+	if resp.Response == nil {
+		return 0, ErrEmptyResponse
+	}
+
+	if resp.Response.Success != 1 || resp.Response.SteamID == nil {
+		return 0, ErrVanityNotFound
+	}
+	return *resp.Response.SteamID, nil
+}
+
 type RecentGame struct {
 	AppID          AppID  `json:"appid"`
 	Name           string `json:"name"`
@@ -309,9 +552,9 @@ type RecentlyPlayedGamesResponse struct {
 }
 
 func (s *SteamAPI) GetRecentlyPlayedGames(ctx context.Context, steamid SteamID) (map[AppID]RecentGame, error) {
-	url := "https://api.steampowered.com/IPlayerService/GetRecentlyPlayedGames/v1/?key=" + s.apiKey + "&steamid=" + steamid.String()
+	url := s.webBaseURL + "/IPlayerService/GetRecentlyPlayedGames/v1/?key=" + s.apiKey + "&steamid=" + steamid.String()
 
-	body, err := s.getRespBody(ctx, url)
+	body, err := s.getRespBody(ctx, s.webLimiter, url)
 	if err != nil {
 		return nil, err
 	}
@@ -338,44 +581,155 @@ func (s *SteamAPI) GetRecentlyPlayedGames(ctx context.Context, steamid SteamID)
 
 // getRespBody sends a GET request to the given URL and returns the response body
 // It also checks the response status code and ensures it is 200
-func (s *SteamAPI) getRespBody(ctx context.Context, url string) ([]byte, error) {
+//
+// Every attempt first waits on the limiter of the endpoint family. 429 and 5xx
+// responses are retried with exponential backoff and jitter, honoring
+// Retry-After when present. Once retries are exhausted, or Retry-After asks
+// for more than the maximum backoff, ErrRateLimited or
+// ErrUpstreamUnavailable is returned.
+func (s *SteamAPI) getRespBody(ctx context.Context, limiter *rateLimiter, url string) ([]byte, error) {
+	if s.client == nil {
+		log.Warn("HTTP client is nil when it shouldn't, creating new one")
+		s.client = &http.Client{}
+	}
+
+	redactedURL := strings.ReplaceAll(url, s.apiKey, "<API_KEY_REDACTED>")
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				if lastErr != nil {
+					return nil, lastErr
+				}
+				return nil, fmt.Errorf("Error while waiting for rate limiter: %w", err)
+			}
+		}
+
+		body, retryAfter, err := s.doRequest(ctx, url, redactedURL)
+		if err == nil {
+			return body, nil
+		}
+		if err != ErrRateLimited && err != ErrUpstreamUnavailable {
+			return nil, err
+		}
+		lastErr = err
+
+		if attempt >= s.retry.maxRetries {
+			log.Errorf("Giving up on %s after %d attempts: %v", redactedURL, attempt+1, err)
+			return nil, err
+		}
+
+		// A Retry-After beyond maxDelay would stall every caller of the
+		// family, hold it back for maxDelay only and give up
+		if retryAfter > s.retry.maxDelay {
+			if err == ErrRateLimited && limiter != nil {
+				limiter.Pause(time.Now().Add(s.retry.maxDelay))
+			}
+			log.Errorf("Giving up on %s, asked to retry in %v: %v", redactedURL, retryAfter, err)
+			return nil, err
+		}
+
+		delay := s.retry.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if err == ErrRateLimited && limiter != nil {
+			limiter.Pause(time.Now().Add(delay))
+		}
+
+		log.Warnf("Request to %s failed (%v), retrying in %v (attempt %d/%d)", redactedURL, err, delay, attempt+1, s.retry.maxRetries)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, lastErr
+		case <-timer.C:
+		}
+	}
+}
+
+// doRequest performs a single attempt of a GET request.
+// For 429 and 5xx responses, the returned duration is the parsed Retry-After
+// header, or 0 if absent.
+func (s *SteamAPI) doRequest(ctx context.Context, url, redactedURL string) ([]byte, time.Duration, error) {
 	ctx, cancel := context.WithTimeout(ctx, RequestTimeout*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("Error while creating http request: %w", err)
-	}
-
-	if s.client == nil {
-		log.Warn("HTTP client is nil when it shouldn't, creating new one")
-		s.client = &http.Client{}
+		return nil, 0, fmt.Errorf("Error while creating http request: %w", err)
 	}
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Error while sending http request: %w", err)
+		return nil, 0, fmt.Errorf("Error while sending http request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("Error while reading response body of http request: %w", err)
+		return nil, 0, fmt.Errorf("Error while reading response body of http request: %w", err)
 	}
 
 	bodyStr := string(body)
 
 	if resp.StatusCode != http.StatusOK {
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			return nil, parseRetryAfter(resp.Header.Get("Retry-After")), ErrRateLimited
+		case resp.StatusCode >= 500:
+			return nil, parseRetryAfter(resp.Header.Get("Retry-After")), ErrUpstreamUnavailable
+		}
+
 		log.Error("HTTP request failed with status code ", resp.StatusCode)
-		log.Error("Request URI: ", strings.ReplaceAll(url, s.apiKey, "<API_KEY_REDACTED>"))
+		log.Error("Request URI: ", redactedURL)
 		log.Error("Response: ", bodyStr)
 		if resp.StatusCode == 403 {
-			return nil, ErrForbidden
+			return nil, 0, ErrForbidden
 		}
-		return nil, fmt.Errorf("Error Response http status code %d", resp.StatusCode)
+		return nil, 0, fmt.Errorf("Error Response http status code %d", resp.StatusCode)
 	}
 
 	log.Debug("Response Body:\n", bodyStr)
 
-	return body, nil
+	return body, 0, nil
+}
+
+// retryPolicy controls how getRespBody retries 429 and 5xx responses.
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// backoff returns the delay before the retry following the given attempt:
+// exponential in the attempt number, capped at maxDelay, with full jitter.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay << attempt
+	if delay <= 0 || delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date. Returns 0 if the header is absent or invalid.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
 }
@@ -2,16 +2,70 @@ package sptt
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// newFakeSteamServer serves canned responses for the Steam endpoints
+// used by SteamAPI, mimicking the shapes returned by the real API.
+func newFakeSteamServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ISteamUser/GetPlayerSummaries/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "testkey" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var players []string
+		for _, id := range strings.Split(r.URL.Query().Get("steamids"), ",") {
+			players = append(players, `{"steamid":"`+id+`","communityvisibilitystate":3,"personaname":"Test","gameid":"493520"}`)
+		}
+		w.Write([]byte(`{"response":{"players":[` + strings.Join(players, ",") + `]}}`))
+	})
+	mux.HandleFunc("/ISteamUser/ResolveVanityURL/v1/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("vanityurl") != "gabelogannewell" {
+			w.Write([]byte(`{"response":{"success":42,"message":"No match"}}`))
+			return
+		}
+		w.Write([]byte(`{"response":{"steamid":"76561197960287930","success":1}}`))
+	})
+	mux.HandleFunc("/IPlayerService/GetOwnedGames/v1/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"game_count":1,"games":[{"appid":493520,"name":"GTFO","playtime_forever":1234,"playtime_2weeks":60}]}}`))
+	})
+	mux.HandleFunc("/IPlayerService/GetRecentlyPlayedGames/v1/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"response":{"total_count":1,"games":[{"appid":493520,"name":"GTFO","playtime_forever":1234,"playtime_2weeks":60}]}}`))
+	})
+	mux.HandleFunc("/api/appdetails", func(w http.ResponseWriter, r *http.Request) {
+		appid := r.URL.Query().Get("appids")
+		if appid == "1" {
+			w.Write([]byte(`{"1":{"success":false}}`))
+			return
+		}
+		w.Write([]byte(`{"` + appid + `":{"success":true,"data":{"name":"GTFO","steam_appid":` + appid + `,"developers":["10 Chambers"],"recommendations":{"total":42}}}}`))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestSteamAPI(t *testing.T) *SteamAPI {
+	srv := newFakeSteamServer(t)
+	return NewSteamAPIWithConfig("testkey", SteamAPIConfig{
+		WebAPIBaseURL: srv.URL,
+		StoreBaseURL:  srv.URL,
+		HTTPClient:    srv.Client(),
+	})
+}
+
 func TestSteamAPI(t *testing.T) {
 	ctx := context.Background()
-	env, err := GetEnv("../.env")
-	if err != nil {
-		t.Errorf("Expected nil, got %v", err)
-	}
-	api := NewSteamAPI(env["STEAM_API_KEY"])
+	api := newTestSteamAPI(t)
 	ids := []SteamID{76561198854733565}
 
 	t.Run("GetPlayerSummaries", func(t *testing.T) {
@@ -27,6 +81,28 @@ func TestSteamAPI(t *testing.T) {
 		}
 	})
 
+	t.Run("GetPlayerSummaries Batched", func(t *testing.T) {
+		many := make([]SteamID, 2*PlayerSummariesBatchSize+1)
+		for i := range many {
+			many[i] = SteamID(76561198000000000 + uint64(i))
+		}
+		summaries, err := api.GetPlayerSummaries(ctx, many)
+		if err != nil {
+			t.Errorf("Expected nil, got %v", err)
+			return
+		}
+		if len(summaries) != len(many) {
+			t.Errorf("Expected %d summaries, got %d", len(many), len(summaries))
+		}
+	})
+
+	t.Run("GetPlayerSummaries Forbidden", func(t *testing.T) {
+		bad := NewSteamAPIWithConfig("badkey", SteamAPIConfig{WebAPIBaseURL: api.webBaseURL})
+		if err := bad.TestAPIKey(ctx); err != ErrForbidden {
+			t.Errorf("Expected ErrForbidden, got %v", err)
+		}
+	})
+
 	t.Run("GetOwnedGames", func(t *testing.T) {
 		games, err := api.GetOwnedGames(ctx, ids[0], []AppID{493520})
 		if err != nil {
@@ -42,26 +118,151 @@ func TestSteamAPI(t *testing.T) {
 
 	t.Run("GetRecentlyPlayedGames", func(t *testing.T) {
 		games, err := api.GetRecentlyPlayedGames(ctx, ids[0])
-		if err != nil && err != ErrEmptyGames {
-			t.Errorf("Expected nil or ErrEmptyGames, got %v", err)
-			return
-
-		}
-
-		if err == ErrEmptyGames {
-			t.Log("No recently played games for this account, skipping entry checks")
-
+		if err != nil {
+			t.Errorf("Expected nil, got %v", err)
 			return
 		}
 
 		for _, game := range games {
-			t.Logf("%v (%v) => %v", game.Name, game.AppID, game.Playtime)
 			if game.AppID == 0 {
 				t.Errorf("Expected valid AppID, got 0")
 			}
-			if game.Name == "" {
-				t.Logf("Empty Name for AppID %v", game.AppID)
+			if game.Playtime != 1234 {
+				t.Errorf("Expected 1234, got %d", game.Playtime)
 			}
 		}
 	})
+
+	t.Run("GetGameDetails", func(t *testing.T) {
+		game, err := api.GetGameDetails(ctx, 493520)
+		if err != nil {
+			t.Errorf("Expected nil, got %v", err)
+			return
+		}
+
+		cached := GameCacheFromData(game)
+		if cached.AppID != 493520 || cached.Name != "GTFO" || cached.Developer != "10 Chambers" || cached.Recommendations != 42 {
+			t.Errorf("Expected game to be valid, got %+v", cached)
+		}
+	})
+
+	t.Run("GetGameDetails No Data", func(t *testing.T) {
+		if _, err := api.GetGameDetails(ctx, 1); err != ErrNoGameData {
+			t.Errorf("Expected ErrNoGameData, got %v", err)
+		}
+	})
+}
+
+func TestChunkSteamIDs(t *testing.T) {
+	ids := make([]SteamID, 250)
+	for i := range ids {
+		ids[i] = SteamID(76561198000000000 + uint64(i))
+	}
+
+	tests := []struct {
+		name  string
+		n     int
+		sizes []int
+	}{
+		{"Single", 1, []int{1}},
+		{"Exact", 100, []int{100}},
+		{"Overflow", 101, []int{100, 1}},
+		{"Multiple", 250, []int{100, 100, 50}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkSteamIDs(ids[:tt.n], PlayerSummariesBatchSize)
+			if len(chunks) != len(tt.sizes) {
+				t.Fatalf("Expected %d chunks, got %d", len(tt.sizes), len(chunks))
+			}
+			next := 0
+			for i, chunk := range chunks {
+				if len(chunk) != tt.sizes[i] {
+					t.Errorf("Expected chunk %d to have %d ids, got %d", i, tt.sizes[i], len(chunk))
+				}
+				for _, id := range chunk {
+					if id != ids[next] {
+						t.Errorf("Expected %d, got %d", ids[next], id)
+					}
+					next++
+				}
+			}
+		})
+	}
+}
+
+func TestGetRespBodyRetry(t *testing.T) {
+	ctx := context.Background()
+
+	newTestAPI := func() *SteamAPI {
+		api := NewSteamAPI("testkey")
+		api.retry.baseDelay = time.Millisecond
+		api.retry.maxDelay = 5 * time.Millisecond
+		return api
+	}
+
+	tests := []struct {
+		name       string
+		statuses   []int
+		retryAfter string
+		wantErr    error
+		wantHits   int
+	}{
+		{"OK", []int{200}, "0", nil, 1},
+		{"Recovers from 503", []int{503, 502, 200}, "0", nil, 3},
+		{"Recovers from 429", []int{429, 200}, "0", nil, 2},
+		{"Exhausted 429", []int{429, 429, 429, 429}, "0", ErrRateLimited, MaxRequestRetries + 1},
+		{"Exhausted 5xx", []int{500, 503, 500, 504}, "0", ErrUpstreamUnavailable, MaxRequestRetries + 1},
+		{"No retry on 403", []int{403}, "0", ErrForbidden, 1},
+		{"Retry-After beyond max delay", []int{429, 200}, "3600", ErrRateLimited, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&hits, 1) - 1
+				status := tt.statuses[min(int(i), len(tt.statuses)-1)]
+				if status == http.StatusTooManyRequests {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+				w.Write([]byte("{}"))
+			}))
+			defer srv.Close()
+
+			api := newTestAPI()
+			_, err := api.getRespBody(ctx, api.webLimiter, srv.URL)
+			if err != tt.wantErr {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if got := int(atomic.LoadInt32(&hits)); got != tt.wantHits {
+				t.Errorf("Expected %d requests, got %d", tt.wantHits, got)
+			}
+
+			// The limiter is never held back longer than the max delay
+			waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			if err := api.webLimiter.Wait(waitCtx); err != nil {
+				t.Errorf("Expected nil, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 120*time.Second {
+		t.Errorf("Expected 2m0s, got %v", got)
+	}
+	if got := parseRetryAfter(""); got != 0 {
+		t.Errorf("Expected 0, got %v", got)
+	}
+	if got := parseRetryAfter("garbage"); got != 0 {
+		t.Errorf("Expected 0, got %v", got)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("Expected ~1h, got %v", got)
+	}
 }
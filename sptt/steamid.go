@@ -0,0 +1,125 @@
+package sptt
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Errors associated with parsing steamids
+const (
+	ErrInvalidSteamID = APIError("invalid steamid")
+	ErrVanityNotFound = APIError("vanity url could not be resolved")
+)
+
+// steamID64Base is the 64-bit id of the first individual account,
+// account ids of STEAM_X:Y:Z and [U:1:N] ids are offsets from it.
+const steamID64Base uint64 = 76561197960265728
+
+var (
+	steam2Pattern = regexp.MustCompile(`^STEAM_[0-5]:([01]):(\d+)$`)
+	steam3Pattern = regexp.MustCompile(`^(?:\[U:1:(\d+)\]|U:1:(\d+))$`)
+	vanityPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{2,32}$`)
+)
+
+// VanityResolver resolves the custom part of a
+// steamcommunity.com/id/<vanity> link to a SteamID.
+type VanityResolver interface {
+	ResolveVanityURL(ctx context.Context, vanity string) (SteamID, error)
+}
+
+// ParseSteamIDInput parses user input identifying a Steam account.
+// Accepted formats are:
+//
+//	76561197960287930
+//	STEAM_0:0:11101
+//	[U:1:22202]
+//	https://steamcommunity.com/profiles/76561197960287930
+//	https://steamcommunity.com/id/<vanity>
+//
+// For /id/<vanity> links, the vanity name is returned instead of an id
+// and must be resolved with a VanityResolver.
+func ParseSteamIDInput(raw string) (id SteamID, vanity string, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, "", ErrInvalidSteamID
+	}
+
+	if v, err := strconv.ParseUint(raw, 10, 64); err == nil {
+		if v == 0 {
+			return 0, "", ErrInvalidSteamID
+		}
+		return SteamID(v), "", nil
+	}
+
+	if m := steam2Pattern.FindStringSubmatch(strings.ToUpper(raw)); m != nil {
+		y, _ := strconv.ParseUint(m[1], 10, 64)
+		z, err := strconv.ParseUint(m[2], 10, 32)
+		if err != nil {
+			return 0, "", ErrInvalidSteamID
+		}
+		return SteamID(steamID64Base + z*2 + y), "", nil
+	}
+
+	if m := steam3Pattern.FindStringSubmatch(strings.ToUpper(raw)); m != nil {
+		n, err := strconv.ParseUint(m[1]+m[2], 10, 32)
+		if err != nil {
+			return 0, "", ErrInvalidSteamID
+		}
+		return SteamID(steamID64Base + n), "", nil
+	}
+
+	return parseProfileURL(raw)
+}
+
+// parseProfileURL parses steamcommunity.com/profiles/<id> and
+// steamcommunity.com/id/<vanity> links, with or without scheme.
+func parseProfileURL(raw string) (SteamID, string, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0, "", ErrInvalidSteamID
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if host != "steamcommunity.com" {
+		return 0, "", ErrInvalidSteamID
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 {
+		return 0, "", ErrInvalidSteamID
+	}
+
+	switch parts[0] {
+	case "profiles":
+		v, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil || v == 0 {
+			return 0, "", ErrInvalidSteamID
+		}
+		return SteamID(v), "", nil
+	case "id":
+		if !vanityPattern.MatchString(parts[1]) {
+			return 0, "", ErrInvalidSteamID
+		}
+		return 0, parts[1], nil
+	}
+	return 0, "", ErrInvalidSteamID
+}
+
+// ResolveSteamID parses raw like ParseSteamIDInput and resolves vanity
+// links through r.
+func ResolveSteamID(ctx context.Context, r VanityResolver, raw string) (SteamID, error) {
+	id, vanity, err := ParseSteamIDInput(raw)
+	if err != nil {
+		return 0, err
+	}
+	if vanity == "" {
+		return id, nil
+	}
+	return r.ResolveVanityURL(ctx, vanity)
+}
@@ -0,0 +1,77 @@
+package sptt
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseSteamIDInput(t *testing.T) {
+	const gaben = SteamID(76561197960287930)
+
+	tests := []struct {
+		name       string
+		raw        string
+		wantID     SteamID
+		wantVanity string
+		wantErr    error
+	}{
+		{"SteamID64", "76561197960287930", gaben, "", nil},
+		{"SteamID64 Whitespace", "  76561197960287930\n", gaben, "", nil},
+		{"Steam2", "STEAM_0:0:11101", gaben, "", nil},
+		{"Steam2 Universe 1", "STEAM_1:0:11101", gaben, "", nil},
+		{"Steam2 Odd", "STEAM_0:1:11101", gaben + 1, "", nil},
+		{"Steam3", "[U:1:22202]", gaben, "", nil},
+		{"Steam3 No Brackets", "U:1:22202", gaben, "", nil},
+		{"Profile URL", "https://steamcommunity.com/profiles/76561197960287930/", gaben, "", nil},
+		{"Profile URL No Scheme", "steamcommunity.com/profiles/76561197960287930", gaben, "", nil},
+		{"Profile URL www", "http://www.steamcommunity.com/profiles/76561197960287930/home", gaben, "", nil},
+		{"Vanity URL", "https://steamcommunity.com/id/gabelogannewell/", 0, "gabelogannewell", nil},
+		{"Vanity URL No Scheme", "steamcommunity.com/id/gabelogannewell", 0, "gabelogannewell", nil},
+		{"Empty", "", 0, "", ErrInvalidSteamID},
+		{"Zero", "0", 0, "", ErrInvalidSteamID},
+		{"Garbage", "hello world", 0, "", ErrInvalidSteamID},
+		{"Other Host", "https://example.com/profiles/76561197960287930", 0, "", ErrInvalidSteamID},
+		{"Bad Profile", "https://steamcommunity.com/profiles/abc", 0, "", ErrInvalidSteamID},
+		{"Bad Steam2", "STEAM_0:2:11101", 0, "", ErrInvalidSteamID},
+		{"Steam3 Open Bracket", "[U:1:22202", 0, "", ErrInvalidSteamID},
+		{"Steam3 Close Bracket", "U:1:22202]", 0, "", ErrInvalidSteamID},
+		{"Missing Vanity", "https://steamcommunity.com/id/", 0, "", ErrInvalidSteamID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, vanity, err := ParseSteamIDInput(tt.raw)
+			if err != tt.wantErr {
+				t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+			}
+			if id != tt.wantID {
+				t.Errorf("Expected %d, got %d", tt.wantID, id)
+			}
+			if vanity != tt.wantVanity {
+				t.Errorf("Expected %q, got %q", tt.wantVanity, vanity)
+			}
+		})
+	}
+}
+
+func TestResolveSteamID(t *testing.T) {
+	ctx := context.Background()
+	api := newTestSteamAPI(t)
+
+	id, err := ResolveSteamID(ctx, api, "https://steamcommunity.com/id/gabelogannewell")
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if id != 76561197960287930 {
+		t.Errorf("Expected 76561197960287930, got %d", id)
+	}
+
+	if _, err := ResolveSteamID(ctx, api, "steamcommunity.com/id/nobody"); err != ErrVanityNotFound {
+		t.Errorf("Expected ErrVanityNotFound, got %v", err)
+	}
+
+	id, err = ResolveSteamID(ctx, api, "[U:1:22202]")
+	if err != nil || id != 76561197960287930 {
+		t.Errorf("Expected 76561197960287930, got %d (%v)", id, err)
+	}
+}
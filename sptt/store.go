@@ -0,0 +1,102 @@
+package sptt
+
+import (
+	"context"
+	"time"
+)
+
+// Store is the persistence layer used by the monitor, the API and the
+// command line tools. DB implements it for every Driver.
+type Store interface {
+	Ping(ctx context.Context) error
+	Close() error
+
+	// Sessions
+	GetSessions(ctx context.Context, id SteamID, q SessionQuery) ([]Session, error)
+	GetSessionCount(ctx context.Context, id SteamID, f SessionFilter) (int64, error)
+	AddSession(ctx context.Context, session Session) error
+	ConcludeSession(ctx context.Context, session Session) error
+	GetUserStats(ctx context.Context, id SteamID, f SessionFilter, loc *time.Location, now time.Time) (UserStats, error)
+	GetPlaytimeSeries(ctx context.Context, id SteamID, q SeriesQuery) ([]SeriesPoint, error)
+	GetLeaderboard(ctx context.Context, q LeaderboardQuery) ([]LeaderboardEntry, int64, error)
+	GetCoPlaySessions(ctx context.Context, q CoPlayQuery) ([]CoPlaySession, int64, error)
+	GetCoPlayPartners(ctx context.Context, id SteamID, q CoPlayQuery) ([]CoPlayPartner, time.Duration, error)
+
+	// Active sessions
+	GetActiveSessions(ctx context.Context, id SteamID) (map[AppID]ActiveSession, error)
+	AddActiveSession(ctx context.Context, session ActiveSession) error
+	AddActiveSessions(ctx context.Context, sessions []ActiveSession) error
+	RemoveActiveSession(ctx context.Context, steamid SteamID, appid AppID) error
+	RemoveActiveSessions(ctx context.Context, steamid SteamID) error
+	GetAllActiveSessions(ctx context.Context) ([]ActiveSession, error)
+	TouchActiveSession(ctx context.Context, steamid SteamID, appid AppID, t time.Time) error
+
+	// Users
+	GetSteamIDs(ctx context.Context) ([]SteamID, error)
+	GetActiveSteamIDs(ctx context.Context) ([]SteamID, error)
+	AddSteamID(ctx context.Context, id SteamID, uname string) error
+	RemoveSteamID(ctx context.Context, steamid []SteamID) error
+	GetUsers(ctx context.Context, limit, offset int) ([]User, int64, error)
+	GetUser(ctx context.Context, id SteamID) (User, error)
+	AddUser(ctx context.Context, id SteamID, username string, active, public bool) error
+	RemoveUser(ctx context.Context, id SteamID) error
+	SetUserActive(ctx context.Context, id SteamID, active bool) error
+	ModifyUser(ctx context.Context, id SteamID, p ModifyUserParams) error
+
+	// Auth tokens
+	GetAuthToken(name string) (AuthToken, error)
+	ListAuthTokensBelowClearance(ctx context.Context, clearanceLimit int) ([]AuthTokenInfo, error)
+	CreateAuthToken(ctx context.Context, name, salt, secret string, clearance int) error
+	DeleteAuthToken(ctx context.Context, name string) error
+
+	// Read tokens
+	GetReadToken(ctx context.Context, id SteamID) (ReadToken, error)
+	SetReadToken(ctx context.Context, id SteamID, salt, secret string) error
+	DeleteReadToken(ctx context.Context, id SteamID) error
+
+	// Metadata
+	GetMetadata(ctx context.Context, key string) (string, error)
+	SetMetadata(ctx context.Context, key, data string) error
+	RequestPoll(ctx context.Context, ids []SteamID) error
+	TakePollRequest(ctx context.Context) (*PollRequest, error)
+
+	// Game catalog
+	GetGameCache(ctx context.Context, appid AppID) (*GameCache, error)
+	AddGameCache(ctx context.Context, game GameCache) error
+	GetUncachedAppIDs(ctx context.Context, limit int) ([]AppID, error)
+
+	// Downtime
+	AddDowntime(ctx context.Context, dt Downtime) error
+	GetDowntimes(ctx context.Context, from, to time.Time) ([]Downtime, error)
+
+	// Webhooks
+	AddWebhook(ctx context.Context, w Webhook) (int64, error)
+	GetWebhooks(ctx context.Context) ([]Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (Webhook, error)
+	RemoveWebhook(ctx context.Context, id int64) error
+	AddWebhookDelivery(ctx context.Context, w WebhookDelivery) (int64, error)
+	UpdateWebhookDelivery(ctx context.Context, w WebhookDelivery) error
+	GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	GetWebhookDeliveries(ctx context.Context, webhookID int64, limit, offset int) ([]WebhookDelivery, error)
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+
+	// Leader election
+	TryLeaderLock(ctx context.Context) (*LeaderLock, error)
+	ClearLeader(ctx context.Context, instance string) error
+
+	// Notifications
+	Notify(ctx context.Context, channel, payload string) error
+	Listen(ctx context.Context, channel string) (<-chan string, error)
+}
+
+var _ Store = (*DB)(nil)
+
+// NewStore connects to the backend selected by cfg.Driver and migrates it
+// to the latest schema.
+func NewStore(cfg DBConfig) (Store, error) {
+	db, err := NewDBWithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
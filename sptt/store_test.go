@@ -0,0 +1,871 @@
+package sptt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testStore is the conformance suite every Store backend has to pass.
+// It only touches rows it creates and removes them again, so it can run
+// against a database that is in use.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	const (
+		alice = SteamID(76561197960000001)
+		bob   = SteamID(76561197960000002)
+		gtfo  = AppID(493520)
+		hl2   = AppID(220)
+	)
+	start := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	downtimeStart := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Cleanup(func() {
+		s.RemoveActiveSessions(ctx, alice)
+		s.RemoveUser(ctx, alice)
+		s.RemoveUser(ctx, bob)
+		s.DeleteAuthToken(ctx, "conformance")
+		// Concluded sessions and catalog entries can't be removed through the Store
+		if db, ok := s.(*DB); ok {
+			db.db.ExecContext(ctx, "DELETE FROM sessions WHERE steamid = $1 OR steamid = $2", alice, bob)
+			db.db.ExecContext(ctx, "DELETE FROM games WHERE appid = $1 OR appid = $2", gtfo, hl2)
+			db.db.ExecContext(ctx, "DELETE FROM metadata WHERE key = $1", "conformance")
+			db.db.ExecContext(ctx, "DELETE FROM downtime WHERE utcstart = $1", downtimeStart)
+		}
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		if err := s.Ping(ctx); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		if err := s.AddUser(ctx, alice, "conformance_alice", true, true); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if err := s.AddUser(ctx, alice, "conformance_alice2", true, true); !errors.Is(err, ErrDuplicateSteamID) {
+			t.Errorf("Expected ErrDuplicateSteamID, got %v", err)
+		}
+		if err := s.AddUser(ctx, bob, "conformance_bob", false, true); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+
+		ids, err := s.GetActiveSteamIDs(ctx)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if !containsID(ids, alice) || containsID(ids, bob) {
+			t.Errorf("Expected only alice active, got %v", ids)
+		}
+
+		name := "conformance_bobby"
+		notify := true
+		tz := "Europe/Berlin"
+		if err := s.ModifyUser(ctx, bob, ModifyUserParams{Username: &name, DiscordNotify: &notify, TimeZone: &tz}); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		if err := s.SetUserActive(ctx, bob, true); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		if err := s.SetUserActive(ctx, SteamID(1), true); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+
+		users, total, err := s.GetUsers(ctx, 1000, 0)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if total < 2 {
+			t.Errorf("Expected at least 2 users, got %d", total)
+		}
+		found := false
+		for _, u := range users {
+			if u.SteamID == bob {
+				found = true
+				if u.Username != name || !u.Active || !u.Public {
+					t.Errorf("Expected %s active public, got %+v", name, u)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Expected bob in users")
+		}
+		if u, err := s.GetUser(ctx, bob); err != nil || u.Username != name || !u.DiscordNotify || u.TimeZone != tz {
+			t.Errorf("Expected %s in %s with Discord notifications, got %+v (%v)", name, tz, u, err)
+		}
+		if u, _ := s.GetUser(ctx, alice); u.TimeZone != "UTC" {
+			t.Errorf("Expected UTC by default, got %q", u.TimeZone)
+		}
+		if _, err := s.GetUser(ctx, SteamID(1)); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+
+		if err := s.RemoveUser(ctx, bob); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		if err := s.RemoveUser(ctx, bob); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Active sessions", func(t *testing.T) {
+		err := s.AddActiveSessions(ctx, []ActiveSession{
+			{SteamID: alice, UTCStart: start, PlaytimeForever: 100, AppID: gtfo},
+			{SteamID: alice, UTCStart: start, PlaytimeForever: -1, AppID: hl2},
+		})
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+
+		sessions, err := s.GetActiveSessions(ctx, alice)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(sessions) != 2 {
+			t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+		}
+		if got := sessions[gtfo]; got.PlaytimeForever != 100 || !got.UTCStart.Equal(start) {
+			t.Errorf("Expected playtime 100 at %v, got %+v", start, got)
+		}
+		if got := sessions[gtfo]; !got.LastSeen.Equal(start) {
+			t.Errorf("Expected last seen at start %v, got %v", start, got.LastSeen)
+		}
+
+		seen := start.Add(10 * time.Minute)
+		if err := s.TouchActiveSession(ctx, alice, gtfo, seen); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		all, err := s.GetAllActiveSessions(ctx)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		touched := false
+		for _, sess := range all {
+			if sess.SteamID == alice && sess.AppID == gtfo {
+				touched = sess.LastSeen.Equal(seen)
+			}
+		}
+		if !touched {
+			t.Errorf("Expected last seen %v in %+v", seen, all)
+		}
+
+		if err := s.RemoveActiveSession(ctx, alice, hl2); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		if err := s.RemoveActiveSessions(ctx, alice); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		sessions, _ = s.GetActiveSessions(ctx, alice)
+		if len(sessions) != 0 {
+			t.Errorf("Expected 0 sessions, got %d", len(sessions))
+		}
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			begin := start.Add(time.Duration(i) * time.Hour)
+			appid := gtfo
+			if i == 1 {
+				appid = hl2
+			}
+			err := s.AddSession(ctx, Session{
+				SteamID:         alice,
+				UTCStart:        begin,
+				UTCEnd:          begin.Add(30 * time.Minute),
+				PlaytimeForever: int32(100 + i*30),
+				AppID:           appid,
+				PlaytimeSource:  PlaytimeSourceOwnedGames,
+			})
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+		}
+
+		sessions, err := s.GetSessions(ctx, alice, SessionQuery{PageSize: 10, SortBy: SortByUTCStart, SortDir: SortDirDesc})
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(sessions) != 3 {
+			t.Fatalf("Expected 3 sessions, got %d", len(sessions))
+		}
+		if !sessions[0].UTCStart.Equal(start.Add(2 * time.Hour)) {
+			t.Errorf("Expected latest session first, got %v", sessions[0].UTCStart)
+		}
+		if sessions[0].PlaytimeSource != PlaytimeSourceOwnedGames {
+			t.Errorf("Expected %s, got %s", PlaytimeSourceOwnedGames, sessions[0].PlaytimeSource)
+		}
+
+		appid := gtfo
+		from := start.Add(30 * time.Minute)
+		filter := SessionFilter{AppID: &appid, UTCStartFrom: &from}
+		count, err := s.GetSessionCount(ctx, alice, filter)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if count != 1 {
+			t.Errorf("Expected 1, got %d", count)
+		}
+
+		page, err := s.GetSessions(ctx, alice, SessionQuery{Page: 1, PageSize: 2, SortBy: SortByUTCStart})
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(page) != 1 {
+			t.Errorf("Expected 1 session on second page, got %d", len(page))
+		}
+
+		active := ActiveSession{SteamID: alice, UTCStart: start.Add(5 * time.Hour), PlaytimeForever: 190, AppID: gtfo}
+		if err := s.AddActiveSession(ctx, active); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		concluded := Session{
+			SteamID:         alice,
+			UTCStart:        active.UTCStart,
+			UTCEnd:          active.UTCStart.Add(time.Hour),
+			PlaytimeForever: 250,
+			AppID:           gtfo,
+			PlaytimeSource:  PlaytimeSourceRecentlyPlayed,
+			Recovered:       true,
+		}
+		// The second run must not insert or fail
+		for i := 0; i < 2; i++ {
+			if err := s.ConcludeSession(ctx, concluded); err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+		}
+		if count, _ := s.GetSessionCount(ctx, alice, SessionFilter{}); count != 4 {
+			t.Errorf("Expected 4, got %d", count)
+		}
+		if sessions, _ := s.GetActiveSessions(ctx, alice); len(sessions) != 0 {
+			t.Errorf("Expected 0 active sessions, got %d", len(sessions))
+		}
+		latest, _ := s.GetSessions(ctx, alice, SessionQuery{PageSize: 1, SortBy: SortByUTCStart, SortDir: SortDirDesc})
+		if len(latest) != 1 || !latest[0].Recovered || sessions[0].Recovered {
+			t.Errorf("Expected only the concluded session to be recovered, got %+v", latest)
+		}
+
+		appids, err := s.GetUncachedAppIDs(ctx, 100)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if !containsAppID(appids, gtfo) || !containsAppID(appids, hl2) {
+			t.Errorf("Expected %d and %d uncached, got %v", gtfo, hl2, appids)
+		}
+	})
+
+	t.Run("Game catalog", func(t *testing.T) {
+		if _, err := s.GetGameCache(ctx, AppID(1)); !errors.Is(err, ErrGameNotFound) {
+			t.Errorf("Expected ErrGameNotFound, got %v", err)
+		}
+
+		game := GameCache{AppID: gtfo, Name: "GTFO", Publisher: "10 Chambers", Developer: "10 Chambers", Recommendations: 10, Available: true}
+		if err := s.AddGameCache(ctx, game); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		game.Recommendations = 20
+		if err := s.AddGameCache(ctx, game); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+
+		got, err := s.GetGameCache(ctx, gtfo)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if got.Name != "GTFO" || got.Recommendations != 20 || !got.Available || got.FetchedAt.IsZero() || got.RetryAfter != nil {
+			t.Errorf("Expected updated GTFO entry, got %+v", got)
+		}
+
+		uncached := func() []AppID {
+			t.Helper()
+			appids, err := s.GetUncachedAppIDs(ctx, 100000)
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			return appids
+		}
+		if appids := uncached(); containsAppID(appids, gtfo) || !containsAppID(appids, hl2) {
+			t.Errorf("Expected HL2 to be fetched but not GTFO, got %v", appids)
+		}
+
+		// Failed fetches wait until they are due
+		retryAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+		if err := s.AddGameCache(ctx, GameCache{AppID: hl2, RetryAfter: &retryAfter}); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if got, err := s.GetGameCache(ctx, hl2); err != nil || got.Available || got.RetryAfter == nil || !got.RetryAfter.Equal(retryAfter) {
+			t.Errorf("Expected HL2 retried at %v, got %+v (%v)", retryAfter, got, err)
+		}
+		if appids := uncached(); containsAppID(appids, hl2) {
+			t.Errorf("Expected HL2 not to be fetched before %v, got %v", retryAfter, appids)
+		}
+		retryAfter = time.Now().Add(-time.Minute)
+		if err := s.AddGameCache(ctx, GameCache{AppID: hl2, RetryAfter: &retryAfter}); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if appids := uncached(); !containsAppID(appids, hl2) {
+			t.Errorf("Expected HL2 to be due, got %v", appids)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		// Sessions so far: 30m of GTFO at 12:00, HL2 at 13:00, GTFO at
+		// 14:00, 1h of GTFO at 17:00. Add 75m of HL2 across midnight.
+		midnight := start.Add(12 * time.Hour)
+		err := s.AddSession(ctx, Session{SteamID: alice, UTCStart: midnight.Add(-30 * time.Minute), UTCEnd: midnight.Add(45 * time.Minute), AppID: hl2})
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+
+		stats, err := s.GetUserStats(ctx, alice, SessionFilter{}, time.UTC, midnight.Add(24*time.Hour))
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if stats.Sessions != 5 || stats.Total != 225*time.Minute || stats.Average != 45*time.Minute {
+			t.Errorf("Expected 5 sessions over 225m, got %d over %v", stats.Sessions, stats.Total)
+		}
+		if stats.Longest == nil || stats.Longest.AppID != hl2 || stats.Longest.UTCEnd.Sub(stats.Longest.UTCStart) != 75*time.Minute {
+			t.Errorf("Expected the HL2 session to be the longest, got %+v", stats.Longest)
+		}
+		if len(stats.Games) != 2 || stats.Games[0].Name != "GTFO" || stats.Games[0].Playtime != 2*time.Hour || stats.Games[1].Sessions != 2 {
+			t.Errorf("Expected GTFO ranked before HL2, got %+v", stats.Games)
+		}
+		if stats.Weekdays[time.Wednesday] != 3*time.Hour || stats.Weekdays[time.Thursday] != 45*time.Minute {
+			t.Errorf("Expected 3h on Wednesday and 45m on Thursday, got %v", stats.Weekdays)
+		}
+		if stats.Hours[17] != time.Hour || stats.Hours[23] != 30*time.Minute || stats.Hours[0] != 45*time.Minute {
+			t.Errorf("Expected playtime split by hour, got %v", stats.Hours)
+		}
+		if !stats.FirstSeen.Equal(start) || !stats.LastSeen.Equal(midnight.Add(45*time.Minute)) {
+			t.Errorf("Expected first and last seen, got %v and %v", stats.FirstSeen, stats.LastSeen)
+		}
+		if stats.CurrentStreak != 2 || stats.LongestStreak != 2 {
+			t.Errorf("Expected streaks of 2 days, got %d and %d", stats.CurrentStreak, stats.LongestStreak)
+		}
+
+		// Sessions straddling the range only count with the part inside it
+		to := start.Add(75 * time.Minute)
+		stats, err = s.GetUserStats(ctx, alice, SessionFilter{UTCEndTo: &to}, time.UTC, midnight.Add(240*time.Hour))
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if stats.Sessions != 2 || stats.Total != 45*time.Minute || !stats.LastSeen.Equal(to) {
+			t.Errorf("Expected 2 sessions over 45m, got %d over %v until %v", stats.Sessions, stats.Total, stats.LastSeen)
+		}
+		if stats.CurrentStreak != 1 {
+			t.Errorf("Expected the streak to be current at the end of the range, got %d", stats.CurrentStreak)
+		}
+
+		appid := hl2
+		stats, err = s.GetUserStats(ctx, alice, SessionFilter{AppID: &appid, UTCStartFrom: &midnight}, time.UTC, midnight.Add(240*time.Hour))
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if stats.Sessions != 1 || stats.Total != 45*time.Minute || stats.Hours[23] != 0 || stats.CurrentStreak != 0 {
+			t.Errorf("Expected 45m after midnight, got %+v", stats)
+		}
+
+		// At +09:45 the GTFO session at 14:00 UTC crosses midnight
+		zone := time.FixedZone("", 9*60*60+45*60)
+		stats, err = s.GetUserStats(ctx, alice, SessionFilter{}, zone, midnight.Add(24*time.Hour))
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if stats.Weekdays[time.Wednesday] != 75*time.Minute || stats.Hours[22] != 30*time.Minute || stats.Hours[23] != 30*time.Minute || stats.Hours[9] != 45*time.Minute {
+			t.Errorf("Expected playtime in wall clock time, got %v and %v", stats.Weekdays, stats.Hours)
+		}
+		if stats.Total != 225*time.Minute || stats.CurrentStreak != 2 {
+			t.Errorf("Expected the same total and streak, got %v and %d", stats.Total, stats.CurrentStreak)
+		}
+
+		stats, err = s.GetUserStats(ctx, bob, SessionFilter{}, time.UTC, midnight)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if stats.Sessions != 0 || stats.Longest != nil || !stats.FirstSeen.IsZero() {
+			t.Errorf("Expected empty stats, got %+v", stats)
+		}
+	})
+
+	t.Run("Playtime series", func(t *testing.T) {
+		// At +09:45 the GTFO session at 14:00 UTC crosses midnight
+		zone := time.FixedZone("", 9*60*60+45*60)
+		q := SeriesQuery{Bucket: BucketDay, From: start, To: start.Add(48 * time.Hour), Location: zone, ByApp: true}
+		points, err := s.GetPlaytimeSeries(ctx, alice, q)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(points) != 3 || !points[0].Start.Equal(time.Date(2025, time.January, 1, 0, 0, 0, 0, zone)) {
+			t.Fatalf("Expected 3 days from January 1st, got %+v", points)
+		}
+		if points[0].Playtime != 75*time.Minute || points[1].Playtime != 150*time.Minute || points[2].Playtime != 0 {
+			t.Errorf("Expected 75m, 150m and 0m, got %v, %v and %v", points[0].Playtime, points[1].Playtime, points[2].Playtime)
+		}
+		if len(points[0].Apps) != 2 || points[0].Apps[0] != (AppPlaytime{gtfo, 45 * time.Minute}) {
+			t.Errorf("Expected 45m of GTFO first, got %+v", points[0].Apps)
+		}
+
+		appid := hl2
+		q = SeriesQuery{Bucket: BucketWeek, From: start, To: start.Add(time.Hour), AppID: &appid}
+		points, err = s.GetPlaytimeSeries(ctx, alice, q)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(points) != 1 || points[0].Start.Weekday() != time.Monday || points[0].Playtime != 105*time.Minute || points[0].Apps != nil {
+			t.Errorf("Expected one week with 105m of HL2, got %+v", points)
+		}
+
+		q = SeriesQuery{Bucket: BucketDay, From: start, To: start.AddDate(10, 0, 0)}
+		if _, err := s.GetPlaytimeSeries(ctx, alice, q); !errors.Is(err, ErrTooManyBuckets) {
+			t.Errorf("Expected ErrTooManyBuckets, got %v", err)
+		}
+	})
+
+	t.Run("Leaderboard", func(t *testing.T) {
+		// Other users may be on it, only alice's entry is checked
+		find := func(q LeaderboardQuery) *LeaderboardEntry {
+			t.Helper()
+			q.PageSize = 1000
+			entries, total, err := s.GetLeaderboard(ctx, q)
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			if total < int64(len(entries)) {
+				t.Errorf("Expected at least %d users, got %d", len(entries), total)
+			}
+			for i, e := range entries {
+				if i > 0 && (e.Playtime > entries[i-1].Playtime || e.Rank < entries[i-1].Rank) {
+					t.Errorf("Expected entries ranked by playtime, got %+v", entries)
+				}
+				if e.SteamID == alice {
+					return &e
+				}
+			}
+			return nil
+		}
+
+		to := start.Add(75 * time.Minute)
+		if e := find(LeaderboardQuery{From: &start, To: &to}); e == nil || e.Sessions != 2 || e.Playtime != 45*time.Minute || e.Username != "conformance_alice" {
+			t.Errorf("Expected alice with 2 sessions over 45m, got %+v", e)
+		}
+		appid := hl2
+		if e := find(LeaderboardQuery{AppID: &appid}); e == nil || e.Sessions != 2 || e.Playtime != 105*time.Minute {
+			t.Errorf("Expected alice with 105m of HL2, got %+v", e)
+		}
+
+		public := false
+		if err := s.ModifyUser(ctx, alice, ModifyUserParams{Public: &public}); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer func() {
+			public = true
+			s.ModifyUser(ctx, alice, ModifyUserParams{Public: &public})
+		}()
+		if e := find(LeaderboardQuery{}); e != nil {
+			t.Errorf("Expected alice not on the leaderboard while not public, got %+v", e)
+		}
+	})
+
+	t.Run("Co-play", func(t *testing.T) {
+		// bob overlaps alice's GTFO at 12:00 and 14:00 by 15m each and her
+		// HL2 at 13:00 by 10m
+		if err := s.AddUser(ctx, bob, "conformance_bob", true, true); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		for _, session := range []Session{
+			{SteamID: bob, UTCStart: start.Add(15 * time.Minute), UTCEnd: start.Add(135 * time.Minute), AppID: gtfo},
+			{SteamID: bob, UTCStart: start.Add(time.Hour), UTCEnd: start.Add(70 * time.Minute), AppID: hl2},
+		} {
+			if err := s.AddSession(ctx, session); err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+		}
+
+		partner := func(id, other SteamID, q CoPlayQuery) (*CoPlayPartner, time.Duration) {
+			t.Helper()
+			partners, total, err := s.GetCoPlayPartners(ctx, id, q)
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			for _, p := range partners {
+				if p.SteamID == other {
+					return &p, total
+				}
+			}
+			return nil, total
+		}
+
+		p, total := partner(alice, bob, CoPlayQuery{})
+		if p == nil || p.Sessions != 3 || p.Shared != 40*time.Minute || p.Username != "conformance_bob" || !p.LastSeen.Equal(start.Add(135*time.Minute)) {
+			t.Errorf("Expected 40m with bob over 3 sessions, got %+v", p)
+		}
+		if total != 40*time.Minute {
+			t.Errorf("Expected 40m, got %v", total)
+		}
+		to := start.Add(65 * time.Minute)
+		if p, _ := partner(alice, bob, CoPlayQuery{To: &to}); p == nil || p.Sessions != 2 || p.Shared != 20*time.Minute {
+			t.Errorf("Expected 20m with bob before %v, got %+v", to, p)
+		}
+		appid := hl2
+		if p, _ := partner(alice, bob, CoPlayQuery{AppID: &appid}); p == nil || p.Sessions != 1 || p.Shared != 10*time.Minute {
+			t.Errorf("Expected 10m of HL2 with bob, got %+v", p)
+		}
+
+		from, to := start, start.Add(3*time.Hour)
+		sessions, count, err := s.GetCoPlaySessions(ctx, CoPlayQuery{From: &from, To: &to, PageSize: 1000})
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		var ours []CoPlaySession
+		for _, cs := range sessions {
+			if cs.SteamIDs[0] == alice || cs.SteamIDs[1] == alice {
+				ours = append(ours, cs)
+			}
+		}
+		if count < 3 || len(ours) != 3 || !ours[0].UTCStart.Equal(start.Add(2*time.Hour)) || !ours[0].UTCEnd.Equal(start.Add(135*time.Minute)) {
+			t.Errorf("Expected 3 sessions with bob, latest first, got %+v", ours)
+		}
+		if ours[0].SteamIDs != [2]SteamID{alice, bob} && ours[0].SteamIDs != [2]SteamID{bob, alice} {
+			t.Errorf("Expected alice and bob, got %v", ours[0].SteamIDs)
+		}
+
+		public := false
+		if err := s.ModifyUser(ctx, alice, ModifyUserParams{Public: &public}); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer func() {
+			public = true
+			s.ModifyUser(ctx, alice, ModifyUserParams{Public: &public})
+		}()
+		if p, _ := partner(bob, alice, CoPlayQuery{}); p != nil {
+			t.Errorf("Expected alice hidden while not public, got %+v", p)
+		}
+		if p, _ := partner(alice, bob, CoPlayQuery{}); p == nil {
+			t.Errorf("Expected bob still a partner of alice")
+		}
+	})
+
+	t.Run("Auth tokens", func(t *testing.T) {
+		if err := s.CreateAuthToken(ctx, "conformance", "salt", "secret", 10); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if err := s.CreateAuthToken(ctx, "conformance", "salt", "secret", 10); !errors.Is(err, ErrDuplicateTokenName) {
+			t.Errorf("Expected ErrDuplicateTokenName, got %v", err)
+		}
+
+		token, err := s.GetAuthToken("conformance")
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if token.Salt != "salt" || token.Secret != "secret" || token.Clearance != 10 || token.CreateDate.IsZero() {
+			t.Errorf("Expected stored token, got %+v", token)
+		}
+
+		tokens, err := s.ListAuthTokensBelowClearance(ctx, 11)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		found := false
+		for _, info := range tokens {
+			found = found || info.Name == "conformance"
+		}
+		if !found {
+			t.Errorf("Expected conformance token below clearance 11")
+		}
+
+		if err := s.DeleteAuthToken(ctx, "conformance"); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+	})
+
+	t.Run("Read tokens", func(t *testing.T) {
+		if _, err := s.GetReadToken(ctx, alice); !errors.Is(err, ErrReadTokenNotFound) {
+			t.Errorf("Expected ErrReadTokenNotFound, got %v", err)
+		}
+
+		token, salt, secret, err := GenerateToken()
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if err := s.SetReadToken(ctx, alice, "00", "00"); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if err := s.SetReadToken(ctx, alice, salt, secret); err != nil {
+			t.Fatalf("Expected replaced token, got %v", err)
+		}
+		if !AuthenticateReadToken(ctx, s, alice, token) {
+			t.Errorf("Expected the read token to authenticate alice")
+		}
+		if AuthenticateReadToken(ctx, s, bob, token) || AuthenticateReadToken(ctx, s, alice, "00") {
+			t.Errorf("Expected other users and tokens to fail")
+		}
+
+		if err := s.DeleteReadToken(ctx, alice); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		if err := s.DeleteReadToken(ctx, alice); !errors.Is(err, ErrReadTokenNotFound) {
+			t.Errorf("Expected ErrReadTokenNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		if err := s.SetMetadata(ctx, "conformance", "a"); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if err := s.SetMetadata(ctx, "conformance", "b"); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		data, err := s.GetMetadata(ctx, "conformance")
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if data != "b" {
+			t.Errorf("Expected b, got %s", data)
+		}
+		if data, _ := s.GetMetadata(ctx, "conformance_missing"); data != "" {
+			t.Errorf("Expected empty, got %s", data)
+		}
+	})
+
+	t.Run("Downtime", func(t *testing.T) {
+		dt := Downtime{UTCStart: downtimeStart, UTCEnd: downtimeStart.Add(time.Hour)}
+		if err := s.AddDowntime(ctx, dt); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		dt.UTCEnd = downtimeStart.Add(2 * time.Hour)
+		if err := s.AddDowntime(ctx, dt); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+
+		downtimes, err := s.GetDowntimes(ctx, downtimeStart.Add(90*time.Minute), downtimeStart.Add(3*time.Hour))
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(downtimes) != 1 || !downtimes[0].UTCEnd.Equal(dt.UTCEnd) {
+			t.Errorf("Expected extended window %+v, got %+v", dt, downtimes)
+		}
+		if downtimes, _ := s.GetDowntimes(ctx, dt.UTCEnd.Add(time.Minute), dt.UTCEnd.Add(time.Hour)); len(downtimes) != 0 {
+			t.Errorf("Expected no downtime after the window, got %+v", downtimes)
+		}
+	})
+
+	t.Run("Webhooks", func(t *testing.T) {
+		w := Webhook{URL: "http://localhost/hook", Secret: "s3cret", EventTypes: []string{"session_started"}, SteamIDs: []SteamID{alice, bob}}
+		id, err := s.AddWebhook(ctx, w)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		defer s.RemoveWebhook(ctx, id)
+
+		got, err := s.GetWebhook(ctx, id)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if got.URL != w.URL || got.Secret != w.Secret || len(got.EventTypes) != 1 || len(got.SteamIDs) != 2 || got.SteamIDs[1] != bob {
+			t.Errorf("Expected %+v, got %+v", w, got)
+		}
+		webhooks, err := s.GetWebhooks(ctx)
+		if err != nil || len(webhooks) == 0 {
+			t.Errorf("Expected webhooks, got %v (%v)", webhooks, err)
+		}
+
+		delivery := WebhookDelivery{WebhookID: id, EventType: "session_started", Payload: "{}", Status: WebhookPending, NextAttempt: downtimeStart}
+		delivery.ID, err = s.AddWebhookDelivery(ctx, delivery)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		due, err := s.GetDueWebhookDeliveries(ctx, downtimeStart.Add(time.Minute), 10)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(due) != 1 || due[0].ID != delivery.ID {
+			t.Errorf("Expected delivery %d to be due, got %+v", delivery.ID, due)
+		}
+
+		delivery.Status = WebhookDelivered
+		delivery.Attempts = 1
+		delivery.StatusCode = 204
+		if err := s.UpdateWebhookDelivery(ctx, delivery); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if due, _ := s.GetDueWebhookDeliveries(ctx, downtimeStart.Add(time.Minute), 10); len(due) != 0 {
+			t.Errorf("Expected no due deliveries, got %+v", due)
+		}
+		log, err := s.GetWebhookDeliveries(ctx, id, 10, 0)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(log) != 1 || log[0].Status != WebhookDelivered || log[0].Attempts != 1 || log[0].StatusCode != 204 {
+			t.Errorf("Expected delivered entry, got %+v", log)
+		}
+
+		if err := s.RemoveWebhook(ctx, id); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if _, err := s.GetWebhook(ctx, id); err != ErrWebhookNotFound {
+			t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+		}
+		if log, _ := s.GetWebhookDeliveries(ctx, id, 10, 0); len(log) != 0 {
+			t.Errorf("Expected deliveries to be removed, got %+v", log)
+		}
+	})
+
+	t.Run("Leader lock", func(t *testing.T) {
+		lock, err := s.TryLeaderLock(ctx)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if lock == nil {
+			t.Skip("Leader lock is held by a running instance")
+		}
+		if err := lock.Check(ctx); err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+		if db, ok := s.(*DB); ok && db.Driver() == DriverPostgres {
+			if other, _ := s.TryLeaderLock(ctx); other != nil {
+				other.Release()
+				t.Errorf("Expected lock to be held")
+			}
+		}
+
+		lock.Release()
+		lock, err = s.TryLeaderLock(ctx)
+		if err != nil || lock == nil {
+			t.Fatalf("Expected lock to be free after release, got %v", err)
+		}
+		lock.Release()
+	})
+
+	t.Run("Clear leader", func(t *testing.T) {
+		record := func(leader, since string) {
+			t.Helper()
+			if err := s.SetMetadata(ctx, MetaKeyLeader, leader); err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+			if err := s.SetMetadata(ctx, MetaKeyLeaderSince, since); err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+		}
+		expect := func(leader, since string) {
+			t.Helper()
+			gotLeader, _ := s.GetMetadata(ctx, MetaKeyLeader)
+			gotSince, _ := s.GetMetadata(ctx, MetaKeyLeaderSince)
+			if gotLeader != leader || gotSince != since {
+				t.Errorf("Expected leader %q since %q, got %q since %q", leader, since, gotLeader, gotSince)
+			}
+		}
+
+		// Another instance took over, its record stays
+		record("b", "2024-05-01T12:00:00Z")
+		if err := s.ClearLeader(ctx, "a"); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		expect("b", "2024-05-01T12:00:00Z")
+
+		if err := s.ClearLeader(ctx, "b"); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		expect("", "")
+	})
+}
+
+func containsID(ids []SteamID, id SteamID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAppID(ids []AppID, id AppID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSQLiteStore(t *testing.T) {
+	s, err := NewStore(DBConfig{Driver: DriverSQLite, Name: filepath.Join(t.TempDir(), "sptt.db")})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer s.Close()
+
+	testStore(t, s)
+}
+
+// Poll requests are shared by every instance, so they aren't part of the
+// conformance suite that may run against a database in use.
+func TestSQLitePollRequest(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStore(DBConfig{Driver: DriverSQLite, Name: filepath.Join(t.TempDir(), "sptt.db")})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer s.Close()
+
+	if req, err := s.TakePollRequest(ctx); err != nil || req != nil {
+		t.Errorf("Expected no request, got %+v (%v)", req, err)
+	}
+
+	tests := []struct {
+		name     string
+		requests [][]SteamID
+		want     []SteamID
+	}{
+		{"Some users", [][]SteamID{{1, 2}}, []SteamID{1, 2}},
+		{"Merged", [][]SteamID{{1}, {2}}, []SteamID{1, 2}},
+		{"Every user", [][]SteamID{{1}, nil}, nil},
+		{"Every user first", [][]SteamID{nil, {1}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, ids := range tt.requests {
+				if err := s.RequestPoll(ctx, ids); err != nil {
+					t.Fatalf("Expected nil, got %v", err)
+				}
+			}
+			req, err := s.TakePollRequest(ctx)
+			if err != nil || req == nil {
+				t.Fatalf("Expected a request, got %v", err)
+			}
+			if len(req.SteamIDs) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, req.SteamIDs)
+			}
+			for i, id := range tt.want {
+				if req.SteamIDs[i] != id {
+					t.Errorf("Expected %v, got %v", tt.want, req.SteamIDs)
+				}
+			}
+			if req, _ := s.TakePollRequest(ctx); req != nil {
+				t.Errorf("Expected the request to be taken once, got %+v", req)
+			}
+		})
+	}
+}
+
+// Runs against the database configured in ../.env, skipped without one.
+func TestPostgresStore(t *testing.T) {
+	env, err := GetEnv("../.env")
+	if err != nil {
+		t.Skip("No .env, skipping Postgres store tests")
+	}
+	cfg, err := DBConfigFromEnv(env)
+	if err != nil || cfg.Driver != DriverPostgres {
+		t.Skip("No Postgres configured in .env, skipping")
+	}
+
+	s, err := NewStore(cfg)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	defer s.Close()
+
+	testStore(t, s)
+}
@@ -0,0 +1,61 @@
+package tracker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+// DefaultWorkers is the default number of users processed concurrently.
+const DefaultWorkers = 8
+
+// userLock returns the mutex serializing work on a user.
+func (t *Tracker) userLock(id sptt.SteamID) *sync.Mutex {
+	mu, _ := t.userLocks.LoadOrStore(id, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// ProcessUsers runs ProcessUser for every summary on a pool of workers and
+// waits for all of them. Returns how many users were processed and whether
+// any user was deactivated.
+func (t *Tracker) ProcessUsers(ctx context.Context, summaries map[sptt.SteamID]sptt.PlayerSummary, workers int) (processed int, deactivated bool) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	type job struct {
+		id      sptt.SteamID
+		summary sptt.PlayerSummary
+	}
+
+	jobs := make(chan job)
+	results := make(chan bool, len(summaries))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- t.ProcessUser(ctx, j.id, j.summary)
+			}
+		}()
+	}
+
+	for id, summary := range summaries {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- job{id, summary}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	for d := range results {
+		processed++
+		deactivated = deactivated || d
+	}
+	return processed, deactivated
+}
@@ -0,0 +1,167 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+)
+
+// DefaultRecoveryThreshold is how long the monitor may go without a
+// heartbeat, and a session without being seen, before it counts as downtime.
+const DefaultRecoveryThreshold = 10 * time.Minute
+
+// Heartbeat records that the monitor is running. The last heartbeat is where
+// the downtime found by Recover starts.
+func (t *Tracker) Heartbeat(ctx context.Context) error {
+	now := t.now().UTC().Truncate(time.Second)
+	return t.store.SetMetadata(ctx, sptt.MetaKeyHeartbeat, now.Format(time.RFC3339))
+}
+
+// Recover reconciles the state left behind by downtime and has to run before
+// the first poll. A heartbeat older than threshold is recorded as a downtime
+// window, active sessions not seen for longer than threshold are concluded
+// as recovered. Otherwise a user back in the same game would continue the
+// old session across the downtime. Returns the number of recovered sessions.
+func (t *Tracker) Recover(ctx context.Context, threshold time.Duration) (int, error) {
+	now := t.now().UTC().Truncate(time.Second)
+
+	heartbeat, err := t.store.GetMetadata(ctx, sptt.MetaKeyHeartbeat)
+	if err != nil {
+		return 0, fmt.Errorf("error getting heartbeat: %v", err)
+	}
+	if heartbeat != "" {
+		last, err := time.Parse(time.RFC3339, heartbeat)
+		if err != nil {
+			log.Warnf("Ignoring invalid heartbeat %q: %v", heartbeat, err)
+		} else if now.Sub(last) > threshold {
+			log.Warnf("Monitor was down from %v to %v (%v)", last, now, now.Sub(last))
+			err := t.store.AddDowntime(ctx, sptt.Downtime{UTCStart: last, UTCEnd: now})
+			if err != nil {
+				return 0, fmt.Errorf("error recording downtime: %v", err)
+			}
+		}
+	}
+
+	sessions, err := t.store.GetAllActiveSessions(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error getting active sessions: %v", err)
+	}
+
+	orphaned := make(map[sptt.SteamID][]sptt.ActiveSession)
+	for _, sess := range sessions {
+		if now.Sub(sess.LastSeen) > threshold {
+			orphaned[sess.SteamID] = append(orphaned[sess.SteamID], sess)
+		}
+	}
+
+	recovered := 0
+	for id, userSessions := range orphaned {
+		n, err := t.recoverSessions(ctx, id, userSessions, now)
+		recovered += n
+		if err != nil {
+			log.Errorf("Failed to recover sessions for %v: %v", id, err)
+		}
+	}
+	if recovered > 0 {
+		log.Infof("Recovered %d orphaned sessions", recovered)
+	}
+	return recovered, nil
+}
+
+// recoverSessions concludes the orphaned sessions of a user. A session ends
+// after the playtime Steam counted since its start, but no earlier than the
+// last time it was seen and no later than now. Without a playtime baseline
+// it ends when it was last seen.
+func (t *Tracker) recoverSessions(ctx context.Context, id sptt.SteamID, sessions []sptt.ActiveSession, now time.Time) (int, error) {
+	mu := t.userLock(id)
+	mu.Lock()
+	defer mu.Unlock()
+
+	appids := make([]sptt.AppID, 0, len(sessions))
+	for _, sess := range sessions {
+		appids = append(appids, sess.AppID)
+	}
+	playtimes := t.recoveryPlaytimes(ctx, id, appids)
+
+	recovered := 0
+	for _, sess := range sessions {
+		newSession := sptt.Session{
+			SteamID:         id,
+			UTCStart:        sess.UTCStart,
+			UTCEnd:          sess.LastSeen,
+			PlaytimeForever: -1,
+			AppID:           sess.AppID,
+			PlaytimeSource:  sptt.PlaytimeSourceNone,
+			Recovered:       true,
+		}
+
+		pt, found := playtimes[sess.AppID]
+		if found && sess.PlaytimeForever != -1 {
+			newSession.PlaytimeForever = pt.playtime
+			newSession.PlaytimeSource = pt.source
+			if delta := pt.playtime - sess.PlaytimeForever; delta > 0 {
+				end := sess.UTCStart.Add(time.Duration(delta) * time.Minute)
+				if end.Before(sess.LastSeen) {
+					end = sess.LastSeen
+				}
+				if end.After(now) {
+					end = now
+				}
+				newSession.UTCEnd = end
+			}
+		} else {
+			log.Warnf("No playtime baseline for recovered session of user %v in game %v, ending it when last seen", id, sess.AppID)
+		}
+
+		if err := t.store.ConcludeSession(ctx, newSession); err != nil {
+			return recovered, fmt.Errorf("error concluding recovered session for game %v: %v", sess.AppID, err)
+		}
+		recovered++
+		t.events.Publish(events.SessionConcluded{Session: newSession})
+		log.Infof("Recovered session for user %v in game %v, %v to %v", id, sess.AppID, newSession.UTCStart, newSession.UTCEnd)
+	}
+	return recovered, nil
+}
+
+type recoveryPlaytime struct {
+	playtime int32
+	source   sptt.PlaytimeSource
+}
+
+// recoveryPlaytimes looks up playtime_forever of appids, falling back to
+// recently played games. Games Steam has no data for are left out, errors
+// are logged since recovery proceeds without playtime anyway.
+func (t *Tracker) recoveryPlaytimes(ctx context.Context, id sptt.SteamID, appids []sptt.AppID) map[sptt.AppID]recoveryPlaytime {
+	playtimes := make(map[sptt.AppID]recoveryPlaytime, len(appids))
+
+	games, err := t.steam.GetOwnedGames(ctx, id, appids)
+	if err != nil && err != sptt.ErrEmptyGames {
+		log.Warnf("GetOwnedGames failed while recovering sessions of user %v: %v", id, err)
+	}
+	for _, appid := range appids {
+		if game, ok := games[appid]; ok {
+			playtimes[appid] = recoveryPlaytime{game.Playtime, sptt.PlaytimeSourceOwnedGames}
+		}
+	}
+	if len(playtimes) == len(appids) {
+		return playtimes
+	}
+
+	recent, err := t.steam.GetRecentlyPlayedGames(ctx, id)
+	if err != nil && err != sptt.ErrEmptyGames {
+		log.Warnf("GetRecentlyPlayedGames failed while recovering sessions of user %v: %v", id, err)
+	}
+	for _, appid := range appids {
+		if _, ok := playtimes[appid]; ok {
+			continue
+		}
+		if game, ok := recent[appid]; ok {
+			playtimes[appid] = recoveryPlaytime{game.Playtime, sptt.PlaytimeSourceRecentlyPlayed}
+		}
+	}
+	return playtimes
+}
@@ -0,0 +1,123 @@
+package tracker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+func TestRecover(t *testing.T) {
+	start := now.Add(-3 * time.Hour)
+	lastSeen := start.Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		lastSeen time.Time
+		baseline int32
+		steam    fakeSteam
+		want     *sptt.Session // nil if the session should stay active
+	}{
+		{
+			name:     "Ends after steam's playtime delta",
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(190)},
+			want:     &sptt.Session{UTCEnd: start.Add(90 * time.Minute), PlaytimeForever: 190, PlaytimeSource: sptt.PlaytimeSourceOwnedGames},
+		},
+		{
+			name:     "Delta is not shorter than the last observation",
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(130)},
+			want:     &sptt.Session{UTCEnd: lastSeen, PlaytimeForever: 130, PlaytimeSource: sptt.PlaytimeSourceOwnedGames},
+		},
+		{
+			name:     "Delta is capped at now",
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(400)},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: 400, PlaytimeSource: sptt.PlaytimeSourceOwnedGames},
+		},
+		{
+			name:     "Recently played fallback",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrEmptyGames, recent: recent(190)},
+			want:     &sptt.Session{UTCEnd: start.Add(90 * time.Minute), PlaytimeForever: 190, PlaytimeSource: sptt.PlaytimeSourceRecentlyPlayed},
+		},
+		{
+			name:     "No baseline ends at last observation",
+			baseline: -1,
+			steam:    fakeSteam{owned: owned(190)},
+			want:     &sptt.Session{UTCEnd: lastSeen, PlaytimeForever: -1, PlaytimeSource: sptt.PlaytimeSourceNone},
+		},
+		{
+			name:     "Steam unavailable ends at last observation",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrUpstreamUnavailable, recentErr: sptt.ErrUpstreamUnavailable},
+			want:     &sptt.Session{UTCEnd: lastSeen, PlaytimeForever: -1, PlaytimeSource: sptt.PlaytimeSourceNone},
+		},
+		{
+			name:     "Recently seen session is kept",
+			lastSeen: now.Add(-time.Minute),
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(190)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := tt.lastSeen
+			if seen.IsZero() {
+				seen = lastSeen
+			}
+			store := newFakeStore(sptt.ActiveSession{SteamID: alice, UTCStart: start, PlaytimeForever: tt.baseline, AppID: gtfo, LastSeen: seen})
+			tr := NewTracker(store, &tt.steam, func() time.Time { return now })
+
+			n, err := tr.Recover(context.Background(), DefaultRecoveryThreshold)
+			if err != nil {
+				t.Fatalf("Expected nil, got %v", err)
+			}
+
+			if tt.want == nil {
+				if n != 0 || len(store.concluded) != 0 {
+					t.Errorf("Expected no recovered session, got %+v", store.concluded)
+				}
+				return
+			}
+
+			if n != 1 || len(store.concluded) != 1 {
+				t.Fatalf("Expected 1 recovered session, got %d", len(store.concluded))
+			}
+			want := *tt.want
+			want.SteamID, want.AppID, want.UTCStart, want.Recovered = alice, gtfo, start, true
+			if got := store.concluded[0]; got != want {
+				t.Errorf("Expected %+v, got %+v", want, got)
+			}
+		})
+	}
+
+	t.Run("Downtime from last heartbeat", func(t *testing.T) {
+		store := newFakeStore()
+		clock := now.Add(-time.Hour)
+		tr := NewTracker(store, &fakeSteam{}, func() time.Time { return clock })
+		if err := tr.Heartbeat(context.Background()); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+
+		clock = now
+		if _, err := tr.Recover(context.Background(), DefaultRecoveryThreshold); err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		want := sptt.Downtime{UTCStart: now.Add(-time.Hour), UTCEnd: now}
+		if len(store.downtimes) != 1 || store.downtimes[0] != want {
+			t.Errorf("Expected %+v, got %+v", want, store.downtimes)
+		}
+
+		// A restart within the threshold is not downtime
+		tr.Heartbeat(context.Background())
+		clock = now.Add(time.Minute)
+		tr.Recover(context.Background(), DefaultRecoveryThreshold)
+		if len(store.downtimes) != 1 {
+			t.Errorf("Expected 1 downtime, got %d", len(store.downtimes))
+		}
+	})
+}
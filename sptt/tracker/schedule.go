@@ -0,0 +1,208 @@
+package tracker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+// budgetWindow is how far back requests are counted to project daily usage.
+const budgetWindow = time.Hour
+
+// PollConfig configures how often users are polled.
+type PollConfig struct {
+	Interval        time.Duration // online users and users offline for less than OfflineAfter
+	InGameInterval  time.Duration // users in game, for sharper start and end times
+	OfflineInterval time.Duration // users offline for longer than OfflineAfter
+	OfflineAfter    time.Duration
+	DailyBudget     int // Steam Web API requests per day, 0 for no budget
+}
+
+// DefaultPollConfig keeps 10% of the API key's daily quota spare for the
+// API server and retries.
+func DefaultPollConfig() PollConfig {
+	return PollConfig{
+		Interval:        time.Minute,
+		InGameInterval:  30 * time.Second,
+		OfflineInterval: 10 * time.Minute,
+		OfflineAfter:    72 * time.Hour,
+		DailyBudget:     sptt.WebAPIRequestsPerDay * 9 / 10,
+	}
+}
+
+// TickInterval is how often the scheduler should be asked for due users.
+func (c PollConfig) TickInterval() time.Duration {
+	return min(c.Interval, c.InGameInterval)
+}
+
+type pollState struct {
+	next       time.Time
+	lastOnline time.Time
+}
+
+type spend struct {
+	at time.Time
+	n  int
+}
+
+// Scheduler decides which users are due for polling. Users in game are
+// polled every InGameInterval, users offline for days every
+// OfflineInterval, everyone else every Interval. If the requests of the
+// last budgetWindow project beyond DailyBudget, all intervals are
+// stretched by the overshoot.
+type Scheduler struct {
+	cfg PollConfig
+	now func() time.Time
+
+	mu    sync.Mutex
+	users map[sptt.SteamID]*pollState
+	spent []spend
+}
+
+// NewScheduler creates a scheduler reading the current time from now,
+// time.Now is used if now is nil.
+func NewScheduler(cfg PollConfig, now func() time.Time) *Scheduler {
+	if now == nil {
+		now = time.Now
+	}
+	return &Scheduler{
+		cfg:   cfg,
+		now:   now,
+		users: make(map[sptt.SteamID]*pollState),
+	}
+}
+
+// Due returns the ids that should be polled now. Users not seen before are
+// always due, state of users not in ids is dropped.
+func (s *Scheduler) Due(ids []sptt.SteamID) []sptt.SteamID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	known := make(map[sptt.SteamID]bool, len(ids))
+	var due []sptt.SteamID
+	for _, id := range ids {
+		known[id] = true
+		st, ok := s.users[id]
+		if !ok || !now.Before(st.next) {
+			due = append(due, id)
+		}
+	}
+	for id := range s.users {
+		if !known[id] {
+			delete(s.users, id)
+		}
+	}
+	return due
+}
+
+// Observe schedules the next poll of a user from their latest summary.
+func (s *Scheduler) Observe(summary sptt.PlayerSummary) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	st, ok := s.users[summary.SteamID]
+	if !ok {
+		st = &pollState{}
+		if summary.LastLogoff > 0 {
+			st.lastOnline = time.Unix(summary.LastLogoff, 0)
+		} else {
+			st.lastOnline = now
+		}
+		s.users[summary.SteamID] = st
+	}
+
+	interval := s.cfg.Interval
+	switch {
+	case summary.GameID != nil:
+		st.lastOnline = now
+		interval = s.cfg.InGameInterval
+	case summary.PersonaState != 0:
+		st.lastOnline = now
+	case now.Sub(st.lastOnline) > s.cfg.OfflineAfter:
+		interval = s.cfg.OfflineInterval
+	}
+
+	st.next = now.Add(time.Duration(float64(interval) * s.scaleLocked(now)))
+}
+
+// PollNow makes ids due immediately, or every user if ids is empty.
+func (s *Scheduler) PollNow(ids ...sptt.SteamID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(ids) == 0 {
+		for _, st := range s.users {
+			st.next = time.Time{}
+		}
+		return
+	}
+	for _, id := range ids {
+		if st, ok := s.users[id]; ok {
+			st.next = time.Time{}
+		}
+	}
+}
+
+// Spend records n Steam requests against the budget.
+func (s *Scheduler) Spend(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spent = append(s.spent, spend{s.now(), n})
+}
+
+// Scale returns the factor all intervals are currently stretched by.
+func (s *Scheduler) Scale() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scaleLocked(s.now())
+}
+
+func (s *Scheduler) scaleLocked(now time.Time) float64 {
+	if s.cfg.DailyBudget <= 0 {
+		return 1
+	}
+
+	cutoff := now.Add(-budgetWindow)
+	i := 0
+	for i < len(s.spent) && s.spent[i].at.Before(cutoff) {
+		i++
+	}
+	s.spent = s.spent[i:]
+
+	total := 0
+	for _, sp := range s.spent {
+		total += sp.n
+	}
+
+	projected := float64(total) * float64(24*time.Hour) / float64(budgetWindow)
+	return max(1, projected/float64(s.cfg.DailyBudget))
+}
+
+// Client wraps c so every request it makes is spent against the budget.
+func (s *Scheduler) Client(c sptt.SteamClient) sptt.SteamClient {
+	return &budgetClient{c, s}
+}
+
+type budgetClient struct {
+	sptt.SteamClient
+	s *Scheduler
+}
+
+func (b *budgetClient) GetPlayerSummaries(ctx context.Context, steamids []sptt.SteamID) (map[sptt.SteamID]sptt.PlayerSummary, error) {
+	b.s.Spend((len(steamids) + sptt.PlayerSummariesBatchSize - 1) / sptt.PlayerSummariesBatchSize)
+	return b.SteamClient.GetPlayerSummaries(ctx, steamids)
+}
+
+func (b *budgetClient) GetOwnedGames(ctx context.Context, steamid sptt.SteamID, appids []sptt.AppID) (map[sptt.AppID]sptt.GameInfo, error) {
+	b.s.Spend(1)
+	return b.SteamClient.GetOwnedGames(ctx, steamid, appids)
+}
+
+func (b *budgetClient) GetRecentlyPlayedGames(ctx context.Context, steamid sptt.SteamID) (map[sptt.AppID]sptt.RecentGame, error) {
+	b.s.Spend(1)
+	return b.SteamClient.GetRecentlyPlayedGames(ctx, steamid)
+}
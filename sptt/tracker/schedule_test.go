@@ -0,0 +1,101 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+)
+
+func TestScheduler(t *testing.T) {
+	cfg := PollConfig{
+		Interval:        time.Minute,
+		InGameInterval:  30 * time.Second,
+		OfflineInterval: 10 * time.Minute,
+		OfflineAfter:    72 * time.Hour,
+		DailyBudget:     2400, // 100 per hour
+	}
+	clock := now
+	s := NewScheduler(cfg, func() time.Time { return clock })
+
+	bob := alice + 1
+	carol := alice + 2
+	appid := gtfo
+	ids := []sptt.SteamID{alice, bob, carol}
+
+	if due := s.Due(ids); len(due) != 3 {
+		t.Fatalf("Expected new users to be due, got %v", due)
+	}
+
+	s.Observe(sptt.PlayerSummary{SteamID: alice, PersonaState: 1, GameID: &appid})
+	s.Observe(sptt.PlayerSummary{SteamID: bob, PersonaState: 1})
+	s.Observe(sptt.PlayerSummary{SteamID: carol, LastLogoff: now.Add(-7 * 24 * time.Hour).Unix()})
+
+	tests := []struct {
+		after time.Duration
+		want  []sptt.SteamID
+	}{
+		{10 * time.Second, nil},
+		{30 * time.Second, []sptt.SteamID{alice}},
+		{time.Minute, []sptt.SteamID{alice, bob}},
+		{10 * time.Minute, []sptt.SteamID{alice, bob, carol}},
+	}
+	for _, tt := range tests {
+		clock = now.Add(tt.after)
+		due := s.Due(ids)
+		if len(due) != len(tt.want) {
+			t.Errorf("After %v: expected %v, got %v", tt.after, tt.want, due)
+			continue
+		}
+		for i := range due {
+			if due[i] != tt.want[i] {
+				t.Errorf("After %v: expected %v, got %v", tt.after, tt.want, due)
+			}
+		}
+	}
+
+	t.Run("Budget", func(t *testing.T) {
+		clock = now
+		if scale := s.Scale(); scale != 1 {
+			t.Errorf("Expected 1, got %.2f", scale)
+		}
+
+		// 200 requests in the last hour project to twice the budget
+		s.Spend(200)
+		if scale := s.Scale(); scale != 2 {
+			t.Errorf("Expected 2, got %.2f", scale)
+		}
+		s.Observe(sptt.PlayerSummary{SteamID: bob, PersonaState: 1})
+		clock = now.Add(time.Minute)
+		if due := s.Due([]sptt.SteamID{bob}); len(due) != 0 {
+			t.Errorf("Expected bob to be backed off, got %v", due)
+		}
+
+		clock = now.Add(2 * time.Hour)
+		if scale := s.Scale(); scale != 1 {
+			t.Errorf("Expected budget window to expire, got %.2f", scale)
+		}
+	})
+
+	t.Run("Poll now", func(t *testing.T) {
+		clock = now.Add(3 * time.Hour)
+		s.Observe(sptt.PlayerSummary{SteamID: alice, PersonaState: 1})
+		s.Observe(sptt.PlayerSummary{SteamID: carol, PersonaState: 1})
+
+		s.PollNow(carol)
+		if due := s.Due([]sptt.SteamID{alice, carol}); len(due) != 1 || due[0] != carol {
+			t.Errorf("Expected only carol to be due, got %v", due)
+		}
+		s.PollNow()
+		if due := s.Due([]sptt.SteamID{alice, carol}); len(due) != 2 {
+			t.Errorf("Expected everyone to be due, got %v", due)
+		}
+	})
+
+	t.Run("Removed users are forgotten", func(t *testing.T) {
+		s.Due([]sptt.SteamID{alice})
+		if _, ok := s.users[bob]; ok {
+			t.Errorf("Expected bob to be dropped")
+		}
+	})
+}
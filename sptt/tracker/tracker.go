@@ -0,0 +1,374 @@
+// Package tracker holds the rules turning player summaries and playtime
+// reported by Steam into active and concluded sessions.
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+)
+
+const (
+	// DefaultTimeTolerance is how many minutes the server and Steam may
+	// disagree on a session's length before Steam's value is preferred.
+	DefaultTimeTolerance int32 = 3
+	// DefaultStaleAfter is how many minutes a concluded session without new
+	// playtime is kept waiting for Steam before it is considered stale.
+	DefaultStaleAfter int32 = 3
+)
+
+// Config holds the tolerances of the session rules, in minutes, and the bus
+// session lifecycle events are published on.
+type Config struct {
+	TimeTolerance int32
+	StaleAfter    int32
+	Events        *events.Bus // nil to not publish events
+}
+
+// DefaultConfig returns the tolerances the tracker uses unless configured.
+func DefaultConfig() Config {
+	return Config{
+		TimeTolerance: DefaultTimeTolerance,
+		StaleAfter:    DefaultStaleAfter,
+	}
+}
+
+// Store is the part of sptt.Store the tracker reads and writes.
+type Store interface {
+	GetActiveSessions(ctx context.Context, id sptt.SteamID) (map[sptt.AppID]sptt.ActiveSession, error)
+	AddActiveSession(ctx context.Context, session sptt.ActiveSession) error
+	RemoveActiveSessions(ctx context.Context, steamid sptt.SteamID) error
+	ConcludeSession(ctx context.Context, session sptt.Session) error
+	SetUserActive(ctx context.Context, id sptt.SteamID, active bool) error
+	TouchActiveSession(ctx context.Context, steamid sptt.SteamID, appid sptt.AppID, t time.Time) error
+
+	// Downtime recovery
+	GetAllActiveSessions(ctx context.Context) ([]sptt.ActiveSession, error)
+	AddDowntime(ctx context.Context, dt sptt.Downtime) error
+	GetMetadata(ctx context.Context, key string) (string, error)
+	SetMetadata(ctx context.Context, key, data string) error
+}
+
+// Tracker starts and concludes sessions for users. It is safe for
+// concurrent use, calls for the same user are serialized.
+type Tracker struct {
+	store         Store
+	steam         sptt.SteamClient
+	now           func() time.Time
+	timeTolerance int32
+	staleAfter    int32
+	events        *events.Bus
+	userLocks     sync.Map // SteamID -> *sync.Mutex
+}
+
+// NewTracker creates a tracker with the default tolerances, reading the
+// current time from now. time.Now is used if now is nil.
+func NewTracker(store Store, steam sptt.SteamClient, now func() time.Time) *Tracker {
+	return NewTrackerWithConfig(store, steam, now, DefaultConfig())
+}
+
+// NewTrackerWithConfig creates a tracker with custom tolerances.
+func NewTrackerWithConfig(store Store, steam sptt.SteamClient, now func() time.Time, cfg Config) *Tracker {
+	if now == nil {
+		now = time.Now
+	}
+	return &Tracker{
+		store:         store,
+		steam:         steam,
+		now:           now,
+		timeTolerance: cfg.TimeTolerance,
+		staleAfter:    cfg.StaleAfter,
+		events:        cfg.Events,
+	}
+}
+
+// ProcessUser updates the sessions of a user from their player summary.
+// Returns true if the user was deactivated and the user list needs a reload.
+func (t *Tracker) ProcessUser(ctx context.Context, id sptt.SteamID, summary sptt.PlayerSummary) (deactivated bool) {
+	if summary.SteamID != id {
+		log.Error("SteamID mismatch, expected ", id, " got ", summary.SteamID)
+		log.Error("Skipping user ", id, "/", summary.SteamID)
+		return false
+	}
+
+	mu := t.userLock(id)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if summary.Visibility != 3 {
+		log.Debugf("User %v has a private profile", id)
+		log.Infof("Releasing active_sessions and deactivating %v b/c private profile", id)
+		err := t.store.RemoveActiveSessions(ctx, id)
+		if err != nil {
+			log.Errorf("Error removing active_sessions for %v: %v", id, err)
+		}
+		err = t.store.SetUserActive(ctx, id, false)
+		if err != nil {
+			log.Errorf("Error setting user %v inactive: %v", id, err)
+		}
+		t.events.Publish(events.ProfileWentPrivate{SteamID: id})
+		return true
+	}
+
+	// User in game
+	if summary.GameID != nil {
+		err := t.startSession(ctx, id, summary)
+		if err != nil {
+			log.Errorf("Failed to start session for %v: %v", id, err)
+		}
+		return false
+	}
+
+	// User not in game
+	log.Debugf("User %v is not in game", id)
+	activeSessions, err := t.store.GetActiveSessions(ctx, id)
+	if err != nil {
+		log.Errorf("Error getting active_sessions for %v: %v", id, err)
+		return false
+	}
+
+	if len(activeSessions) > 0 {
+		err := t.concludeSessions(ctx, id, activeSessions)
+		if err != nil {
+			log.Errorf("Failed to conclude sessions for %v: %v", id, err)
+		}
+	}
+	return false
+}
+
+func (t *Tracker) startSession(ctx context.Context, id sptt.SteamID, summary sptt.PlayerSummary) error {
+	if summary.GameID == nil {
+		return fmt.Errorf("GameID is nil for %v, cannot start session", id)
+	}
+
+	gameId := *summary.GameID
+
+	activeSessions, err := t.store.GetActiveSessions(ctx, id)
+	if err != nil {
+		log.Errorf("Error while trying to get active sessions for user %v: %v", id, err)
+		return err
+	}
+
+	existingSession, alreadyPlaying := activeSessions[gameId]
+
+	// if gameId is already in active sessions, only record that it was seen
+	if alreadyPlaying {
+		log.Debugf("User %v is already playing game %v since %v", id, gameId, existingSession.UTCStart)
+		err := t.store.TouchActiveSession(ctx, id, gameId, t.now().UTC().Truncate(time.Second))
+		if err != nil {
+			log.Errorf("Error updating last seen of active session for %v: %v", id, err)
+		}
+		return nil
+	}
+
+	var playtime int32 = 0
+	game, err := sptt.GetOwnedGame(ctx, t.steam, id, gameId)
+	if err != nil && err != sptt.ErrEmptyGames {
+		log.Errorf("Error while trying to get owned game for user %v: %v", id, err)
+		return err
+	}
+
+	// NOTE: used to assume absence of playtime_2weeks implies time hidden.
+	// This is INCORRECT - playtime_2weeks is also hidden if game is not played in the last 2 weeks, even if playtime_forever is visible.
+	// We now check if playtime is 0; however, this also overlooks the case where the game
+	// is newly added and never played -- need to be handled in concludeSessions.
+	if err == sptt.ErrEmptyGames || (game.Playtime2Weeks == nil && game.Playtime == 0) {
+		log.Warnf("Playtime of game for user %v is empty/private/new, PlaytimeForever will be recorded as -1", id)
+		playtime = -1
+	} else {
+		playtime = game.Playtime
+	}
+
+	log.Debugf("User %v has no active sessions, starting new session", id)
+	start := t.now().UTC().Truncate(time.Second)
+	sess := sptt.ActiveSession{
+		SteamID:         id,
+		UTCStart:        start,
+		PlaytimeForever: playtime,
+		AppID:           *summary.GameID,
+		LastSeen:        start,
+	}
+
+	err = t.store.AddActiveSession(ctx, sess)
+	if err != nil {
+		log.Errorf("Error adding active session for %v: %v", id, err)
+		return err
+	}
+	log.Infof("Started new session for %v in game %v", id, gameId)
+	t.events.Publish(events.SessionStarted{Session: sess})
+
+	return nil
+}
+
+func (t *Tracker) concludeSessions(ctx context.Context, id sptt.SteamID, activeSessions map[sptt.AppID]sptt.ActiveSession) error {
+	log.Debug("User ", id, " has active sessions, releasing them")
+	now := t.now().UTC().Truncate(time.Second)
+	timeTolerance := t.timeTolerance
+
+	appids := make([]sptt.AppID, 0, len(activeSessions))
+	for _, sess := range activeSessions {
+		appids = append(appids, sess.AppID)
+	}
+
+	games, err := t.steam.GetOwnedGames(ctx, id, appids)
+
+	// Steam kept rate limiting or failing after retries - defer to next cycle
+	if err == sptt.ErrRateLimited || err == sptt.ErrUpstreamUnavailable {
+		log.Warnf("GetOwnedGames failed for user %v (%v), deferring session conclusion", id, err)
+		return nil
+	}
+
+	if err != nil && err != sptt.ErrEmptyGames && err != sptt.ErrEmptyResponse {
+		return fmt.Errorf("error getting owned games for user %v: %v", id, err)
+	}
+
+	ownedErr := err
+
+	// GetRecentlyPlayedGames is used as a fallback whenever GetOwnedGames
+	// has nothing for a game. It is fetched at most once per conclusion.
+	var recentGames map[sptt.AppID]sptt.RecentGame
+	var recentErr error
+	recentFetched := false
+	getRecentGames := func() (map[sptt.AppID]sptt.RecentGame, error) {
+		if !recentFetched {
+			recentGames, recentErr = t.steam.GetRecentlyPlayedGames(ctx, id)
+			recentFetched = true
+			if recentErr != nil && recentErr != sptt.ErrEmptyGames {
+				log.Warnf("GetRecentlyPlayedGames fallback failed for user %v: %v", id, recentErr)
+			}
+		}
+		return recentGames, recentErr
+	}
+
+	// Case 3: Steam returned an empty response envelope - try the fallback,
+	// defer to next cycle if it has no data either. Sessions of games missing
+	// from the fallback are deferred in the loop below.
+	if ownedErr == sptt.ErrEmptyResponse {
+		if _, err := getRecentGames(); err != nil {
+			log.Warnf("GetOwnedGames returned empty response for user %v and fallback has no data (%v), deferring session conclusion", id, err)
+			return nil
+		}
+		log.Warnf("GetOwnedGames returned empty response for user %v, concluding with recently played games", id)
+	}
+
+	// Case 1 (partial): ErrEmptyGames means the library is private or empty - no playtime data available
+	// from GetOwnedGames, the fallback may still have it
+	if ownedErr == sptt.ErrEmptyGames {
+		log.Warnf("Games for user %v are empty/private, falling back to recently played games", id)
+	}
+
+	// lookupPlaytime returns the current playtime_forever of appid and which endpoint supplied it
+	lookupPlaytime := func(appid sptt.AppID) (int32, sptt.PlaytimeSource, bool) {
+		if game, ok := games[appid]; ok {
+			return game.Playtime, sptt.PlaytimeSourceOwnedGames, true
+		}
+		recent, err := getRecentGames()
+		if err != nil {
+			return 0, sptt.PlaytimeSourceNone, false
+		}
+		if game, ok := recent[appid]; ok {
+			log.Infof("Using recently played games for game %v of user %v", appid, id)
+			return game.Playtime, sptt.PlaytimeSourceRecentlyPlayed, true
+		}
+		return 0, sptt.PlaytimeSourceNone, false
+	}
+
+	for _, sess := range activeSessions {
+		newSession := sptt.Session{
+			SteamID:        id,
+			UTCStart:       sess.UTCStart,
+			AppID:          sess.AppID,
+			PlaytimeSource: sptt.PlaytimeSourceNone,
+		}
+
+		playtime, source, gameFound := lookupPlaytime(sess.AppID)
+
+		// The empty response says nothing about the game, try again next cycle
+		if !gameFound && ownedErr == sptt.ErrEmptyResponse {
+			log.Warnf("Game %v of user %v not in recently played games after empty response, deferring session conclusion", sess.AppID, id)
+			continue
+		}
+
+		if gameFound && sess.PlaytimeForever != -1 {
+			// Case 2: playtime_forever is available from Steam and we have a baseline from session start
+			playtimeDiffSteam := playtime - sess.PlaytimeForever
+			playtimeDiffServer := int32(now.Sub(sess.UTCStart).Abs().Minutes())
+			playtimeDiffDiff := math.Abs(float64(playtimeDiffServer - playtimeDiffSteam))
+
+			if playtimeDiffSteam == 0 {
+				log.Debugf("No playtime difference for game %v for user %v, defer if not too old (>%vm)", sess.AppID, id, t.staleAfter)
+
+				if playtimeDiffServer <= t.staleAfter {
+					continue
+				}
+
+				// Steam never counted the session, record it with zero length so
+				// the history keeps a valid playtime_forever
+				newSession.PlaytimeForever = playtime
+				newSession.PlaytimeSource = source
+				newSession.UTCEnd = sess.UTCStart
+
+				if err := t.store.ConcludeSession(ctx, newSession); err != nil {
+					return fmt.Errorf("error concluding stale session for user %v game %v: %v", id, sess.AppID, err)
+				}
+
+				log.Infof("Concluded stale 0-playtime session for user %v in game %v after %d server minutes", id, sess.AppID, playtimeDiffServer)
+				t.events.Publish(events.SessionConcluded{Session: newSession})
+
+				continue
+			}
+
+			newSession.PlaytimeForever = playtime
+			newSession.PlaytimeSource = source
+			if playtimeDiffDiff > float64(timeTolerance) {
+				log.Warnf("Significant playtime difference for game %v user %v: steam=%d server=%d minutes, using Steam's value", sess.AppID, id, playtimeDiffSteam, playtimeDiffServer)
+				newSession.UTCEnd = sess.UTCStart.Add(time.Duration(playtimeDiffSteam) * time.Minute)
+			} else {
+				newSession.UTCEnd = now
+			}
+		} else {
+			// Case 1: playtime_forever unavailable — library private/empty, game missing from both responses,
+			// or session started without a playtime baseline (sess.PlaytimeForever == -1)
+			if !gameFound {
+				log.Warnf("Game %v not found in owned or recently played games for user %v, concluding without playtime_forever", sess.AppID, id)
+			}
+
+			if sess.PlaytimeForever == -1 && gameFound {
+				// No baseline at session start, but Steam has data now.
+				// If playtime ≈ server duration, this is a first-play (newly added game).
+				// Otherwise the profile was hidden at start — we have no valid baseline.
+				playtimeDiffServer := int32(now.Sub(sess.UTCStart).Abs().Minutes())
+				if math.Abs(float64(playtime)-float64(playtimeDiffServer)) <= float64(timeTolerance) {
+					log.Infof("Game %v for user %v appears newly added (steam=%d ≈ server=%d min), recording playtime_forever", sess.AppID, id, playtime, playtimeDiffServer)
+					newSession.PlaytimeForever = playtime
+					newSession.PlaytimeSource = source
+				} else {
+					log.Warnf("Game %v for user %v had no playtime baseline and steam playtime (%d) diverges from server duration (%d min), concluding without playtime_forever", sess.AppID, id, playtime, playtimeDiffServer)
+					newSession.PlaytimeForever = -1
+				}
+			} else {
+				if sess.PlaytimeForever == -1 {
+					log.Warnf("Session for game %v user %v had no playtime baseline, concluding without playtime_forever", sess.AppID, id)
+				}
+				newSession.PlaytimeForever = -1
+			}
+
+			newSession.UTCEnd = now
+		}
+
+		// The active session is kept on error and concluded again next cycle
+		if err := t.store.ConcludeSession(ctx, newSession); err != nil {
+			return fmt.Errorf("error concluding session for user %v game %v: %v", id, sess.AppID, err)
+		}
+
+		log.Infof("Released session for user %v in game %v", id, sess.AppID)
+		t.events.Publish(events.SessionConcluded{Session: newSession})
+	}
+	return nil
+}
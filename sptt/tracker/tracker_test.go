@@ -0,0 +1,475 @@
+package tracker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/sptttest"
+)
+
+const (
+	alice = sptttest.Alice
+	gtfo  = sptttest.GTFO
+)
+
+var now = time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeSteam struct {
+	owned     map[sptt.AppID]sptt.GameInfo
+	ownedErr  error
+	recent    map[sptt.AppID]sptt.RecentGame
+	recentErr error
+}
+
+func (f *fakeSteam) GetPlayerSummaries(ctx context.Context, steamids []sptt.SteamID) (map[sptt.SteamID]sptt.PlayerSummary, error) {
+	return nil, nil
+}
+
+// GetOwnedGames applies the appids filter like Steam does
+func (f *fakeSteam) GetOwnedGames(ctx context.Context, steamid sptt.SteamID, appids []sptt.AppID) (map[sptt.AppID]sptt.GameInfo, error) {
+	if f.ownedErr != nil {
+		return nil, f.ownedErr
+	}
+	games := make(map[sptt.AppID]sptt.GameInfo)
+	for _, appid := range appids {
+		if game, ok := f.owned[appid]; ok {
+			games[appid] = game
+		}
+	}
+	if len(games) == 0 {
+		return nil, sptt.ErrEmptyGames
+	}
+	return games, nil
+}
+
+func (f *fakeSteam) GetRecentlyPlayedGames(ctx context.Context, steamid sptt.SteamID) (map[sptt.AppID]sptt.RecentGame, error) {
+	if f.recentErr != nil {
+		return nil, f.recentErr
+	}
+	if len(f.recent) == 0 {
+		return nil, sptt.ErrEmptyGames
+	}
+	return f.recent, nil
+}
+
+func (f *fakeSteam) GetGameDetails(ctx context.Context, appid sptt.AppID) (sptt.GameData, error) {
+	return sptt.GameData{}, sptt.ErrNoGameData
+}
+
+type fakeStore struct {
+	active     map[sptt.AppID]sptt.ActiveSession
+	concluded  []sptt.Session
+	userActive bool
+	downtimes  []sptt.Downtime
+	meta       map[string]string
+}
+
+func newFakeStore(active ...sptt.ActiveSession) *fakeStore {
+	s := &fakeStore{active: make(map[sptt.AppID]sptt.ActiveSession), userActive: true, meta: make(map[string]string)}
+	for _, sess := range active {
+		s.active[sess.AppID] = sess
+	}
+	return s
+}
+
+func (s *fakeStore) GetActiveSessions(ctx context.Context, id sptt.SteamID) (map[sptt.AppID]sptt.ActiveSession, error) {
+	sessions := make(map[sptt.AppID]sptt.ActiveSession)
+	for appid, sess := range s.active {
+		sessions[appid] = sess
+	}
+	return sessions, nil
+}
+
+func (s *fakeStore) AddActiveSession(ctx context.Context, session sptt.ActiveSession) error {
+	s.active[session.AppID] = session
+	return nil
+}
+
+func (s *fakeStore) RemoveActiveSessions(ctx context.Context, steamid sptt.SteamID) error {
+	clear(s.active)
+	return nil
+}
+
+func (s *fakeStore) ConcludeSession(ctx context.Context, session sptt.Session) error {
+	s.concluded = append(s.concluded, session)
+	delete(s.active, session.AppID)
+	return nil
+}
+
+func (s *fakeStore) SetUserActive(ctx context.Context, id sptt.SteamID, active bool) error {
+	s.userActive = active
+	return nil
+}
+
+func (s *fakeStore) TouchActiveSession(ctx context.Context, steamid sptt.SteamID, appid sptt.AppID, t time.Time) error {
+	if sess, ok := s.active[appid]; ok {
+		sess.LastSeen = t
+		s.active[appid] = sess
+	}
+	return nil
+}
+
+func (s *fakeStore) GetAllActiveSessions(ctx context.Context) ([]sptt.ActiveSession, error) {
+	var sessions []sptt.ActiveSession
+	for _, sess := range s.active {
+		sessions = append(sessions, sess)
+	}
+	return sessions, nil
+}
+
+func (s *fakeStore) AddDowntime(ctx context.Context, dt sptt.Downtime) error {
+	s.downtimes = append(s.downtimes, dt)
+	return nil
+}
+
+func (s *fakeStore) GetMetadata(ctx context.Context, key string) (string, error) {
+	return s.meta[key], nil
+}
+
+func (s *fakeStore) SetMetadata(ctx context.Context, key, data string) error {
+	s.meta[key] = data
+	return nil
+}
+
+func int32p(v int32) *int32 { return &v }
+
+func owned(playtime int32) map[sptt.AppID]sptt.GameInfo {
+	return map[sptt.AppID]sptt.GameInfo{gtfo: {AppID: gtfo, Playtime: playtime, Playtime2Weeks: int32p(playtime)}}
+}
+
+func recent(playtime int32) map[sptt.AppID]sptt.RecentGame {
+	return map[sptt.AppID]sptt.RecentGame{gtfo: {AppID: gtfo, Playtime: playtime}}
+}
+
+func TestConcludeSessions(t *testing.T) {
+	start := now.Add(-30 * time.Minute)
+
+	tests := []struct {
+		name     string
+		start    time.Time
+		baseline int32
+		steam    fakeSteam
+		want     *sptt.Session // nil if the session should stay active
+	}{
+		{
+			name:     "Case 2: steam agrees with server",
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(130)},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: 130, PlaytimeSource: sptt.PlaytimeSourceOwnedGames},
+		},
+		{
+			name:     "Case 2: significant difference uses steam's value",
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(110)},
+			want:     &sptt.Session{UTCEnd: start.Add(10 * time.Minute), PlaytimeForever: 110, PlaytimeSource: sptt.PlaytimeSourceOwnedGames},
+		},
+		{
+			name:     "Case 2: difference within tolerance uses server time",
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(128)},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: 128, PlaytimeSource: sptt.PlaytimeSourceOwnedGames},
+		},
+		{
+			name:     "Case 2: no playtime yet is deferred",
+			start:    now.Add(-2 * time.Minute),
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(100)},
+		},
+		{
+			name:     "Case 2: stale zero playtime is concluded with zero length",
+			baseline: 100,
+			steam:    fakeSteam{owned: owned(100)},
+			want:     &sptt.Session{UTCEnd: start, PlaytimeForever: 100, PlaytimeSource: sptt.PlaytimeSourceOwnedGames},
+		},
+		{
+			name:     "Case 2: recently played fallback",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrEmptyGames, recent: recent(130)},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: 130, PlaytimeSource: sptt.PlaytimeSourceRecentlyPlayed},
+		},
+		{
+			name:     "Case 1: private library",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrEmptyGames},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: -1, PlaytimeSource: sptt.PlaytimeSourceNone},
+		},
+		{
+			name:     "Case 1: no baseline and no data",
+			baseline: -1,
+			steam:    fakeSteam{ownedErr: sptt.ErrEmptyGames},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: -1, PlaytimeSource: sptt.PlaytimeSourceNone},
+		},
+		{
+			name:     "Case 1: no baseline, newly added game",
+			baseline: -1,
+			steam:    fakeSteam{owned: owned(31)},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: 31, PlaytimeSource: sptt.PlaytimeSourceOwnedGames},
+		},
+		{
+			name:     "Case 1: no baseline, playtime diverges",
+			baseline: -1,
+			steam:    fakeSteam{owned: owned(500)},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: -1, PlaytimeSource: sptt.PlaytimeSourceNone},
+		},
+		{
+			name:     "Case 3: empty response with fallback",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrEmptyResponse, recent: recent(130)},
+			want:     &sptt.Session{UTCEnd: now, PlaytimeForever: 130, PlaytimeSource: sptt.PlaytimeSourceRecentlyPlayed},
+		},
+		{
+			name:     "Case 3: empty response and failed fallback is deferred",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrEmptyResponse, recentErr: sptt.ErrEmptyResponse},
+		},
+		{
+			name:     "Case 3: empty response and no recently played games is deferred",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrEmptyResponse, recentErr: sptt.ErrEmptyGames},
+		},
+		{
+			name:     "Case 3: empty response and game not recently played is deferred",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrEmptyResponse, recent: map[sptt.AppID]sptt.RecentGame{570: {AppID: 570, Playtime: 130}}},
+		},
+		{
+			name:     "Rate limited is deferred",
+			baseline: 100,
+			steam:    fakeSteam{ownedErr: sptt.ErrRateLimited},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessStart := tt.start
+			if sessStart.IsZero() {
+				sessStart = start
+			}
+			store := newFakeStore(sptt.ActiveSession{SteamID: alice, UTCStart: sessStart, PlaytimeForever: tt.baseline, AppID: gtfo})
+			tr := NewTracker(store, &tt.steam, func() time.Time { return now })
+
+			tr.ProcessUser(context.Background(), alice, sptt.PlayerSummary{SteamID: alice, Visibility: 3})
+
+			if tt.want == nil {
+				if len(store.concluded) != 0 {
+					t.Errorf("Expected no concluded session, got %+v", store.concluded)
+				}
+				if _, ok := store.active[gtfo]; !ok {
+					t.Errorf("Expected session to stay active")
+				}
+				return
+			}
+
+			if len(store.concluded) != 1 {
+				t.Fatalf("Expected 1 concluded session, got %d", len(store.concluded))
+			}
+			if _, ok := store.active[gtfo]; ok {
+				t.Errorf("Expected active session to be removed")
+			}
+
+			want := *tt.want
+			want.SteamID, want.AppID, want.UTCStart = alice, gtfo, sessStart
+			if got := store.concluded[0]; got != want {
+				t.Errorf("Expected %+v, got %+v", want, got)
+			}
+		})
+	}
+}
+
+func TestStartSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *sptt.ActiveSession
+		steam    fakeSteam
+		want     int32 // playtime_forever baseline of the active session
+	}{
+		{
+			name:  "Baseline from owned games",
+			steam: fakeSteam{owned: owned(100)},
+			want:  100,
+		},
+		{
+			name:  "Played before but not in the last 2 weeks",
+			steam: fakeSteam{owned: map[sptt.AppID]sptt.GameInfo{gtfo: {AppID: gtfo, Playtime: 100}}},
+			want:  100,
+		},
+		{
+			name:  "Private library",
+			steam: fakeSteam{ownedErr: sptt.ErrEmptyGames},
+			want:  -1,
+		},
+		{
+			name:  "Never played or hidden playtime",
+			steam: fakeSteam{owned: map[sptt.AppID]sptt.GameInfo{gtfo: {AppID: gtfo}}},
+			want:  -1,
+		},
+		{
+			name:     "Already playing",
+			existing: &sptt.ActiveSession{SteamID: alice, UTCStart: now.Add(-time.Hour), PlaytimeForever: 50, AppID: gtfo},
+			steam:    fakeSteam{owned: owned(100)},
+			want:     50,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			if tt.existing != nil {
+				store.active[gtfo] = *tt.existing
+			}
+			tr := NewTracker(store, &tt.steam, func() time.Time { return now })
+
+			appid := gtfo
+			tr.ProcessUser(context.Background(), alice, sptt.PlayerSummary{SteamID: alice, Visibility: 3, GameID: &appid})
+
+			sess, ok := store.active[gtfo]
+			if !ok {
+				t.Fatalf("Expected active session")
+			}
+			if sess.PlaytimeForever != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, sess.PlaytimeForever)
+			}
+			if tt.existing == nil && !sess.UTCStart.Equal(now) {
+				t.Errorf("Expected start %v, got %v", now, sess.UTCStart)
+			}
+			if tt.existing != nil && !sess.UTCStart.Equal(tt.existing.UTCStart) {
+				t.Errorf("Expected start %v, got %v", tt.existing.UTCStart, sess.UTCStart)
+			}
+			if !sess.LastSeen.Equal(now) {
+				t.Errorf("Expected last seen %v, got %v", now, sess.LastSeen)
+			}
+		})
+	}
+}
+
+// nextEvent waits for the next event on sub
+func nextEvent(t *testing.T, sub *events.Subscription) events.Event {
+	t.Helper()
+	select {
+	case e := <-sub.C:
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("Expected an event, got none")
+		return nil
+	}
+}
+
+func TestSessionEvents(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe()
+	defer sub.Close()
+
+	store := newFakeStore()
+	steam := &fakeSteam{owned: owned(100)}
+	clock := now
+	cfg := DefaultConfig()
+	cfg.Events = bus
+	tr := NewTrackerWithConfig(store, steam, func() time.Time { return clock }, cfg)
+
+	appid := gtfo
+	tr.ProcessUser(context.Background(), alice, sptt.PlayerSummary{SteamID: alice, Visibility: 3, GameID: &appid})
+	started, ok := nextEvent(t, sub).(events.SessionStarted)
+	if !ok || started.Session.SteamID != alice || !started.Session.UTCStart.Equal(now) {
+		t.Errorf("Expected SessionStarted for %v at %v, got %+v", alice, now, started)
+	}
+
+	clock = now.Add(30 * time.Minute)
+	steam.owned = owned(130)
+	tr.ProcessUser(context.Background(), alice, sptt.PlayerSummary{SteamID: alice, Visibility: 3})
+	concluded, ok := nextEvent(t, sub).(events.SessionConcluded)
+	if !ok || concluded.Session.PlaytimeForever != 130 || !concluded.Session.UTCEnd.Equal(clock) {
+		t.Errorf("Expected SessionConcluded ending at %v, got %+v", clock, concluded)
+	}
+}
+
+func TestPrivateProfile(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe(events.TypeProfileWentPrivate)
+	defer sub.Close()
+
+	store := newFakeStore(sptt.ActiveSession{SteamID: alice, UTCStart: now.Add(-time.Hour), PlaytimeForever: 100, AppID: gtfo})
+	cfg := DefaultConfig()
+	cfg.Events = bus
+	tr := NewTrackerWithConfig(store, &fakeSteam{}, func() time.Time { return now }, cfg)
+
+	if !tr.ProcessUser(context.Background(), alice, sptt.PlayerSummary{SteamID: alice, Visibility: 1}) {
+		t.Errorf("Expected user list reload")
+	}
+	if len(store.active) != 0 {
+		t.Errorf("Expected active sessions to be released, got %d", len(store.active))
+	}
+	if store.userActive {
+		t.Errorf("Expected user to be deactivated")
+	}
+	if e, ok := nextEvent(t, sub).(events.ProfileWentPrivate); !ok || e.SteamID != alice {
+		t.Errorf("Expected ProfileWentPrivate for %v, got %+v", alice, e)
+	}
+}
+
+// slowStore records how many calls run at once, overall and per user
+type slowStore struct {
+	fakeStore
+	mu      sync.Mutex
+	running int
+	maxRun  int
+	perUser map[sptt.SteamID]int
+	overlap bool
+}
+
+func (s *slowStore) GetActiveSessions(ctx context.Context, id sptt.SteamID) (map[sptt.AppID]sptt.ActiveSession, error) {
+	s.mu.Lock()
+	s.running++
+	s.maxRun = max(s.maxRun, s.running)
+	s.perUser[id]++
+	s.overlap = s.overlap || s.perUser[id] > 1
+	s.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	s.mu.Lock()
+	s.running--
+	s.perUser[id]--
+	s.mu.Unlock()
+	return nil, nil
+}
+
+func TestProcessUsers(t *testing.T) {
+	store := &slowStore{perUser: make(map[sptt.SteamID]int)}
+	tr := NewTracker(store, &fakeSteam{}, func() time.Time { return now })
+
+	summaries := make(map[sptt.SteamID]sptt.PlayerSummary)
+	for i := 0; i < 20; i++ {
+		id := alice + sptt.SteamID(i)
+		summaries[id] = sptt.PlayerSummary{SteamID: id, Visibility: 3}
+	}
+
+	processed, deactivated := tr.ProcessUsers(context.Background(), summaries, 3)
+	if processed != 20 {
+		t.Errorf("Expected 20, got %d", processed)
+	}
+	if deactivated {
+		t.Errorf("Expected no deactivation")
+	}
+	if store.maxRun > 3 {
+		t.Errorf("Expected at most 3 concurrent users, got %d", store.maxRun)
+	}
+
+	t.Run("Same user is serialized", func(t *testing.T) {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tr.ProcessUser(context.Background(), alice, sptt.PlayerSummary{SteamID: alice, Visibility: 3})
+			}()
+		}
+		wg.Wait()
+		if store.overlap {
+			t.Errorf("Expected user to never be processed twice at once")
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/log"
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+)
+
+const (
+	DefaultMaxAttempts   = 8
+	DefaultBackoff       = 30 * time.Second // before the first retry, doubled for every further one
+	DefaultMaxBackoff    = time.Hour
+	DefaultRetryInterval = 15 * time.Second // how often the queue is checked for due retries
+	DefaultRetention     = 30 * 24 * time.Hour
+	DefaultTimeout       = 10 * time.Second
+
+	// Maximum deliveries attempted per queue check
+	deliveryBatchSize = 50
+	// How often finished deliveries older than the retention are deleted
+	pruneInterval = time.Hour
+)
+
+// Store is the part of sptt.Store the webhooks need.
+type Store interface {
+	GetWebhooks(ctx context.Context) ([]sptt.Webhook, error)
+	GetWebhook(ctx context.Context, id int64) (sptt.Webhook, error)
+	AddWebhookDelivery(ctx context.Context, w sptt.WebhookDelivery) (int64, error)
+	UpdateWebhookDelivery(ctx context.Context, w sptt.WebhookDelivery) error
+	GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]sptt.WebhookDelivery, error)
+	PruneWebhookDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+type Config struct {
+	Client        *http.Client
+	MaxAttempts   int
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	RetryInterval time.Duration
+	Retention     time.Duration // how long the delivery log is kept
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Client:        &http.Client{Timeout: DefaultTimeout},
+		MaxAttempts:   DefaultMaxAttempts,
+		Backoff:       DefaultBackoff,
+		MaxBackoff:    DefaultMaxBackoff,
+		RetryInterval: DefaultRetryInterval,
+		Retention:     DefaultRetention,
+	}
+}
+
+// Dispatcher queues a delivery for every webhook matching a session event
+// on the bus and works through the queue, retrying failed deliveries with
+// exponential backoff. The queue lives in the database, deliveries pending
+// when the dispatcher stops are picked up by the next one.
+type Dispatcher struct {
+	ctx   context.Context
+	store Store
+	bus   *events.Bus
+	wg    *sync.WaitGroup
+	cfg   Config
+	now   func() time.Time
+}
+
+func NewDispatcher(ctx context.Context, store Store, bus *events.Bus, wg *sync.WaitGroup) *Dispatcher {
+	return NewDispatcherWithConfig(ctx, store, bus, wg, DefaultConfig())
+}
+
+func NewDispatcherWithConfig(ctx context.Context, store Store, bus *events.Bus, wg *sync.WaitGroup, cfg Config) *Dispatcher {
+	def := DefaultConfig()
+	if cfg.Client == nil {
+		cfg.Client = def.Client
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = def.Backoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = def.MaxBackoff
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = def.RetryInterval
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = def.Retention
+	}
+	return &Dispatcher{
+		ctx:   ctx,
+		store: store,
+		bus:   bus,
+		wg:    wg,
+		cfg:   cfg,
+		now:   time.Now,
+	}
+}
+
+// Run delivers events until the context is cancelled.
+func (d *Dispatcher) Run() {
+	defer d.wg.Done()
+
+	sub := d.bus.Subscribe(EventTypes...)
+	defer sub.Close()
+
+	ticker := time.NewTicker(d.cfg.RetryInterval)
+	defer ticker.Stop()
+
+	var lastPrune time.Time
+	for {
+		if d.now().Sub(lastPrune) >= pruneInterval {
+			d.prune()
+			lastPrune = d.now()
+		}
+		d.deliverDue()
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			d.enqueue(e)
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueue queues a delivery of e for every matching webhook.
+func (d *Dispatcher) enqueue(e events.Event) {
+	now := d.now()
+	p, steamid, ok := NewPayload(e, now)
+	if !ok {
+		return
+	}
+
+	webhooks, err := d.store.GetWebhooks(d.ctx)
+	if err != nil {
+		log.Error("Error while trying to get webhooks: ", err)
+		return
+	}
+
+	var payload string
+	for _, w := range webhooks {
+		if !Matches(w, p.Type, steamid) {
+			continue
+		}
+		if payload == "" {
+			if payload, err = marshalPayload(p); err != nil {
+				log.Errorf("Error while encoding %s webhook payload: %v", p.Type, err)
+				return
+			}
+		}
+		_, err := d.store.AddWebhookDelivery(d.ctx, sptt.WebhookDelivery{
+			WebhookID:   w.ID,
+			EventType:   p.Type,
+			Payload:     payload,
+			Status:      sptt.WebhookPending,
+			NextAttempt: now,
+		})
+		if err != nil {
+			log.Errorf("Error while queueing %s delivery for webhook %d: %v", p.Type, w.ID, err)
+		}
+	}
+}
+
+// deliverDue attempts up to deliveryBatchSize deliveries that are due, the
+// rest wait for the next check.
+func (d *Dispatcher) deliverDue() {
+	due, err := d.store.GetDueWebhookDeliveries(d.ctx, d.now(), deliveryBatchSize)
+	if err != nil {
+		log.Error("Error while trying to get due webhook deliveries: ", err)
+		return
+	}
+	for _, delivery := range due {
+		if d.ctx.Err() != nil {
+			return
+		}
+		d.attempt(delivery)
+	}
+}
+
+// attempt sends delivery once and records the outcome, scheduling a retry
+// or giving up after MaxAttempts.
+func (d *Dispatcher) attempt(delivery sptt.WebhookDelivery) {
+	w, err := d.store.GetWebhook(d.ctx, delivery.WebhookID)
+	if errors.Is(err, sptt.ErrWebhookNotFound) {
+		delivery.Status = sptt.WebhookFailed
+		delivery.LastError = "webhook removed"
+		d.update(delivery)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error while trying to get webhook %d: %v", delivery.WebhookID, err)
+		return
+	}
+
+	now := d.now()
+	code, err := Send(d.ctx, d.cfg.Client, w, delivery, now)
+	if d.ctx.Err() != nil {
+		// Shutting down, the attempt is repeated by the next dispatcher
+		return
+	}
+	delivery.Attempts++
+	delivery.StatusCode = code
+
+	switch {
+	case err == nil:
+		delivery.Status = sptt.WebhookDelivered
+		delivery.LastError = ""
+	case delivery.Attempts >= d.cfg.MaxAttempts:
+		delivery.Status = sptt.WebhookFailed
+		delivery.LastError = err.Error()
+		log.Warnf("Giving up on delivery %d to webhook %d after %d attempts: %v", delivery.ID, w.ID, delivery.Attempts, err)
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+		log.Debugf("Delivery %d to webhook %d failed, retrying at %v: %v", delivery.ID, w.ID, delivery.NextAttempt, err)
+	}
+	d.update(delivery)
+}
+
+func (d *Dispatcher) update(delivery sptt.WebhookDelivery) {
+	if err := d.store.UpdateWebhookDelivery(d.ctx, delivery); err != nil {
+		log.Errorf("Error while recording delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// backoff returns the wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.Backoff
+	for i := 1; i < attempts && wait < d.cfg.MaxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, d.cfg.MaxBackoff)
+}
+
+func (d *Dispatcher) prune() {
+	n, err := d.store.PruneWebhookDeliveries(d.ctx, d.now().Add(-d.cfg.Retention))
+	if err != nil {
+		log.Error("Error while pruning webhook deliveries: ", err)
+		return
+	}
+	if n > 0 {
+		log.Debugf("Pruned %d webhook deliveries", n)
+	}
+}
+
+// Ping sends a test delivery to w right away, without retries, and
+// returns it with the outcome. The delivery shows up in the log.
+func Ping(ctx context.Context, store Store, client *http.Client, w sptt.Webhook) (sptt.WebhookDelivery, error) {
+	now := time.Now()
+	payload, err := marshalPayload(Payload{Type: TypePing, Timestamp: now.UTC().Truncate(time.Second)})
+	if err != nil {
+		return sptt.WebhookDelivery{}, err
+	}
+
+	// Logged as failed until the attempt succeeds, a pending ping would be
+	// sent a second time by the dispatcher
+	delivery := sptt.WebhookDelivery{
+		WebhookID:   w.ID,
+		EventType:   TypePing,
+		Payload:     payload,
+		Status:      sptt.WebhookFailed,
+		NextAttempt: now,
+		LastError:   "not sent",
+	}
+	delivery.ID, err = store.AddWebhookDelivery(ctx, delivery)
+	if err != nil {
+		return delivery, err
+	}
+
+	code, err := Send(ctx, client, w, delivery, now)
+	delivery.Attempts = 1
+	delivery.StatusCode = code
+	if err == nil {
+		delivery.Status = sptt.WebhookDelivered
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = err.Error()
+	}
+	return delivery, store.UpdateWebhookDelivery(ctx, delivery)
+}
@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/sptttest"
+)
+
+const (
+	alice = sptttest.Alice
+	bob   = sptttest.Bob
+	gtfo  = sptttest.GTFO
+)
+
+// receiver verifies signatures and answers with the given status codes in
+// turn, repeating the last one.
+type receiver struct {
+	t        *testing.T
+	secret   string
+	statuses []int
+	calls    atomic.Int32
+	payloads chan Payload
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	timestamp, _ := strconv.ParseInt(req.Header.Get("X-Sptt-Timestamp"), 10, 64)
+	if !Verify(r.secret, timestamp, body, req.Header.Get("X-Sptt-Signature")) {
+		r.t.Errorf("Expected a valid signature, got %q", req.Header.Get("X-Sptt-Signature"))
+	}
+
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		r.t.Errorf("Expected JSON, got %q", body)
+	}
+	if req.Header.Get("X-Sptt-Event") != p.Type {
+		r.t.Errorf("Expected X-Sptt-Event %s, got %s", p.Type, req.Header.Get("X-Sptt-Event"))
+	}
+
+	n := int(r.calls.Add(1)) - 1
+	w.WriteHeader(r.statuses[min(n, len(r.statuses)-1)])
+	r.payloads <- p
+}
+
+func TestDispatcher(t *testing.T) {
+	store := sptttest.NewSQLiteStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recv := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusInternalServerError, http.StatusNoContent}, payloads: make(chan Payload, 10)}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	id, err := store.AddWebhook(ctx, sptt.Webhook{URL: srv.URL, Secret: recv.secret, EventTypes: []string{string(events.TypeSessionStarted)}, SteamIDs: []sptt.SteamID{alice}})
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+
+	bus := events.NewBus()
+	wg := sync.WaitGroup{}
+	d := NewDispatcherWithConfig(ctx, store, bus, &wg, Config{Backoff: time.Millisecond, RetryInterval: 10 * time.Millisecond})
+	wg.Add(1)
+	go d.Run()
+	defer wg.Wait()
+	defer cancel()
+
+	for bus.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+	bus.Publish(events.SessionStarted{Session: sptt.ActiveSession{SteamID: bob, AppID: gtfo, UTCStart: start}})
+	bus.Publish(events.SessionConcluded{Session: sptt.Session{SteamID: alice, AppID: gtfo, UTCStart: start}})
+	bus.Publish(events.SessionStarted{Session: sptt.ActiveSession{SteamID: alice, AppID: gtfo, UTCStart: start, PlaytimeForever: 60}})
+
+	// The first attempt fails, the retry succeeds
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-recv.payloads:
+			if p.Type != string(events.TypeSessionStarted) || p.SteamID != uint64(alice) {
+				t.Errorf("Expected session_started for %v, got %+v", alice, p)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected attempt %d, got none", i+1)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	var log []sptt.WebhookDelivery
+	for time.Now().Before(deadline) {
+		log, err = store.GetWebhookDeliveries(ctx, id, 10, 0)
+		if err != nil {
+			t.Fatalf("Expected nil, got %v", err)
+		}
+		if len(log) == 1 && log[0].Status == sptt.WebhookDelivered {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(log) != 1 || log[0].Status != sptt.WebhookDelivered || log[0].Attempts != 2 || log[0].StatusCode != http.StatusNoContent {
+		t.Errorf("Expected one delivery delivered on the second attempt, got %+v", log)
+	}
+	if n := recv.calls.Load(); n != 2 {
+		t.Errorf("Expected 2 requests, got %d", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := NewDispatcherWithConfig(context.Background(), nil, nil, nil, Config{Backoff: time.Minute, MaxBackoff: 10 * time.Minute})
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{60, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempts); got != tt.want {
+			t.Errorf("Expected %v after %d attempts, got %v", tt.want, tt.attempts, got)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	store := sptttest.NewSQLiteStore(t)
+	ctx := context.Background()
+
+	recv := &receiver{t: t, secret: "s3cret", statuses: []int{http.StatusGone}, payloads: make(chan Payload, 1)}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+
+	w := sptt.Webhook{URL: srv.URL, Secret: recv.secret}
+	w.ID, _ = store.AddWebhook(ctx, w)
+
+	delivery, err := Ping(ctx, store, srv.Client(), w)
+	if err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if delivery.Status != sptt.WebhookFailed || delivery.StatusCode != http.StatusGone {
+		t.Errorf("Expected failed delivery with 410, got %+v", delivery)
+	}
+	if p := <-recv.payloads; p.Type != TypePing {
+		t.Errorf("Expected %s, got %s", TypePing, p.Type)
+	}
+
+	// Failed pings are logged but not retried
+	if due, _ := store.GetDueWebhookDeliveries(ctx, time.Now().Add(time.Hour), 10); len(due) != 0 {
+		t.Errorf("Expected no due deliveries, got %+v", due)
+	}
+	if log, _ := store.GetWebhookDeliveries(ctx, w.ID, 10, 0); len(log) != 1 || log[0].ID != delivery.ID {
+		t.Errorf("Expected the ping in the log, got %+v", log)
+	}
+}
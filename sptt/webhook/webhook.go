@@ -0,0 +1,174 @@
+// Package webhook delivers session events to the webhooks registered by
+// admins, as JSON POSTs signed with the webhook's secret.
+//
+// Every request carries these headers:
+//
+//	X-Sptt-Event:     event type, e.g. session_started
+//	X-Sptt-Delivery:  delivery id, the same across retries
+//	X-Sptt-Timestamp: unix seconds the request was signed at
+//	X-Sptt-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// Receivers should recompute the signature and reject old timestamps.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sebun1/steamPlaytimeTracker/sptt"
+	"github.com/sebun1/steamPlaytimeTracker/sptt/events"
+)
+
+// TypePing is the event type of test deliveries.
+const TypePing = "ping"
+
+// EventTypes are the events a webhook can subscribe to.
+var EventTypes = []events.Type{
+	events.TypeSessionStarted,
+	events.TypeSessionConcluded,
+}
+
+// IsEventType reports whether a webhook can subscribe to t.
+func IsEventType(t string) bool {
+	for _, et := range EventTypes {
+		if string(et) == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"` // when the event happened
+	SteamID   uint64    `json:"steam_id,omitempty"`
+	Session   any       `json:"session,omitempty"`
+}
+
+type sessionPayload struct {
+	AppID           uint32 `json:"app_id"`
+	UTCStart        string `json:"utc_start"`
+	UTCEnd          string `json:"utc_end,omitempty"` // empty while active
+	PlaytimeForever int32  `json:"playtime_forever"`
+	PlaytimeSource  string `json:"playtime_source,omitempty"`
+	Recovered       bool   `json:"recovered,omitempty"`
+}
+
+// NewPayload converts e into a payload, false if e is not a webhook event.
+func NewPayload(e events.Event, now time.Time) (Payload, sptt.SteamID, bool) {
+	p := Payload{Type: string(e.Type()), Timestamp: now.UTC().Truncate(time.Second)}
+
+	var steamid sptt.SteamID
+	switch e := e.(type) {
+	case events.SessionStarted:
+		steamid = e.Session.SteamID
+		p.Session = sessionPayload{
+			AppID:           uint32(e.Session.AppID),
+			UTCStart:        e.Session.UTCStart.UTC().Format(time.RFC3339),
+			PlaytimeForever: e.Session.PlaytimeForever,
+		}
+	case events.SessionConcluded:
+		steamid = e.Session.SteamID
+		p.Session = sessionPayload{
+			AppID:           uint32(e.Session.AppID),
+			UTCStart:        e.Session.UTCStart.UTC().Format(time.RFC3339),
+			UTCEnd:          e.Session.UTCEnd.UTC().Format(time.RFC3339),
+			PlaytimeForever: e.Session.PlaytimeForever,
+			PlaytimeSource:  string(e.Session.PlaytimeSource),
+			Recovered:       e.Session.Recovered,
+		}
+	default:
+		return p, 0, false
+	}
+	p.SteamID = uint64(steamid)
+	return p, steamid, true
+}
+
+// Matches reports whether w subscribes to events of type t for steamid.
+func Matches(w sptt.Webhook, t string, steamid sptt.SteamID) bool {
+	if len(w.EventTypes) > 0 && !contains(w.EventTypes, t) {
+		return false
+	}
+	if len(w.SteamIDs) > 0 && !contains(w.SteamIDs, steamid) {
+		return false
+	}
+	return true
+}
+
+func contains[T comparable](s []T, v T) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateSecret returns a random secret for a new webhook.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the X-Sptt-Signature value of body signed at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is valid for body signed at timestamp.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Send makes one attempt at delivering d to w. Any 2xx response counts as
+// delivered. The status code is 0 if there was no response.
+func Send(ctx context.Context, client *http.Client, w sptt.Webhook, d sptt.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "steamPlaytimeTracker-Webhook")
+	req.Header.Set("X-Sptt-Event", d.EventType)
+	req.Header.Set("X-Sptt-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Sptt-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Sptt-Signature", Sign(w.Secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func marshalPayload(p Payload) (string, error) {
+	b, err := json.Marshal(p)
+	return string(b), err
+}